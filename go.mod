@@ -2,7 +2,11 @@ module github.com/epuerta9/smolagents-go
 
 go 1.24.1
 
-require github.com/openai/openai-go v0.1.0-alpha.62
+require (
+	github.com/mattn/go-sqlite3 v1.14.32
+	github.com/openai/openai-go v0.1.0-alpha.62
+	golang.org/x/time v0.12.0
+)
 
 require (
 	github.com/tidwall/gjson v1.14.4 // indirect
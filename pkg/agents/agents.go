@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/epuerta9/smolagents-go/pkg/memory"
 	"github.com/epuerta9/smolagents-go/pkg/models"
@@ -27,6 +29,200 @@ func WithMaxSteps(maxSteps int) Option {
 	}
 }
 
+// StepLimitBehavior controls what Run does when the agent exhausts its step budget
+// without producing a final answer.
+type StepLimitBehavior int
+
+const (
+	// StepLimitError makes Run return an error when max steps is reached.
+	StepLimitError StepLimitBehavior = iota
+	// StepLimitPartial makes Run return the last model response as the answer.
+	StepLimitPartial
+	// StepLimitForceAnswer makes Run issue one last generation, with tools
+	// disabled, asking the model for its best answer so far.
+	StepLimitForceAnswer
+)
+
+// forceAnswerPrompt is sent to the model when the step limit is reached and
+// the agent is configured with StepLimitForceAnswer.
+const forceAnswerPrompt = "You have reached the maximum number of steps. " +
+	"Do not call any more tools. Based on the information gathered so far, " +
+	"respond with your best final answer to the original task."
+
+// WithStepLimitBehavior sets what the agent does when it reaches max steps.
+func WithStepLimitBehavior(behavior StepLimitBehavior) Option {
+	return func(a *BaseAgent) error {
+		switch behavior {
+		case StepLimitError, StepLimitPartial, StepLimitForceAnswer:
+		default:
+			return fmt.Errorf("unknown step limit behavior: %d", behavior)
+		}
+		a.stepLimitBehavior = behavior
+		return nil
+	}
+}
+
+// ToolDescriptionPolicy controls on which steps the tool descriptions are
+// included in the prompt.
+type ToolDescriptionPolicy int
+
+const (
+	// ToolDescriptionsEveryStep includes the tool descriptions on every step.
+	// This is the default and suits models that call tools through the prompt.
+	ToolDescriptionsEveryStep ToolDescriptionPolicy = iota
+	// ToolDescriptionsFirstStep includes the tool descriptions on the first
+	// step of a run only.
+	ToolDescriptionsFirstStep
+	// ToolDescriptionsNever never includes the tool descriptions, for models
+	// that receive the tools natively.
+	ToolDescriptionsNever
+)
+
+// WithToolDescriptionPolicy sets on which steps the tool descriptions are
+// included in the prompt.
+func WithToolDescriptionPolicy(policy ToolDescriptionPolicy) Option {
+	return func(a *BaseAgent) error {
+		switch policy {
+		case ToolDescriptionsEveryStep, ToolDescriptionsFirstStep, ToolDescriptionsNever:
+		default:
+			return fmt.Errorf("unknown tool description policy: %d", policy)
+		}
+		a.toolDescriptionPolicy = policy
+		return nil
+	}
+}
+
+// UnknownToolBehavior controls what the agent does when the model calls a
+// tool that does not exist.
+type UnknownToolBehavior int
+
+const (
+	// AbortOnUnknownTool makes the run fail with ErrToolNotFound.
+	AbortOnUnknownTool UnknownToolBehavior = iota
+	// FeedbackOnUnknownTool tells the model that the tool does not exist and
+	// lists the available tools, then continues the run. After
+	// MaxUnknownToolFeedback such calls in a run, the run fails as with
+	// AbortOnUnknownTool.
+	FeedbackOnUnknownTool
+)
+
+// MaxUnknownToolFeedback is the number of unknown tool calls per run that
+// FeedbackOnUnknownTool answers with feedback before aborting.
+const MaxUnknownToolFeedback = 3
+
+// ErrToolNotFound is returned when the model calls a tool that does not exist.
+var ErrToolNotFound = errors.New("tool not found")
+
+// WithUnknownToolBehavior sets what the agent does when the model calls a
+// tool that does not exist.
+func WithUnknownToolBehavior(behavior UnknownToolBehavior) Option {
+	return func(a *BaseAgent) error {
+		switch behavior {
+		case AbortOnUnknownTool, FeedbackOnUnknownTool:
+		default:
+			return fmt.Errorf("invalid unknown tool behavior: %d", behavior)
+		}
+		a.unknownToolBehavior = behavior
+		return nil
+	}
+}
+
+// WithTokenCounter sets the token counter used to estimate prompt and
+// response sizes recorded on each step.
+func WithTokenCounter(counter models.TokenCounter) Option {
+	return func(a *BaseAgent) error {
+		if counter == nil {
+			return errors.New("token counter cannot be nil")
+		}
+		a.tokenCounter = counter
+		return nil
+	}
+}
+
+// WithToolResultDigest enables injecting a compact table of previous tool
+// results into the prompt each step, so the model can reuse earlier results
+// instead of calling the same tools again.
+func WithToolResultDigest(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.toolResultDigest = enabled
+		return nil
+	}
+}
+
+// WithIDGenerator sets the generator used for run, step and tool call IDs.
+func WithIDGenerator(ids memory.IDGenerator) Option {
+	return func(a *BaseAgent) error {
+		if ids == nil {
+			return errors.New("ID generator cannot be nil")
+		}
+		a.ids = ids
+		return nil
+	}
+}
+
+// WithAnswerMarkdown sets whether the final answer keeps its markdown
+// formatting. When disabled, string answers are converted to plain text
+// before being returned, which suits terminal output.
+func WithAnswerMarkdown(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.answerMarkdown = enabled
+		return nil
+	}
+}
+
+// WithLenientJSON sets whether malformed JSON tool calls (trailing commas,
+// single quotes, unquoted keys) are repaired before parsing. Enabled by default.
+func WithLenientJSON(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.lenientJSON = enabled
+		return nil
+	}
+}
+
+// WithStrictOutputValidation sets whether a tool output that does not match
+// the tool's declared output schema fails the step. By default a mismatch
+// only adds a warning observation for the model.
+func WithStrictOutputValidation(strict bool) Option {
+	return func(a *BaseAgent) error {
+		a.strictOutputValidation = strict
+		return nil
+	}
+}
+
+// WithToolRetries retries failed tool executions up to retries times.
+// Tools declared non-idempotent are never re-invoked; their failure is
+// surfaced instead.
+func WithToolRetries(retries int) Option {
+	return func(a *BaseAgent) error {
+		if retries < 0 {
+			return errors.New("tool retries must not be negative")
+		}
+		a.toolRetries = retries
+		return nil
+	}
+}
+
+// WithStopCondition sets a predicate consulted after every step that did not
+// produce a final answer. When it reports stop, the run ends with the given
+// answer, before the step limit is reached.
+func WithStopCondition(condition func(m *memory.Memory) (stop bool, answer any)) Option {
+	return func(a *BaseAgent) error {
+		a.stopCondition = condition
+		return nil
+	}
+}
+
+// WithGenerationParams sets agent-level generation settings for every model
+// call the agent makes. They override the model's own settings and are
+// overridden by per-call settings carried by the run context; see
+// models.GenerationParams.
+func WithGenerationParams(params models.GenerationParams) Option {
+	return func(a *BaseAgent) error {
+		a.generationParams = params
+		return nil
+	}
+}
+
 // WithSystemPrompt sets the system prompt for the agent.
 func WithSystemPrompt(systemPrompt string) Option {
 	return func(a *BaseAgent) error {
@@ -35,6 +231,17 @@ func WithSystemPrompt(systemPrompt string) Option {
 	}
 }
 
+// WithSystemPromptFragments appends fragments, such as tool instructions,
+// dynamic context, or safety rules, to the system prompt. The system message
+// is the system prompt followed by each non-empty fragment, in order,
+// separated by blank lines.
+func WithSystemPromptFragments(fragments ...string) Option {
+	return func(a *BaseAgent) error {
+		a.systemPromptFragments = append(a.systemPromptFragments, fragments...)
+		return nil
+	}
+}
+
 // WithName sets the name of the agent.
 func WithName(name string) Option {
 	return func(a *BaseAgent) error {
@@ -85,6 +292,109 @@ type BaseAgent struct {
 	name         string
 	description  string
 	stepper      Stepper
+
+	stepLimitBehavior StepLimitBehavior
+	tokenCounter      models.TokenCounter
+	toolResultDigest  bool
+	ids               memory.IDGenerator
+	answerMarkdown    bool
+	lenientJSON       bool
+	maxExposedTools   int
+	toolScorer        ToolScorer
+
+	strictOutputValidation bool
+	debugDumpDir           string
+	summarizer             models.Model
+	summaryThreshold       int
+	summarizationPrompt    string
+	summaryMaxTokens       int
+	argRepairModel         models.Model
+	tokenCallback          func(delta string)
+	heldDeltas             []string
+	toolRetries            int
+	generationParams       models.GenerationParams
+	systemPromptFragments  []string
+	pinnedInstructions     []string
+	finalAnswerTemperature *float64
+	toolDescriptionPolicy  ToolDescriptionPolicy
+	stopCondition          func(m *memory.Memory) (stop bool, answer any)
+	answerMarkers          []string
+	maxModelCalls          int
+	unknownToolBehavior    UnknownToolBehavior
+	toolResultDedup        bool
+	toolTiming             bool
+	maxCodeBlocks          int
+	executor               Executor
+	autoToolFallback       bool
+	toolsBeforeFinalAnswer bool
+	stepCallback           func(step *memory.ActionStep)
+	toolCallCallback       func(call memory.ToolCall)
+	timeout                time.Duration
+	planningInterval       int
+	maxPlanningSteps       int
+	codeExecutor           CodeExecutor
+
+	// toolEnvelopes caches the JSON schema of each tool, by name.
+	toolEnvelopes map[string]map[string]any
+
+	// toolsUnsupported records that the model rejected native tools, so
+	// later calls go straight to the prompt-based fallback.
+	toolsUnsupported bool
+
+	// currentPlan is the latest planning step of the current run.
+	currentPlan *memory.PlanningStep
+
+	// planningSteps counts the planning steps of the current run.
+	planningSteps int
+
+	// keepMemory makes runs continue the conversation in memory instead of
+	// starting with an empty memory.
+	keepMemory bool
+
+	// task is the task of the current run.
+	task string
+
+	// modelCalls counts the model calls of the current run.
+	modelCalls int
+
+	// unknownToolCalls counts the calls to unknown tools in the current run.
+	unknownToolCalls int
+
+	// seenResults maps the tool results of the current run to the step that
+	// first produced them.
+	seenResults map[string]int
+
+	// trace holds the action steps of the current run.
+	trace []*memory.ActionStep
+}
+
+// RunResult holds the outcome of a run along with its step trace.
+type RunResult struct {
+	// RunID identifies the run.
+	RunID string
+
+	// FinalAnswer is the answer produced by the run.
+	FinalAnswer any
+
+	// Steps are the action steps executed during the run, in order.
+	Steps []memory.Step
+
+	// Sizes aggregates the approximate prompt and response sizes of
+	// every model call made during the run.
+	Sizes memory.SizeMetrics
+
+	// TokenUsage aggregates the token usage reported by the provider for
+	// the model calls of the steps. It is only counted for models
+	// implementing models.DetailedModel, on calls without native tools.
+	TokenUsage memory.TokenUsage
+
+	// ModelLatency is the total wall-clock time spent waiting on the model,
+	// excluding tool execution.
+	ModelLatency time.Duration
+
+	// ToolUsage is the number of calls of each tool during the run, keyed by
+	// tool name.
+	ToolUsage map[string]int
 }
 
 // Stepper is an interface for executing agent steps.
@@ -102,21 +412,45 @@ func NewBaseAgent(tools []tools.Tool, model models.Model, opts ...Option) (*Base
 		return nil, errors.New("model is required")
 	}
 
+	// A tool shadowed by another of the same name could never be called
+	names := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		if names[tool.Name()] {
+			return nil, fmt.Errorf("duplicate tool name: %s", tool.Name())
+		}
+		names[tool.Name()] = true
+	}
+
 	agent := &BaseAgent{
-		tools:        tools,
-		model:        model,
-		memory:       memory.NewMemory(),
-		maxSteps:     20, // Default max steps
-		systemPrompt: "You are a helpful assistant that can use tools to help the user.",
-		name:         "BaseAgent",
-		description:  "A base agent implementation",
+		tools:          withFinalAnswerTool(tools),
+		model:          model,
+		memory:         memory.NewMemory(),
+		maxSteps:       20, // Default max steps
+		systemPrompt:   "You are a helpful assistant that can use tools to help the user.",
+		name:           "BaseAgent",
+		description:    "A base agent implementation",
+		tokenCounter:   models.DefaultTokenCounter,
+		ids:            memory.RandomIDGenerator{},
+		answerMarkdown: true,
+		lenientJSON:    true,
+		toolScorer:     KeywordToolScorer,
+		executor:       LocalExecutor{},
+
+		summaryThreshold:    DefaultToolResultSummaryThreshold,
+		summarizationPrompt: DefaultSummarizationPrompt,
+		summaryMaxTokens:    DefaultSummaryMaxTokens,
 	}
 
+	// Apply every option so that all misconfigurations are reported at once
+	var optErrs []error
 	for _, opt := range opts {
 		if err := opt(agent); err != nil {
-			return nil, fmt.Errorf("error applying option: %w", err)
+			optErrs = append(optErrs, err)
 		}
 	}
+	if len(optErrs) > 0 {
+		return nil, fmt.Errorf("error applying options: %w", errors.Join(optErrs...))
+	}
 
 	return agent, nil
 }
@@ -131,6 +465,54 @@ func (a *BaseAgent) GetTools() []tools.Tool {
 	return a.tools
 }
 
+// AddTool makes tool available to the agent from its next step on. It fails
+// if the agent already has a tool of the same name. Tools must not be added
+// or removed while the agent is running.
+func (a *BaseAgent) AddTool(tool tools.Tool) error {
+	if tool == nil {
+		return errors.New("tool cannot be nil")
+	}
+
+	name := tool.Name()
+	if name == "" {
+		return errors.New("tool name cannot be empty")
+	}
+	if _, err := a.findTool(name); err == nil {
+		return fmt.Errorf("duplicate tool name: %s", name)
+	}
+
+	// Keep the final_answer tool last, as it is registered
+	updated := make([]tools.Tool, 0, len(a.tools)+1)
+	at := len(a.tools)
+	if at > 0 && a.tools[at-1].Name() == tools.FinalAnswerToolName {
+		at--
+	}
+	updated = append(updated, a.tools[:at]...)
+	updated = append(updated, tool)
+	a.tools = append(updated, a.tools[at:]...)
+
+	delete(a.toolEnvelopes, name)
+	return nil
+}
+
+// RemoveTool removes the tool with the given name from the agent, from its
+// next step on, and reports whether the agent had such a tool.
+func (a *BaseAgent) RemoveTool(name string) bool {
+	for i, tool := range a.tools {
+		if tool.Name() != name {
+			continue
+		}
+
+		updated := make([]tools.Tool, 0, len(a.tools)-1)
+		updated = append(updated, a.tools[:i]...)
+		a.tools = append(updated, a.tools[i+1:]...)
+
+		delete(a.toolEnvelopes, name)
+		return true
+	}
+	return false
+}
+
 // GetMemory returns the agent's memory.
 func (a *BaseAgent) GetMemory() *memory.Memory {
 	return a.memory
@@ -153,18 +535,70 @@ func (a *BaseAgent) GetDescription() string {
 
 // Run runs the agent on the given task.
 func (a *BaseAgent) Run(ctx context.Context, task string) (any, error) {
-	// Initialize the memory
-	a.memory = memory.NewMemory()
+	result, err := a.RunWithTrace(ctx, task)
+	return result.FinalAnswer, err
+}
 
-	// Add the system prompt to memory
-	systemMessages := []models.Message{
-		{
-			Role:    models.RoleSystem,
-			Content: a.systemPrompt,
-		},
+// RunWithTrace runs the agent on the given task and returns the final answer
+// together with the trace of executed steps. The result is non-nil even when
+// an error is returned, so the partial trace can be inspected.
+func (a *BaseAgent) RunWithTrace(ctx context.Context, task string) (*RunResult, error) {
+	return a.run(ctx, task, a.keepMemory)
+}
+
+// Continue runs the agent on the next task of the conversation in memory,
+// such as the user's next chat turn. Unlike Run, it keeps the memory of the
+// previous runs, so the model sees the earlier turns; the system prompt is
+// only added on the first turn.
+func (a *BaseAgent) Continue(ctx context.Context, task string) (any, error) {
+	result, err := a.ContinueWithTrace(ctx, task)
+	return result.FinalAnswer, err
+}
+
+// ContinueWithTrace is like Continue, returning the trace of the steps of
+// this turn like RunWithTrace.
+func (a *BaseAgent) ContinueWithTrace(ctx context.Context, task string) (*RunResult, error) {
+	return a.run(ctx, task, true)
+}
+
+// run runs the agent on task, starting with an empty memory unless
+// keepMemory is true.
+func (a *BaseAgent) run(ctx context.Context, task string, keepMemory bool) (*RunResult, error) {
+	// Scope asynchronous tool jobs to this run
+	jobs := tools.NewJobRegistry()
+	defer jobs.Close()
+	ctx = tools.ContextWithJobRegistry(ctx, jobs)
+
+	// Bound the run's wall-clock time, if configured
+	ctx, cancel := a.withRunTimeout(ctx)
+	defer cancel()
+
+	// Initialize the memory, unless it is kept across runs
+	runID := a.ids.NewID()
+	if !keepMemory {
+		a.memory = memory.NewMemory()
+	}
+	a.memory.SetIDGenerator(a.ids)
+	a.trace = nil
+	a.task = task
+	a.modelCalls = 0
+	a.unknownToolCalls = 0
+	a.seenResults = nil
+	a.currentPlan = nil
+	a.planningSteps = 0
+
+	// Add the system prompt to memory, once per conversation
+	if len(a.memory.GetSteps()) == 0 {
+		systemPrompt := a.composeSystemPrompt()
+		systemMessages := []models.Message{
+			{
+				Role:    models.RoleSystem,
+				Content: systemPrompt,
+			},
+		}
+		a.memory.AddSystemPromptStep(systemPrompt, systemMessages)
+		a.memory.CompleteCurrentStep()
 	}
-	a.memory.AddSystemPromptStep(a.systemPrompt, systemMessages)
-	a.memory.CompleteCurrentStep()
 
 	// Add the task to memory
 	taskMessages := []models.Message{
@@ -179,11 +613,30 @@ func (a *BaseAgent) Run(ctx context.Context, task string) (any, error) {
 	// Execute steps until completion or max steps reached
 	var finalAnswer any
 	var lastError error
+	var lastStep *memory.ActionStep
+	var lastMessages []models.Message
+	var stopped bool
 
 	for step := 0; step < a.maxSteps; step++ {
+		// Stop if the run was cancelled or timed out
+		if err := ctx.Err(); err != nil {
+			lastError = err
+			break
+		}
+
+		// Update the plan every planning interval
+		if a.shouldPlan(step) {
+			if err := a.updatePlan(ctx, task); err != nil {
+				lastError = err
+				break
+			}
+		}
+
 		// Create action step
 		messages := a.buildMessages()
-		actionStep := a.memory.AddActionStep(task, messages)
+		actionStep := a.addActionStep(task, messages)
+		lastStep = actionStep
+		lastMessages = messages
 
 		// Execute step
 		var result any
@@ -194,7 +647,7 @@ func (a *BaseAgent) Run(ctx context.Context, task string) (any, error) {
 			result, err = a.Step(ctx, actionStep)
 		}
 		if err != nil {
-			a.memory.CompleteCurrentStep()
+			a.completeActionStep(actionStep)
 			lastError = err
 			break
 		}
@@ -202,18 +655,186 @@ func (a *BaseAgent) Run(ctx context.Context, task string) (any, error) {
 		// Check if we have a final answer
 		if result != nil {
 			finalAnswer = result
-			a.memory.CompleteCurrentStep()
+			a.completeActionStep(actionStep)
 			break
 		}
 
-		a.memory.CompleteCurrentStep()
+		a.completeActionStep(actionStep)
+
+		// Check the custom stop condition
+		if a.stopCondition != nil {
+			if stop, answer := a.stopCondition(a.memory); stop {
+				finalAnswer = answer
+				stopped = true
+				break
+			}
+		}
 	}
 
-	if finalAnswer == nil && lastError == nil {
-		lastError = fmt.Errorf("agent reached maximum number of steps (%d) without finding an answer", a.maxSteps)
+	if finalAnswer == nil && lastError == nil && !stopped {
+		finalAnswer, lastError = a.handleStepLimit(ctx, task, lastStep)
 	}
 
-	return finalAnswer, lastError
+	if answer, ok := finalAnswer.(string); ok && !a.answerMarkdown {
+		finalAnswer = stripMarkdown(answer)
+	}
+
+	lastError = a.runTimeoutError(ctx, lastError)
+
+	if lastError != nil && a.debugDumpDir != "" {
+		if _, err := a.writeDebugDump(runID, lastMessages, lastError); err != nil {
+			lastError = fmt.Errorf("%w (%v)", lastError, err)
+		}
+	}
+
+	return a.buildRunResult(runID, finalAnswer), lastError
+}
+
+// addActionStep adds an action step to memory and to the current run's trace.
+func (a *BaseAgent) addActionStep(task string, messages []models.Message) *memory.ActionStep {
+	step := a.memory.AddActionStep(task, messages)
+	a.trace = append(a.trace, step)
+	return step
+}
+
+// buildRunResult assembles the result of the current run.
+func (a *BaseAgent) buildRunResult(runID string, finalAnswer any) *RunResult {
+	result := &RunResult{
+		RunID:       runID,
+		FinalAnswer: finalAnswer,
+		Steps:       make([]memory.Step, 0, len(a.trace)),
+		ToolUsage:   make(map[string]int),
+	}
+
+	for _, step := range a.trace {
+		result.Steps = append(result.Steps, step.Step)
+		result.Sizes.Add(step.Sizes)
+		result.TokenUsage.Add(step.TokenUsage)
+		result.ModelLatency += step.ModelLatency
+		for _, call := range step.ToolCalls {
+			result.ToolUsage[call.Name]++
+		}
+	}
+
+	return result
+}
+
+// generate calls the model and records the call's latency, approximate
+// prompt and response sizes, and reported token usage on the step. Tools are
+// only offered to the model when toolsSchema is non-nil.
+func (a *BaseAgent) generate(
+	ctx context.Context,
+	step *memory.ActionStep,
+	messages []models.Message,
+	toolsSchema []map[string]any,
+) (string, error) {
+	if err := a.countModelCall(); err != nil {
+		return "", err
+	}
+	ctx = models.ContextWithDefaultGenerationParams(ctx, a.generationParams)
+
+	var response string
+	var err error
+	a.heldDeltas = nil
+	start := time.Now()
+	streamer, canStream := a.model.(models.StreamingModel)
+	detailed, canDetail := a.model.(models.DetailedModel)
+	switch {
+	case toolsSchema != nil:
+		response, err = a.generateWithTools(ctx, step, messages, toolsSchema)
+	case a.tokenCallback != nil && canStream:
+		response, err = a.streamGeneration(ctx, step, func() (<-chan models.StreamChunk, error) {
+			return streamer.GenerateStream(ctx, messages)
+		})
+	case canDetail:
+		var result *models.GenerateResult
+		response, err = awaitModel(ctx, func() (string, error) {
+			r, err := detailed.GenerateDetailed(ctx, messages)
+			if err != nil {
+				return "", err
+			}
+			result = r
+			return r.Content, nil
+		})
+		if err == nil {
+			step.TokenUsage.Add(memory.TokenUsage{
+				PromptTokens:     result.PromptTokens,
+				CompletionTokens: result.CompletionTokens,
+				TotalTokens:      result.TotalTokens,
+			})
+		}
+	default:
+		response, err = awaitModel(ctx, func() (string, error) {
+			return a.model.Generate(ctx, messages)
+		})
+	}
+	step.ModelLatency += time.Since(start)
+	if err != nil {
+		return "", err
+	}
+
+	step.Sizes.Add(memory.MeasureSizes(a.tokenCounter, messages, response))
+
+	return response, nil
+}
+
+// handleStepLimit resolves the outcome of a run that used up all its steps,
+// according to the configured StepLimitBehavior.
+func (a *BaseAgent) handleStepLimit(ctx context.Context, task string, lastStep *memory.ActionStep) (any, error) {
+	limitErr := fmt.Errorf("agent reached maximum number of steps (%d) without finding an answer", a.maxSteps)
+
+	switch a.stepLimitBehavior {
+	case StepLimitPartial:
+		if lastStep != nil {
+			if response, ok := lastAssistantContent(lastStep.Messages); ok {
+				return response, nil
+			}
+		}
+		return nil, limitErr
+
+	case StepLimitForceAnswer:
+		var messages []models.Message
+		if lastStep != nil {
+			messages = append(messages, lastStep.Messages...)
+		} else {
+			messages = a.buildMessages()
+		}
+		messages = append(messages, models.Message{
+			Role:    models.RoleUser,
+			Content: forceAnswerPrompt,
+		})
+
+		forceStep := a.addActionStep(task, messages)
+		defer a.memory.CompleteCurrentStep()
+
+		// Generate without a tools schema so no tools are offered to the model
+		response, err := a.generate(a.finalAnswerContext(ctx), forceStep, messages, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to force final answer: %w", err)
+		}
+
+		forceStep.Messages = append(forceStep.Messages, models.Message{
+			Role:    models.RoleAssistant,
+			Content: response,
+		})
+		forceStep.Output = response
+		a.streamAnswer(response)
+
+		return response, nil
+
+	default:
+		return nil, limitErr
+	}
+}
+
+// lastAssistantContent returns the content of the last assistant message.
+func lastAssistantContent(messages []models.Message) (string, bool) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == models.RoleAssistant {
+			return messages[i].Content, true
+		}
+	}
+	return "", false
 }
 
 // buildMessages constructs the message history for the model.
@@ -223,11 +844,11 @@ func (a *BaseAgent) buildMessages() []models.Message {
 	// Add system prompt
 	messages = append(messages, models.Message{
 		Role:    models.RoleSystem,
-		Content: a.systemPrompt,
+		Content: a.composeSystemPrompt(),
 	})
 
 	// Add tool definitions to system prompt
-	if len(a.tools) > 0 {
+	if len(a.tools) > 0 && a.includeToolDescriptions() {
 		toolsDesc := a.buildToolsDescription()
 		messages = append(messages, models.Message{
 			Role:    models.RoleSystem,
@@ -235,9 +856,26 @@ func (a *BaseAgent) buildMessages() []models.Message {
 		})
 	}
 
-	// Add messages from memory
-	memMessages := a.memory.GetMessages()
-	for _, msg := range memMessages {
+	// Add a digest of previous tool results
+	if a.toolResultDigest {
+		if digest := a.buildToolResultDigest(); digest != "" {
+			messages = append(messages, models.Message{
+				Role:    models.RoleSystem,
+				Content: digest,
+			})
+		}
+	}
+
+	// Add the current plan
+	if a.currentPlan != nil {
+		messages = append(messages, models.Message{
+			Role:    models.RoleSystem,
+			Content: planMessage(a.currentPlan),
+		})
+	}
+
+	// Add the conversation so far
+	for _, msg := range conversationHistory(a.memory) {
 		// Skip system messages as we've already added them
 		if msg.Role == models.RoleSystem {
 			continue
@@ -248,13 +886,101 @@ func (a *BaseAgent) buildMessages() []models.Message {
 	return messages
 }
 
+// conversationHistory returns the messages of the conversation recorded in
+// mem. Each action step's messages start with the prompt it was given, so the
+// last action step carries the history before it forward; only the messages
+// of the steps after it, such as a new task, are added. The exchanges of
+// planning steps are left out, since the current plan is added separately.
+func conversationHistory(mem *memory.Memory) []models.Message {
+	steps := mem.GetSteps()
+
+	last := -1
+	for i, step := range steps {
+		if step.Type == "action" {
+			last = i
+		}
+	}
+
+	var messages []models.Message
+	if last >= 0 {
+		messages = append(messages, steps[last].Messages...)
+	}
+	for _, step := range steps[last+1:] {
+		if step.Type == "planning" {
+			continue
+		}
+		messages = append(messages, step.Messages...)
+	}
+	return messages
+}
+
+// includeToolDescriptions reports whether the tool descriptions belong in
+// the prompt of the next step.
+func (a *BaseAgent) includeToolDescriptions() bool {
+	switch a.toolDescriptionPolicy {
+	case ToolDescriptionsFirstStep:
+		return len(a.trace) == 0
+	case ToolDescriptionsNever:
+		return false
+	default:
+		return true
+	}
+}
+
+// composeSystemPrompt joins the pinned instructions, the system prompt and its
+// fragments.
+func (a *BaseAgent) composeSystemPrompt() string {
+	parts := make([]string, 0, len(a.systemPromptFragments)+2)
+	for _, part := range append([]string{a.pinnedText(), a.systemPrompt}, a.systemPromptFragments...) {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// maxDigestResultLength is the maximum length of a result in the tool result digest.
+const maxDigestResultLength = 80
+
+// buildToolResultDigest constructs a compact table of the tool calls made so
+// far in the current run. It returns an empty string if no tools were called.
+func (a *BaseAgent) buildToolResultDigest() string {
+	var builder strings.Builder
+
+	for i, step := range a.trace {
+		for _, call := range step.ToolCalls {
+			result := fmt.Sprintf("%v", call.Output)
+			if call.Error != "" {
+				result = "error: " + call.Error
+			}
+			fmt.Fprintf(&builder, "%d | %s | %s\n", i+1, call.Name, summarizeResult(result))
+		}
+	}
+
+	if builder.Len() == 0 {
+		return ""
+	}
+
+	return "Results of tools called so far (reuse them instead of calling the tools again):\n" +
+		"step | tool | key result\n" + builder.String()
+}
+
+// summarizeResult collapses a tool result onto one line and truncates it.
+func summarizeResult(result string) string {
+	result = strings.Join(strings.Fields(result), " ")
+	if runes := []rune(result); len(runes) > maxDigestResultLength {
+		result = string(runes[:maxDigestResultLength]) + "..."
+	}
+	return result
+}
+
 // buildToolsDescription constructs a description of all available tools.
 func (a *BaseAgent) buildToolsDescription() string {
 	var builder strings.Builder
 
 	builder.WriteString("You have access to the following tools:\n\n")
 
-	for _, tool := range a.tools {
+	for _, tool := range a.exposedTools() {
 		builder.WriteString(tools.FormatToolDescription(tool))
 		builder.WriteString("\n")
 	}
@@ -269,7 +995,7 @@ func (a *BaseAgent) buildToolsDescription() string {
 	builder.WriteString("  }\n")
 	builder.WriteString("}\n")
 	builder.WriteString("```\n")
-	builder.WriteString("If you want to provide a final answer, just respond with text instead.\n")
+	builder.WriteString("When you have the final answer, call the final_answer tool with it, or respond with text instead.\n")
 
 	return builder.String()
 }
@@ -280,28 +1006,192 @@ func (a *BaseAgent) Step(ctx context.Context, step *memory.ActionStep) (any, err
 	return nil, errors.New("Step method must be implemented by derived agents")
 }
 
-// extractToolCall extracts a tool call from the model's response.
-func (a *BaseAgent) extractToolCall(response string) (string, map[string]any, error) {
-	// Extract JSON from the response
+// toolCall is a tool invocation parsed from a model response.
+type toolCall struct {
+	// id is the provider's tool call id, if the model response carried one.
+	id   string
+	name string
+	args map[string]any
+}
+
+// parseToolCall extracts a tool call from a model response. When a response
+// matches more than one extraction strategy, the following precedence applies:
+//
+//  1. an explicit JSON tool call in a fenced block ({"tool": ..., "args": ...})
+//  2. a code-form call of a known tool inside a code block (tool(arg="value"))
+//  3. free text, which is returned as an empty tool name (a final answer)
+//
+// Both CodeAgent and ToolCallingAgent resolve tool calls through this function
+// so they behave identically on ambiguous responses. When lenient is true,
+// malformed JSON tool calls are repaired before being rejected. At most
+// maxCodeBlocks code blocks are searched for a code-form call, or all of them
+// if maxCodeBlocks is zero.
+func parseToolCall(response string, available []tools.Tool, lenient bool, maxCodeBlocks int) (toolCall, error) {
+	call, err := extractJSONToolCall(response, lenient)
+	if err != nil || call.name != "" {
+		return call, err
+	}
+
+	for _, codeBlock := range extractCodeBlocks(response, maxCodeBlocks) {
+		toolName, args := extractToolCallFromCode(codeBlock, available)
+		if toolName != "" {
+			return toolCall{name: toolName, args: args}, nil
+		}
+	}
+
+	return toolCall{}, nil
+}
+
+// parseToolCalls extracts the tool calls from a model response. A response
+// holding a JSON array of {"tool": ..., "args": ...} objects, as returned when
+// a provider requests several native tool calls at once, yields one call per
+// element; any other response is resolved with parseToolCall. It returns no
+// calls for a final answer.
+func parseToolCalls(response string, available []tools.Tool, lenient bool, maxCodeBlocks int) ([]toolCall, error) {
+	if elements, ok := toolCallElements(response); ok {
+		calls := make([]toolCall, 0, len(elements))
+		for _, element := range elements {
+			call, err := extractJSONToolCall(string(element), lenient)
+			if err != nil {
+				return nil, err
+			}
+			if call.name == "" {
+				return nil, nil // Not a list of tool calls
+			}
+			calls = append(calls, call)
+		}
+		return calls, nil
+	}
+
+	call, err := parseToolCall(response, available, lenient, maxCodeBlocks)
+	if err != nil || call.name == "" {
+		return nil, err
+	}
+	return []toolCall{call}, nil
+}
+
+// toolCallElements returns the elements of the JSON array a response holds,
+// and false if it holds no array.
+func toolCallElements(response string) ([]json.RawMessage, bool) {
 	jsonStr := extractJSON(response)
 	if jsonStr == "" {
-		return "", nil, nil // No tool call, just a regular message
+		jsonStr = strings.TrimSpace(response)
+	}
+	if !strings.HasPrefix(jsonStr, "[") {
+		return nil, false
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal([]byte(jsonStr), &elements); err != nil {
+		return nil, false
+	}
+	return elements, true
+}
+
+// extractJSONToolCall extracts an explicit JSON tool call from the model's
+// response. Native tool calls returned by a provider arrive as a bare JSON
+// object rather than a fenced block and may carry the provider's call id.
+func extractJSONToolCall(response string, lenient bool) (toolCall, error) {
+	// Extract JSON from the response
+	jsonStr := extractJSON(response)
+	bare := jsonStr == ""
+	if bare {
+		jsonStr = strings.TrimSpace(response)
+	}
+	if !strings.HasPrefix(jsonStr, "{") {
+		return toolCall{}, nil // No tool call, just a regular message or code
 	}
 
 	var call struct {
+		ID   string         `json:"id"`
 		Tool string         `json:"tool"`
 		Args map[string]any `json:"args"`
 	}
 
 	if err := json.Unmarshal([]byte(jsonStr), &call); err != nil {
-		return "", nil, fmt.Errorf("failed to parse tool call: %w", err)
+		if !lenient || json.Unmarshal([]byte(repairJSON(jsonStr)), &call) != nil {
+			if bare {
+				return toolCall{}, nil // Unfenced text that merely looks like JSON
+			}
+			return toolCall{}, fmt.Errorf("failed to parse tool call: %w", err)
+		}
 	}
 
 	if call.Tool == "" {
-		return "", nil, nil // No tool call
+		return toolCall{}, nil // No tool call
+	}
+
+	return toolCall{id: call.ID, name: call.Tool, args: call.Args}, nil
+}
+
+// buildToolsSchema builds the OpenAI-style JSON schema for the tools.
+func buildToolsSchema(available []tools.Tool) []map[string]any {
+	schemas := make([]map[string]any, 0, len(available))
+
+	for _, tool := range available {
+		schemas = append(schemas, toolEnvelope(tool))
 	}
 
-	return call.Tool, call.Args, nil
+	return schemas
+}
+
+// toolsSchema returns the OpenAI-style JSON schema for the tools, like
+// buildToolsSchema, reusing the schema of each tool built on earlier steps.
+// Tool schemas are assumed not to change during the agent's lifetime, and
+// the returned schemas are shared, so they must not be modified.
+func (a *BaseAgent) toolsSchema(available []tools.Tool) []map[string]any {
+	if a.toolEnvelopes == nil {
+		a.toolEnvelopes = make(map[string]map[string]any, len(available))
+	}
+
+	schemas := make([]map[string]any, 0, len(available))
+	for _, tool := range available {
+		envelope, ok := a.toolEnvelopes[tool.Name()]
+		if !ok {
+			envelope = toolEnvelope(tool)
+			a.toolEnvelopes[tool.Name()] = envelope
+		}
+		schemas = append(schemas, envelope)
+	}
+
+	return schemas
+}
+
+// toolEnvelope builds the OpenAI-style JSON schema of a tool. The parameters
+// are converted to a plain map, the form models read them in; a schema that
+// cannot be converted is kept as is.
+func toolEnvelope(tool tools.Tool) map[string]any {
+	var parameters any = tool.Schema()
+	if data, err := json.Marshal(parameters); err == nil {
+		var converted map[string]any
+		if err := json.Unmarshal(data, &converted); err == nil {
+			parameters = converted
+		}
+	}
+
+	return map[string]any{
+		"type": "function",
+		"function": map[string]any{
+			"name":        tool.Name(),
+			"description": tool.Description(),
+			"parameters":  parameters,
+		},
+	}
+}
+
+// exportToolsJSON marshals the tools schema as indented JSON.
+func exportToolsJSON(available []tools.Tool) ([]byte, error) {
+	data, err := json.MarshalIndent(buildToolsSchema(available), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tools schema: %w", err)
+	}
+	return data, nil
+}
+
+// ExportToolsJSON returns the tools JSON for the tools exposed to the model,
+// in the OpenAI tools format, for inspection or reuse.
+func (a *BaseAgent) ExportToolsJSON() ([]byte, error) {
+	return exportToolsJSON(a.exposedTools())
 }
 
 // findTool finds a tool by name.
@@ -312,59 +1202,295 @@ func (a *BaseAgent) findTool(name string) (tools.Tool, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("tool not found: %s", name)
+	if name == searchToolsName && a.maxExposedTools > 0 {
+		return &searchToolsTool{agent: a}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrToolNotFound, name)
 }
 
-// executeToolCall executes a tool call.
+// unknownToolFeedback handles a call to a tool that does not exist according
+// to the agent's UnknownToolBehavior. It reports whether the call was answered
+// with feedback, in which case the run continues.
+func (a *BaseAgent) unknownToolFeedback(step *memory.ActionStep, call toolCall) bool {
+	if a.unknownToolBehavior != FeedbackOnUnknownTool || a.unknownToolCalls >= MaxUnknownToolFeedback {
+		return false
+	}
+	if _, err := a.findTool(call.name); err == nil {
+		return false
+	}
+	a.unknownToolCalls++
+
+	names := make([]string, 0, len(a.tools))
+	for _, tool := range a.tools {
+		names = append(names, tool.Name())
+	}
+	feedback := fmt.Sprintf("Tool %s does not exist. Available tools: %s.", call.name, strings.Join(names, ", "))
+
+	a.notifyToolCall(a.memory.AddToolCallWithID(call.id, call.name, call.args, nil, fmt.Errorf("%w: %s", ErrToolNotFound, call.name)))
+	step.Messages = append(step.Messages, models.Message{
+		Role:       models.RoleTool,
+		Name:       call.name,
+		ToolCallID: call.id,
+		Content:    feedback,
+	})
+	return true
+}
+
+// executeToolCall executes a tool call and returns its result along with how
+// long the tool took, including any retries.
 func (a *BaseAgent) executeToolCall(
 	ctx context.Context,
 	step *memory.ActionStep,
-	toolName string,
-	args map[string]any,
-) (any, error) {
+	call toolCall,
+) (any, time.Duration, error) {
+	toolName, args := call.name, call.args
+
 	// Find the tool
 	tool, err := a.findTool(toolName)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	// Execute the tool, retrying failures of idempotent tools
+	start := time.Now()
+	result, err := executeTool(ctx, a.executor, tool, args)
+	for attempt := 0; err != nil && attempt < a.toolRetries && ctx.Err() == nil; attempt++ {
+		if !tools.IsIdempotent(tool) {
+			err = fmt.Errorf("%w (tool %s is not idempotent and was not retried)", err, toolName)
+			break
+		}
+		result, err = executeTool(ctx, a.executor, tool, args)
 	}
 
-	// Execute the tool
-	result, err := tool.Execute(ctx, args)
+	duration := time.Since(start)
 
 	// Record the tool call in memory
-	a.memory.AddToolCall(toolName, args, result, err)
+	a.notifyToolCall(a.memory.AddTimedToolCall(call.id, toolName, args, result, err, duration))
+
+	if err != nil {
+		return nil, duration, err
+	}
+
+	// Check the output against the tool's declared contract
+	if provider, ok := tool.(tools.OutputSchemaProvider); ok {
+		if err := tools.ValidateOutput(provider.OutputSchema(), result); err != nil {
+			if a.strictOutputValidation {
+				return nil, duration, fmt.Errorf("tool %s returned invalid output: %w", toolName, err)
+			}
+			step.Messages = append(step.Messages, models.Message{
+				Role:       models.RoleTool,
+				Name:       toolName,
+				ToolCallID: call.id,
+				Content:    fmt.Sprintf("Warning: output of tool %s does not match its declared output schema: %v", toolName, err),
+			})
+		}
+	}
 
+	return result, duration, nil
+}
+
+// executeAndAddResToMem executes a tool call and adds the observation of its
+// result to the step. Unknown tools are answered with feedback if configured.
+func (a *BaseAgent) executeAndAddResToMem(ctx context.Context, step *memory.ActionStep, call toolCall) (any, error) {
+	// Tell the model about unknown tools instead of failing, if configured
+	if a.unknownToolFeedback(step, call) {
+		return nil, nil
+	}
+
+	// Execute the tool call
+	result, duration, err := a.executeToolCall(ctx, step, call)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute tool call: %w", err)
+	}
+
+	// Add tool result to memory
+	resultStr, err := a.observation(ctx, call.name, result)
 	if err != nil {
 		return nil, err
 	}
+	step.Messages = append(step.Messages, models.Message{
+		Role:       models.RoleTool,
+		Name:       call.name,
+		ToolCallID: call.id,
+		Content:    a.timedObservation(resultStr, duration),
+	})
 
-	return result, nil
+	// No final answer yet, continue to next step
+	return nil, nil
 }
 
-// extractJSON extracts JSON from a string.
+// executeTool executes a tool through executor with the run context. The
+// context is passed on to the tool so context-aware tools observe
+// cancellation, and executeTool returns as soon as the context is done even if
+// the tool ignores it; in that case the tool keeps running in the background
+// and its result is discarded. A panic in the tool is returned as an error
+// wrapping tools.ErrToolPanic.
+func executeTool(ctx context.Context, executor Executor, tool tools.Tool, args map[string]any) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type toolResult struct {
+		output any
+		err    error
+	}
+
+	done := make(chan toolResult, 1)
+	go func() {
+		// Report a panic as the tool's error instead of crashing the process
+		defer func() {
+			if r := recover(); r != nil {
+				done <- toolResult{err: tools.PanicError(tool.Name(), r)}
+			}
+		}()
+
+		output, err := executor.Execute(ctx, tool, args)
+		done <- toolResult{output: output, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.output, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// extractJSON extracts the JSON of a tool call from a model response: the
+// first fenced block holding a tool call object, or a list of them, or else
+// the first such JSON value embedded in the text without a fence. Failing
+// that, it returns the first fenced block that looks like JSON, so malformed
+// tool calls are reported or repaired, or an empty string if there is none.
 func extractJSON(s string) string {
-	// Look for JSON between triple backticks
-	start := strings.Index(s, "```json")
-	if start == -1 {
-		start = strings.Index(s, "```")
+	var candidate string
+	for _, block := range fencedBlocks(s) {
+		body := strings.TrimSpace(block)
+		if !strings.HasPrefix(body, "{") && !strings.HasPrefix(body, "[") {
+			continue
+		}
+		if isToolCallJSON([]byte(body)) {
+			return body
+		}
+		if candidate == "" {
+			candidate = body
+		}
 	}
-	if start == -1 {
-		return "" // No JSON found
+
+	if embedded, ok := embeddedToolCallJSON(s); ok {
+		return embedded
 	}
 
-	// Find the end of the first line
-	start = strings.Index(s[start:], "\n")
-	if start == -1 {
-		return "" // Invalid format
+	return candidate
+}
+
+// fenceLanguage matches the info string of a code fence.
+var fenceLanguage = regexp.MustCompile(`^[\w+#.-]*$`)
+
+// fencedBlocks returns the bodies of the fenced code blocks of s, in order. A
+// block opens with a run of three or more backticks followed by an optional
+// language and a newline, and closes with a line of at least as many
+// backticks, so backticks inside the block's text and fences nested in a
+// longer fence do not end it. A block without such a line ends at the next
+// run of backticks, as models sometimes close the fence on the last line.
+func fencedBlocks(s string) []string {
+	var blocks []string
+	for {
+		start := strings.Index(s, "```")
+		if start < 0 {
+			return blocks
+		}
+		fence := backticks(s[start:])
+		rest := s[start+fence:]
+
+		newline := strings.IndexByte(rest, '\n')
+		if newline < 0 {
+			return blocks
+		}
+		if !fenceLanguage.MatchString(strings.TrimSpace(rest[:newline])) {
+			s = rest // Inline backticks, not an opening fence
+			continue
+		}
+
+		body := rest[newline+1:]
+		end, next := closingFence(body, fence)
+		if end < 0 {
+			return blocks
+		}
+		blocks = append(blocks, body[:end])
+		s = body[next:]
 	}
-	start += 1 // Skip the newline
+}
 
-	// Find the end marker
-	end := strings.Index(s[start:], "```")
-	if end == -1 {
-		return "" // Invalid format
+// closingFence returns the offsets of the end of a block body opened with a
+// fence of n backticks, and of the text after the closing fence, or -1 if
+// the block is not closed.
+func closingFence(body string, n int) (end, next int) {
+	offset := 0
+	for _, line := range strings.SplitAfter(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) >= n && strings.Trim(trimmed, "`") == "" {
+			return offset, offset + len(line)
+		}
+		offset += len(line)
 	}
 
-	// Extract the JSON
-	return strings.TrimSpace(s[start : start+end])
+	if i := strings.Index(body, strings.Repeat("`", n)); i >= 0 {
+		return i, i + backticks(body[i:])
+	}
+	return -1, 0
+}
+
+// backticks returns the length of the run of backticks at the start of s.
+func backticks(s string) int {
+	return len(s) - len(strings.TrimLeft(s, "`"))
+}
+
+// embeddedToolCallJSON finds the first JSON object or array in s that is a
+// tool call, or a list of them, decoding each candidate value in full so
+// nested braces and brackets inside strings are handled.
+func embeddedToolCallJSON(s string) (string, bool) {
+	for i := 0; i < len(s); {
+		next := strings.IndexAny(s[i:], "{[")
+		if next < 0 {
+			break
+		}
+		i += next
+
+		decoder := json.NewDecoder(strings.NewReader(s[i:]))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			i++
+			continue
+		}
+		if isToolCallJSON(value) {
+			return string(value), true
+		}
+		i += int(decoder.InputOffset()) // Skip the whole value, including nested ones
+	}
+	return "", false
+}
+
+// isToolCallJSON reports whether data is a tool call object with a tool name,
+// or a non-empty list of them.
+func isToolCallJSON(data []byte) bool {
+	var call struct {
+		Tool string `json:"tool"`
+	}
+	if json.Unmarshal(data, &call) == nil && call.Tool != "" {
+		return true
+	}
+
+	var calls []struct {
+		Tool string `json:"tool"`
+	}
+	if json.Unmarshal(data, &calls) != nil || len(calls) == 0 {
+		return false
+	}
+	for _, call := range calls {
+		if call.Tool == "" {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,167 @@
+// Package agenttest provides helpers for testing agents and tools end to end
+// without a real model.
+package agenttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/epuerta9/smolagents-go/pkg/agents"
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// scriptedTurn is a single scripted model response.
+type scriptedTurn struct {
+	response string
+	err      error
+}
+
+// ScriptedModel is a models.Model that returns a scripted sequence of
+// responses, repeating the last one once the script runs out. It is built
+// fluently:
+//
+//	model := agenttest.NewScriptedModel().
+//		CallsTool("get_weather", map[string]any{"arg0": "Paris"}).
+//		Answers("It is sunny in Paris.")
+type ScriptedModel struct {
+	mu    sync.Mutex
+	turns []scriptedTurn
+	calls [][]models.Message
+}
+
+// NewScriptedModel creates an empty ScriptedModel.
+func NewScriptedModel() *ScriptedModel {
+	return &ScriptedModel{}
+}
+
+// CallsTool appends a response that calls the named tool with args.
+func (m *ScriptedModel) CallsTool(name string, args map[string]any) *ScriptedModel {
+	data, err := json.Marshal(map[string]any{"tool": name, "args": args})
+	if err != nil {
+		return m.Fails(fmt.Errorf("agenttest: failed to marshal tool call: %w", err))
+	}
+	return m.Responds(fmt.Sprintf("```json\n%s\n```", data))
+}
+
+// Answers appends a plain-text response, which agents treat as a final answer.
+func (m *ScriptedModel) Answers(answer string) *ScriptedModel {
+	return m.Responds(answer)
+}
+
+// Responds appends a raw model response.
+func (m *ScriptedModel) Responds(response string) *ScriptedModel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = append(m.turns, scriptedTurn{response: response})
+	return m
+}
+
+// Fails appends a turn on which the model returns err.
+func (m *ScriptedModel) Fails(err error) *ScriptedModel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = append(m.turns, scriptedTurn{err: err})
+	return m
+}
+
+// Generate returns the next scripted response.
+func (m *ScriptedModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, messages)
+	if len(m.turns) == 0 {
+		return "", fmt.Errorf("agenttest: scripted model has no responses")
+	}
+
+	idx := len(m.calls) - 1
+	if idx >= len(m.turns) {
+		idx = len(m.turns) - 1
+	}
+
+	turn := m.turns[idx]
+	return turn.response, turn.err
+}
+
+// GenerateWithTools returns the next scripted response, ignoring the tools.
+func (m *ScriptedModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// Calls returns the messages the model was called with, one entry per call.
+func (m *ScriptedModel) Calls() [][]models.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]models.Message(nil), m.calls...)
+}
+
+// tracer is implemented by agents that can return the trace of a run.
+type tracer interface {
+	RunWithTrace(ctx context.Context, task string) (*agents.RunResult, error)
+}
+
+// run runs the agent on task and returns the final answer with the tool
+// calls made during the run.
+func run(agent agents.Agent, task string) (any, []memory.ToolCall, error) {
+	if tr, ok := agent.(tracer); ok {
+		result, err := tr.RunWithTrace(context.Background(), task)
+		if result == nil {
+			return nil, nil, err
+		}
+
+		var toolCalls []memory.ToolCall
+		for _, step := range result.Steps {
+			for _, call := range step.ToolCalls {
+				toolCalls = append(toolCalls, *call)
+			}
+		}
+		return result.FinalAnswer, toolCalls, err
+	}
+
+	answer, err := agent.Run(context.Background(), task)
+	return answer, agent.GetMemory().GetToolCalls(), err
+}
+
+// RunAndAssertToolCalled runs the agent on task and fails the test if the
+// run returns an error or never calls the named tool. It returns the run's
+// final answer.
+func RunAndAssertToolCalled(t testing.TB, agent agents.Agent, task, toolName string) any {
+	t.Helper()
+
+	answer, toolCalls, err := run(agent, task)
+	if err != nil {
+		t.Errorf("agent run failed: %v", err)
+		return answer
+	}
+
+	var called []string
+	for _, call := range toolCalls {
+		if call.Name == toolName {
+			return answer
+		}
+		called = append(called, call.Name)
+	}
+
+	t.Errorf("expected tool %q to be called, called tools: %v", toolName, called)
+	return answer
+}
+
+// RunAndAssertAnswer runs the agent on task and fails the test if the run
+// returns an error or its final answer differs from want.
+func RunAndAssertAnswer(t testing.TB, agent agents.Agent, task string, want any) {
+	t.Helper()
+
+	answer, _, err := run(agent, task)
+	if err != nil {
+		t.Errorf("agent run failed: %v", err)
+		return
+	}
+
+	if answer != want {
+		t.Errorf("expected final answer %v, got %v", want, answer)
+	}
+}
@@ -0,0 +1,114 @@
+package agenttest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/epuerta9/smolagents-go/pkg/agents"
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// recordingT captures failures reported by the helpers under test.
+type recordingT struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func newAgent(t *testing.T, model *ScriptedModel) agents.Agent {
+	t.Helper()
+
+	weather := tools.CreateTool[func(string) string]("get_weather", "Gets the weather")(
+		func(city string) string { return "sunny in " + city },
+	)
+	time := tools.CreateTool[func(string) string]("get_time", "Gets the time")(
+		func(city string) string { return "noon in " + city },
+	)
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{weather, time}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+	return agent
+}
+
+// TestScriptedModel tests the fluent scripted model builder
+func TestScriptedModel(t *testing.T) {
+	model := NewScriptedModel().
+		CallsTool("get_weather", map[string]any{"arg0": "Paris"}).
+		Answers("It is sunny in Paris.")
+
+	answer := RunAndAssertToolCalled(t, newAgent(t, model), "weather in Paris?", "get_weather")
+	if answer != "It is sunny in Paris." {
+		t.Errorf("Expected the scripted answer, got %v", answer)
+	}
+	if len(model.Calls()) != 2 {
+		t.Errorf("Expected 2 model calls, got %d", len(model.Calls()))
+	}
+}
+
+// TestRunAndAssertToolCalled tests that the helper detects missing tool calls
+func TestRunAndAssertToolCalled(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      *ScriptedModel
+		toolName   string
+		wantFailed bool
+	}{
+		{
+			name:     "tool called",
+			model:    NewScriptedModel().CallsTool("get_weather", map[string]any{"arg0": "Paris"}).Answers("done"),
+			toolName: "get_weather",
+		},
+		{
+			name:       "other tool called",
+			model:      NewScriptedModel().CallsTool("get_time", map[string]any{"arg0": "Paris"}).Answers("done"),
+			toolName:   "get_weather",
+			wantFailed: true,
+		},
+		{
+			name:       "no tool called",
+			model:      NewScriptedModel().Answers("done"),
+			toolName:   "get_weather",
+			wantFailed: true,
+		},
+		{
+			name:       "run error",
+			model:      NewScriptedModel().Fails(errors.New("model unavailable")),
+			toolName:   "get_weather",
+			wantFailed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recordingT{TB: t}
+			RunAndAssertToolCalled(rec, newAgent(t, tt.model), "task", tt.toolName)
+
+			if failed := len(rec.failures) > 0; failed != tt.wantFailed {
+				t.Errorf("Expected failed = %v, got failures %v", tt.wantFailed, rec.failures)
+			}
+		})
+	}
+}
+
+// TestRunAndAssertAnswer tests that the helper compares final answers
+func TestRunAndAssertAnswer(t *testing.T) {
+	rec := &recordingT{TB: t}
+	RunAndAssertAnswer(rec, newAgent(t, NewScriptedModel().Answers("42")), "task", "42")
+	if len(rec.failures) != 0 {
+		t.Errorf("Expected no failures, got %v", rec.failures)
+	}
+
+	rec = &recordingT{TB: t}
+	RunAndAssertAnswer(rec, newAgent(t, NewScriptedModel().Answers("41")), "task", "42")
+	if len(rec.failures) != 1 {
+		t.Errorf("Expected 1 failure, got %v", rec.failures)
+	}
+}
@@ -0,0 +1,156 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// argRepairPrompt instructs the argument repair model.
+const argRepairPrompt = `You repair malformed tool calls.
+Given a tool call, the problem with it, and the available tools, respond only with a corrected JSON object of the form {"tool": "tool_name", "args": {...}} whose args match the tool's parameters schema.
+Do not change the intent of the call.`
+
+// WithArgRepairModel has model repair tool calls whose arguments are
+// malformed or miss required parameters, before the step fails. A cheaper
+// model than the agent's own is usually sufficient.
+func WithArgRepairModel(model models.Model) Option {
+	return func(a *BaseAgent) error {
+		if model == nil {
+			return errors.New("argument repair model is required")
+		}
+		a.argRepairModel = model
+		return nil
+	}
+}
+
+// validateToolCall checks that the call provides every required parameter
+// of its tool. Unknown tools are left for execution to report.
+func (a *BaseAgent) validateToolCall(call toolCall) error {
+	tool, err := a.findTool(call.name)
+	if err != nil {
+		return nil
+	}
+
+	schema := tool.Schema()
+	if schema == nil {
+		return nil
+	}
+
+	for _, name := range schema.Required {
+		if _, ok := call.args[name]; !ok {
+			return fmt.Errorf("tool %s is missing required argument %s", call.name, name)
+		}
+	}
+
+	return nil
+}
+
+// parseAndRepairToolCall parses a tool call from the response. If the call
+// cannot be parsed or fails validation and an argument repair model is
+// configured, the repair model is asked for a corrected call.
+func (a *BaseAgent) parseAndRepairToolCall(ctx context.Context, response string) (toolCall, error) {
+	call, err := parseToolCall(response, a.tools, a.lenientJSON, a.maxCodeBlocks)
+	return a.repairToolCall(ctx, response, call, err)
+}
+
+// parseAndRepairToolCalls extracts the tool calls from a response like
+// parseToolCalls. If an argument repair model is configured, each call that
+// cannot be parsed or fails validation is repaired on its own, including
+// every call of a response holding several.
+func (a *BaseAgent) parseAndRepairToolCalls(ctx context.Context, response string) ([]toolCall, error) {
+	if a.argRepairModel == nil {
+		return parseToolCalls(response, a.tools, a.lenientJSON, a.maxCodeBlocks)
+	}
+
+	elements, ok := toolCallElements(response)
+	if !ok {
+		call, err := a.parseAndRepairToolCall(ctx, response)
+		if err != nil || call.name == "" {
+			return nil, err
+		}
+		return []toolCall{call}, nil
+	}
+
+	calls := make([]toolCall, 0, len(elements))
+	for _, element := range elements {
+		var head struct {
+			ID   string `json:"id"`
+			Tool string `json:"tool"`
+		}
+		if json.Unmarshal(element, &head) != nil || head.Tool == "" {
+			return nil, nil // Not a list of tool calls
+		}
+
+		call, err := extractJSONToolCall(string(element), a.lenientJSON)
+		if err == nil && call.name == "" {
+			err = fmt.Errorf("failed to parse arguments of tool %s", head.Tool)
+		}
+		if err != nil {
+			call = toolCall{id: head.ID, name: head.Tool}
+		}
+
+		call, err = a.repairToolCall(ctx, string(element), call, err)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// repairToolCall validates call, parsed from response with error err, and
+// asks the argument repair model for a corrected call if parsing or
+// validation failed. Without a repair model, the call and error are
+// returned as they are.
+func (a *BaseAgent) repairToolCall(ctx context.Context, response string, call toolCall, err error) (toolCall, error) {
+	if err == nil && call.name != "" && a.argRepairModel != nil {
+		err = a.validateToolCall(call)
+	}
+	if err == nil || a.argRepairModel == nil {
+		return call, err
+	}
+
+	toolsJSON, jsonErr := a.ExportToolsJSON()
+	if jsonErr != nil {
+		return toolCall{}, jsonErr
+	}
+
+	messages := []models.Message{
+		{
+			Role:    models.RoleSystem,
+			Content: argRepairPrompt,
+		},
+		{
+			Role:    models.RoleUser,
+			Content: fmt.Sprintf("Tool call:\n%s\n\nProblem: %v\n\nAvailable tools:\n%s", response, err, toolsJSON),
+		},
+	}
+
+	if countErr := a.countModelCall(); countErr != nil {
+		return toolCall{}, countErr
+	}
+	repaired, repairErr := awaitModel(ctx, func() (string, error) {
+		return a.argRepairModel.Generate(ctx, messages)
+	})
+	if repairErr != nil {
+		return toolCall{}, fmt.Errorf("%w (argument repair failed: %v)", err, repairErr)
+	}
+
+	fixed, parseErr := extractJSONToolCall(repaired, true)
+	if parseErr == nil && fixed.name == "" {
+		parseErr = errors.New("no tool call in repair response")
+	}
+	if parseErr == nil {
+		parseErr = a.validateToolCall(fixed)
+	}
+	if parseErr != nil {
+		return toolCall{}, fmt.Errorf("%w (argument repair failed: %v)", err, parseErr)
+	}
+
+	fixed.id = call.id
+	return fixed, nil
+}
@@ -0,0 +1,41 @@
+package agents
+
+import (
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+)
+
+// WithStepCallback calls callback after each action step of a run completes,
+// with the step's messages, tool calls and timings populated. The callback
+// runs on the agent's goroutine and should not modify the step.
+func WithStepCallback(callback func(step *memory.ActionStep)) Option {
+	return func(a *BaseAgent) error {
+		a.stepCallback = callback
+		return nil
+	}
+}
+
+// WithToolCallCallback calls callback after each tool call is recorded in
+// memory, including failed calls and calls of the final_answer tool.
+func WithToolCallCallback(callback func(call memory.ToolCall)) Option {
+	return func(a *BaseAgent) error {
+		a.toolCallCallback = callback
+		return nil
+	}
+}
+
+// completeActionStep completes the current action step and reports it to the
+// step callback.
+func (a *BaseAgent) completeActionStep(step *memory.ActionStep) {
+	a.memory.CompleteCurrentStep()
+	if a.stepCallback != nil {
+		a.stepCallback(step)
+	}
+}
+
+// notifyToolCall reports a tool call recorded in memory to the tool-call
+// callback.
+func (a *BaseAgent) notifyToolCall(call *memory.ToolCall) {
+	if a.toolCallCallback != nil && call != nil {
+		a.toolCallCallback(*call)
+	}
+}
@@ -2,6 +2,7 @@ package agents
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -26,6 +27,7 @@ func NewCodeAgent(tools []tools.Tool, model models.Model, opts ...Option) (*Code
 	agent := &CodeAgent{
 		BaseAgent: baseAgent,
 	}
+	agent.SetStepper(agent)
 
 	// Set default agent properties if not overridden by options
 	if agent.name == "BaseAgent" {
@@ -48,30 +50,10 @@ When you have the answer to the user's request, respond with the relevant inform
 	return agent, nil
 }
 
-func (a *CodeAgent) executeAndAddResToMem(ctx context.Context, step *memory.ActionStep, toolName string,
-	args map[string]any) (any, error) {
-	// Execute the tool call
-	result, err := a.executeToolCall(ctx, step, toolName, args)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute tool call: %w", err)
-	}
-
-	// Add tool result to memory
-	resultStr := fmt.Sprintf("%v", result)
-	step.Messages = append(step.Messages, models.Message{
-		Role:    models.RoleTool,
-		Name:    toolName,
-		Content: resultStr,
-	})
-
-	// No final answer yet, continue to next step
-	return nil, nil
-}
-
 // Step executes a single step of the agent's reasoning.
 func (a *CodeAgent) Step(ctx context.Context, step *memory.ActionStep) (any, error) {
 	// Generate model response
-	response, err := a.model.Generate(ctx, step.Messages)
+	response, err := a.generateStep(ctx, step, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate response: %w", err)
 	}
@@ -82,43 +64,60 @@ func (a *CodeAgent) Step(ctx context.Context, step *memory.ActionStep) (any, err
 		Content: response,
 	})
 
-	// Check if the response contains code blocks
-	codeBlocks := extractCodeBlocks(response)
+	// Check if the response is a tool call, either as JSON or in a code block
+	call, err := a.parseAndRepairToolCall(ctx, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract tool call: %w", err)
+	}
 
-	// For simplicity, we'll just execute the first code block that contains a tool call
-	for _, codeBlock := range codeBlocks {
-		// Check if the code block contains a tool call
-		toolName, args, err := a.extractToolCallFromCode(codeBlock)
-		if err != nil {
-			return nil, fmt.Errorf("failed to extract tool call from code: %w", err)
+	// Execute a Go code block, if code execution is enabled
+	if call.name == "" && a.codeExecutor != nil {
+		if code, ok := goCodeBlock(response, a.maxCodeBlocks); ok {
+			return a.executeCode(ctx, step, code)
 		}
+	}
 
-		if toolName != "" {
-			return a.executeAndAddResToMem(ctx, step, toolName, args)
+	// If no tool call, treat as final answer
+	if call.name == "" {
+		answer, err := a.finalAnswer(ctx, step, response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate final answer: %w", err)
 		}
+		return answer, nil
 	}
 
-	// Check if the response is a direct tool call (JSON format)
-	toolName, args, err := a.extractToolCall(response)
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract tool call: %w", err)
+	// A call of the final_answer tool ends the run with its argument
+	if call.name == tools.FinalAnswerToolName {
+		return a.finalAnswerFromCall(call), nil
 	}
 
-	// If no tool call, treat as final answer
-	if toolName == "" {
-		return response, nil
-	}
+	return a.executeAndAddResToMem(ctx, step, call)
+}
 
-	return a.executeAndAddResToMem(ctx, step, toolName, args)
+// WithMaxCodeBlocks limits how many code blocks of a response are searched
+// for a tool call, bounding the work a response with many blocks can cause.
+// By default all blocks are considered.
+func WithMaxCodeBlocks(n int) Option {
+	return func(a *BaseAgent) error {
+		if n <= 0 {
+			return errors.New("max code blocks must be greater than 0")
+		}
+		a.maxCodeBlocks = n
+		return nil
+	}
 }
 
-// extractCodeBlocks extracts code blocks from a string.
-func extractCodeBlocks(s string) []string {
+// extractCodeBlocks extracts the first limit code blocks from a string, or
+// all of them if limit is zero.
+func extractCodeBlocks(s string, limit int) []string {
 	var blocks []string
 
 	// Match code blocks between triple backticks
 	re := regexp.MustCompile("```(?:\\w+)?\\n([\\s\\S]*?)```")
-	matches := re.FindAllStringSubmatch(s, -1)
+	if limit <= 0 {
+		limit = -1
+	}
+	matches := re.FindAllStringSubmatch(s, limit)
 
 	for _, match := range matches {
 		if len(match) > 1 {
@@ -129,14 +128,14 @@ func extractCodeBlocks(s string) []string {
 	return blocks
 }
 
-// extractToolCallFromCode extracts a tool call from a code block.
-func (a *CodeAgent) extractToolCallFromCode(code string) (string, map[string]any, error) {
+// extractToolCallFromCode extracts a call to one of the available tools from a code block.
+func extractToolCallFromCode(code string, available []tools.Tool) (string, map[string]any) {
 	// Look for patterns like: result = tool_name(arg1="value1", arg2="value2")
 	re := regexp.MustCompile(`(\w+)\s*\((.*?)\)`)
 	match := re.FindStringSubmatch(code)
 
 	if len(match) < 3 {
-		return "", nil, nil
+		return "", nil
 	}
 
 	toolName := match[1]
@@ -144,7 +143,7 @@ func (a *CodeAgent) extractToolCallFromCode(code string) (string, map[string]any
 
 	// Check if the tool exists
 	var found bool
-	for _, tool := range a.tools {
+	for _, tool := range available {
 		if tool.Name() == toolName {
 			found = true
 			break
@@ -152,7 +151,7 @@ func (a *CodeAgent) extractToolCallFromCode(code string) (string, map[string]any
 	}
 
 	if !found {
-		return "", nil, nil
+		return "", nil
 	}
 
 	// Parse arguments
@@ -194,5 +193,5 @@ func (a *CodeAgent) extractToolCallFromCode(code string) (string, map[string]any
 		args[argName] = argValue
 	}
 
-	return toolName, args, nil
+	return toolName, args
 }
@@ -0,0 +1,317 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// CodeExecutionToolName is the name under which code executions are recorded
+// as tool calls in memory.
+const CodeExecutionToolName = "execute_code"
+
+// DefaultCodeTimeout is the time GoExecutor allows for building and running
+// a program when no timeout is set.
+const DefaultCodeTimeout = 30 * time.Second
+
+// DefaultCodeMaxOutputBytes is the number of bytes of standard output, and
+// separately of standard error, GoExecutor keeps when no limit is set.
+const DefaultCodeMaxOutputBytes = 64 << 10
+
+// codeExecutionPrompt is added to the system prompt of agents with a code
+// executor.
+const codeExecutionPrompt = "To run Go code, write a complete Go program (package main) in a ```go code block. " +
+	"It is executed and its standard output is returned to you. " +
+	"Programs may only import standard library packages for computation and formatting, such as fmt, strings, " +
+	"strconv, math, sort, time and encoding/json; they cannot access files, the network or other processes. " +
+	"When you have the answer, respond with it without a code block."
+
+// CodeExecutor executes the code a CodeAgent generates and returns its
+// standard output.
+type CodeExecutor interface {
+	ExecuteCode(ctx context.Context, code string) (string, error)
+}
+
+// WithCodeExecutor makes a CodeAgent execute the Go code blocks of responses
+// that call no tool, feeding the program's output back to the model as an
+// observation. Without a code executor, code blocks are only searched for
+// tool calls.
+func WithCodeExecutor(executor CodeExecutor) Option {
+	return func(a *BaseAgent) error {
+		if executor == nil {
+			return errors.New("code executor cannot be nil")
+		}
+		a.codeExecutor = executor
+		a.systemPromptFragments = append(a.systemPromptFragments, codeExecutionPrompt)
+		return nil
+	}
+}
+
+// allowedImports are the packages GoExecutor builds programs against. They
+// only compute and format; packages such as os, net, crypto/tls, log/syslog,
+// syscall and unsafe, which reach files, the network, other processes or raw
+// memory, are left out.
+var allowedImports = map[string]bool{
+	"bufio": true, "bytes": true, "cmp": true, "context": true, "errors": true, "fmt": true,
+	"io": true, "iter": true, "maps": true, "slices": true, "sort": true, "strconv": true,
+	"strings": true, "sync": true, "sync/atomic": true, "time": true,
+	"unicode": true, "unicode/utf8": true, "unicode/utf16": true,
+	"math": true, "math/big": true, "math/bits": true, "math/cmplx": true, "math/rand": true, "math/rand/v2": true,
+	"container/heap": true, "container/list": true, "container/ring": true,
+	"encoding/base32": true, "encoding/base64": true, "encoding/binary": true, "encoding/csv": true,
+	"encoding/hex": true, "encoding/json": true, "encoding/xml": true,
+	"hash/adler32": true, "hash/crc32": true, "hash/crc64": true, "hash/fnv": true,
+	"crypto/md5": true, "crypto/sha1": true, "crypto/sha256": true, "crypto/sha512": true,
+	"html": true, "path": true, "regexp": true, "text/tabwriter": true, "text/template": true,
+}
+
+// GoExecutor builds and runs Go programs with the local Go toolchain. Each
+// program is built in a fresh temporary directory, against the standard
+// library only, and runs in that directory with an empty environment, under a
+// timeout. Programs may only import an allowlist of standard library packages
+// for computation and formatting, so they cannot open files or network
+// connections or start processes; other imports, cgo included, are refused.
+//
+// These restrictions keep well-meaning generated code contained; they are not
+// enforced by the operating system and are no security boundary against
+// hostile code, which should run in an isolated container or VM instead.
+type GoExecutor struct {
+	// GoBinary is the path of the go command. Defaults to "go" on the PATH.
+	GoBinary string
+
+	// Timeout bounds building and running a program. Defaults to
+	// DefaultCodeTimeout.
+	Timeout time.Duration
+
+	// MaxOutputBytes bounds the standard output and the standard error kept
+	// of a program. A program writing more is stopped and fails with the
+	// output written up to the limit. Defaults to DefaultCodeMaxOutputBytes.
+	MaxOutputBytes int
+}
+
+// ExecuteCode builds and runs code, returning its standard output. A program
+// without a package clause is taken to be package main. Build failures and
+// non-zero exits are returned as errors including the program's output.
+func (e *GoExecutor) ExecuteCode(ctx context.Context, code string) (string, error) {
+	if !hasPackageClause(code) {
+		code = "package main\n\n" + code
+	}
+	if err := checkImports(code); err != nil {
+		return "", err
+	}
+
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCodeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "smolagents-code-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(code), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write program: %w", err)
+	}
+
+	goBinary := e.GoBinary
+	if goBinary == "" {
+		goBinary = "go"
+	}
+
+	// Build against the standard library only, without downloading anything
+	build := exec.CommandContext(ctx, goBinary, "build", "-o", "program", "main.go")
+	build.Dir = dir
+	build.Env = buildEnv(dir)
+	if output, err := build.CombinedOutput(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("build failed: %w\n%s", err, output)
+	}
+
+	maxOutput := e.MaxOutputBytes
+	if maxOutput <= 0 {
+		maxOutput = DefaultCodeMaxOutputBytes
+	}
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
+
+	stdout := &cappedWriter{limit: maxOutput, onExceed: kill}
+	stderr := &cappedWriter{limit: maxOutput, onExceed: kill}
+	run := exec.CommandContext(runCtx, filepath.Join(dir, "program"))
+	run.Dir = dir
+	run.Env = []string{"HOME=" + dir, "TMPDIR=" + dir}
+	run.Stdout = stdout
+	run.Stderr = stderr
+	err = run.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return stdout.String(), ctxErr
+	}
+	if stdout.exceeded || stderr.exceeded {
+		return stdout.String(), fmt.Errorf("program stopped: its output exceeded %d bytes", maxOutput)
+	}
+	if err != nil {
+		return stdout.String(), fmt.Errorf("program failed: %w\n%s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// cappedWriter keeps the first limit bytes written to it and discards the
+// rest, calling onExceed the first time a write goes past the limit.
+type cappedWriter struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+	onExceed func()
+}
+
+// Write implements io.Writer. It never fails, so the writer of a process
+// output is not interrupted while the process is being stopped.
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); len(p) > room {
+		w.buf.Write(p[:max(room, 0)])
+		if !w.exceeded {
+			w.exceeded = true
+			w.onExceed()
+		}
+		return len(p), nil
+	}
+	return w.buf.Write(p)
+}
+
+// String returns the bytes kept.
+func (w *cappedWriter) String() string {
+	return w.buf.String()
+}
+
+// buildEnv returns the environment of the go command building a program in
+// dir, reusing the build cache but disabling downloads and cgo.
+func buildEnv(dir string) []string {
+	env := []string{
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"PATH=" + os.Getenv("PATH"),
+		"GOPROXY=off",
+		"GOTOOLCHAIN=local",
+		"GOFLAGS=",
+		"GOWORK=off",
+		"CGO_ENABLED=0",
+	}
+	if cache := goCache(); cache != "" {
+		env = append(env, "GOCACHE="+cache)
+	}
+	if root := os.Getenv("GOROOT"); root != "" {
+		env = append(env, "GOROOT="+root)
+	}
+	return env
+}
+
+// goCache returns the build cache directory of the current user.
+func goCache() string {
+	if cache := os.Getenv("GOCACHE"); cache != "" {
+		return cache
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "go-build")
+	}
+	return ""
+}
+
+// packageClause matches the package clause of a Go source file.
+var packageClause = regexp.MustCompile(`(?m)^\s*package\s+\w+`)
+
+// hasPackageClause reports whether code starts with a package clause.
+func hasPackageClause(code string) bool {
+	return packageClause.MatchString(code)
+}
+
+// checkImports refuses programs importing packages outside the allowlist.
+func checkImports(code string) error {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", code, parser.ImportsOnly)
+	if err != nil {
+		return fmt.Errorf("invalid program: %w", err)
+	}
+
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return fmt.Errorf("invalid import %s", spec.Path.Value)
+		}
+		if !allowedImports[path] {
+			return fmt.Errorf("import of %s is not allowed", path)
+		}
+	}
+
+	return nil
+}
+
+// goCodeBlock returns the first Go code block of response: a block tagged go,
+// or an untagged block holding a main function.
+func goCodeBlock(response string, limit int) (string, bool) {
+	re := regexp.MustCompile("```(\\w*)\\n([\\s\\S]*?)```")
+	if limit <= 0 {
+		limit = -1
+	}
+
+	for _, match := range re.FindAllStringSubmatch(response, limit) {
+		lang, code := strings.ToLower(match[1]), match[2]
+		if lang == "go" || lang == "golang" || (lang == "" && strings.Contains(code, "func main(")) {
+			return code, true
+		}
+	}
+
+	return "", false
+}
+
+// executeCode runs code with the code executor and records its output as the
+// observation of the step. Build and run failures are reported to the model
+// so it can fix the code; only a done context fails the step.
+func (a *BaseAgent) executeCode(ctx context.Context, step *memory.ActionStep, code string) (any, error) {
+	start := time.Now()
+	output, err := a.codeExecutor.ExecuteCode(ctx, code)
+	duration := time.Since(start)
+
+	args := map[string]any{"code": code}
+	a.notifyToolCall(a.memory.AddTimedToolCall("", CodeExecutionToolName, args, output, err, duration))
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+
+	observation := output
+	if err != nil {
+		observation = fmt.Sprintf("Code execution failed: %v", err)
+		if output != "" {
+			observation += "\nOutput before the failure:\n" + output
+		}
+	} else if strings.TrimSpace(observation) == "" {
+		observation = "The program ran successfully and printed nothing."
+	}
+
+	step.Messages = append(step.Messages, models.Message{
+		Role:    models.RoleTool,
+		Name:    CodeExecutionToolName,
+		Content: a.timedObservation(observation, duration),
+	})
+
+	// No final answer yet, continue to next step
+	return nil, nil
+}
@@ -0,0 +1,67 @@
+package agents
+
+import (
+	"context"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// recoveryKeepMessages is the number of most recent messages kept, besides
+// the system prompt and the task, when recovering from a context-window error.
+const recoveryKeepMessages = 2
+
+// generateStep calls the model with the step's messages. If the provider
+// rejects the request as exceeding its context window, the step's history is
+// aggressively trimmed and the call is retried once before failing. The
+// messages always start with the pinned system instructions, which trimming
+// keeps.
+func (a *BaseAgent) generateStep(
+	ctx context.Context,
+	step *memory.ActionStep,
+	toolsSchema []map[string]any,
+) (string, error) {
+	step.Messages = a.pinInstructions(step.Messages)
+	response, err := a.generate(ctx, step, step.Messages, toolsSchema)
+	if err == nil || !models.IsContextWindowError(err) {
+		return response, err
+	}
+
+	trimmed := trimHistory(step.Messages)
+	if len(trimmed) == len(step.Messages) {
+		return "", err // Nothing left to trim
+	}
+
+	step.Messages = trimmed
+	return a.generate(ctx, step, step.Messages, toolsSchema)
+}
+
+// trimHistory keeps the leading system messages, the first user message (the
+// task), and the most recent messages, dropping everything in between. The
+// cut never separates tool messages from the assistant message holding their
+// calls, which providers reject, so more messages than recoveryKeepMessages
+// may be kept.
+func trimHistory(messages []models.Message) []models.Message {
+	var head []models.Message
+	rest := messages
+	for len(rest) > 0 && rest[0].Role == models.RoleSystem {
+		head = append(head, rest[0])
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0].Role == models.RoleUser {
+		head = append(head, rest[0])
+		rest = rest[1:]
+	}
+
+	cut := len(rest) - recoveryKeepMessages
+	for cut > 0 && rest[cut].Role == models.RoleTool {
+		cut--
+	}
+	if cut <= 0 {
+		return messages
+	}
+
+	trimmed := make([]models.Message, 0, len(head)+len(rest)-cut)
+	trimmed = append(trimmed, head...)
+	return append(trimmed, rest[cut:]...)
+}
@@ -0,0 +1,53 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/epuerta9/smolagents-go/pkg/models"
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// coordinatorPrompt is the system prompt of agents created by NewCoordinator.
+const coordinatorPrompt = `You are a coordinator of a team of specialist agents.
+Each specialist is available as a tool that takes a task and returns the specialist's answer.
+Delegate the user's request, or each part of it, to the specialist best suited for it.
+Give each specialist a complete, self-contained task, since it does not see the conversation.
+When the specialists have answered, combine their answers into the final answer for the user.`
+
+// NewCoordinator creates a ToolCallingAgent that routes tasks to a team of
+// specialist agents. Each sub-agent is exposed to the coordinator as a tool
+// named after the agent and described by its description; calling the tool
+// runs the sub-agent on the given task. The options configure the
+// coordinator, and WithSystemPrompt replaces the default coordinator prompt.
+func NewCoordinator(model models.Model, subAgents []Agent, opts ...Option) (*ToolCallingAgent, error) {
+	if len(subAgents) == 0 {
+		return nil, errors.New("at least one sub-agent is required")
+	}
+
+	agentTools := make([]tools.Tool, 0, len(subAgents))
+	names := make(map[string]bool, len(subAgents))
+	for _, agent := range subAgents {
+		if agent == nil {
+			return nil, errors.New("sub-agent cannot be nil")
+		}
+		name := agent.GetName()
+		if names[name] {
+			return nil, fmt.Errorf("duplicate sub-agent name: %s", name)
+		}
+		names[name] = true
+		agentTools = append(agentTools, tools.AgentTool(agent, name, agent.GetDescription()))
+	}
+
+	opts = append([]Option{WithSystemPrompt(coordinatorPrompt)}, opts...)
+	coordinator, err := NewToolCallingAgent(agentTools, model, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if coordinator.name == "ToolCallingAgent" {
+		coordinator.name = "Coordinator"
+	}
+
+	return coordinator, nil
+}
@@ -0,0 +1,84 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// debugDumpTimeFormat is the timestamp format used in debug dump file names.
+const debugDumpTimeFormat = "20060102T150405.000000000Z"
+
+// DebugDump is the post-mortem record written when a run fails and
+// WithDebugDumpOnError is set.
+type DebugDump struct {
+	// RunID identifies the failed run.
+	RunID string `json:"run_id"`
+
+	// Task is the task of the failed run.
+	Task string `json:"task"`
+
+	// Time is when the dump was written.
+	Time time.Time `json:"time"`
+
+	// Error is the error the run failed with.
+	Error string `json:"error"`
+
+	// StepMessages are the messages built for the failing step, as sent to
+	// the model.
+	StepMessages []models.Message `json:"step_messages"`
+
+	// Memory holds the steps recorded in the agent's memory.
+	Memory []*memory.Step `json:"memory"`
+
+	// Steps holds the action steps of the run, including their tool calls.
+	Steps []*memory.ActionStep `json:"steps"`
+}
+
+// WithDebugDumpOnError writes a DebugDump to a timestamped JSON file in dir
+// whenever a run fails. The directory is created if it does not exist.
+func WithDebugDumpOnError(dir string) Option {
+	return func(a *BaseAgent) error {
+		if dir == "" {
+			return fmt.Errorf("debug dump directory must not be empty")
+		}
+		a.debugDumpDir = dir
+		return nil
+	}
+}
+
+// writeDebugDump writes the state of the failed run to the debug dump
+// directory and returns the path of the written file.
+func (a *BaseAgent) writeDebugDump(runID string, stepMessages []models.Message, runErr error) (string, error) {
+	now := time.Now().UTC()
+	dump := DebugDump{
+		RunID:        runID,
+		Task:         a.task,
+		Time:         now,
+		Error:        runErr.Error(),
+		StepMessages: stepMessages,
+		Memory:       a.memory.GetSteps(),
+		Steps:        a.trace,
+	}
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal debug dump: %w", err)
+	}
+
+	if err := os.MkdirAll(a.debugDumpDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create debug dump directory: %w", err)
+	}
+
+	path := filepath.Join(a.debugDumpDir, fmt.Sprintf("run-%s-%s.json", now.Format(debugDumpTimeFormat), runID))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write debug dump: %w", err)
+	}
+
+	return path, nil
+}
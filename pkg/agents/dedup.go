@@ -0,0 +1,34 @@
+package agents
+
+import "fmt"
+
+// WithToolResultDedup replaces a tool result that repeats an earlier result
+// of the same tool in the run with a short reference to the step that first
+// produced it, keeping loops that call the same tool lean. The full result is
+// still recorded on the step's tool call.
+func WithToolResultDedup(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.toolResultDedup = enabled
+		return nil
+	}
+}
+
+// duplicateObservation returns a reference to the step that first produced
+// the same result from the same tool, if result is a repeat. Otherwise it
+// remembers the result as produced by the current step.
+func (a *BaseAgent) duplicateObservation(toolName, result string) (string, bool) {
+	if !a.toolResultDedup {
+		return "", false
+	}
+
+	key := toolName + "\x00" + result
+	if step, ok := a.seenResults[key]; ok {
+		return fmt.Sprintf("Same output as tool %s returned in step %d.", toolName, step), true
+	}
+
+	if a.seenResults == nil {
+		a.seenResults = make(map[string]int)
+	}
+	a.seenResults[key] = len(a.trace)
+	return "", false
+}
@@ -0,0 +1,38 @@
+package agents
+
+import (
+	"context"
+	"errors"
+
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// Executor dispatches the tool calls of an agent. Implementations can run
+// tools elsewhere, such as on a remote worker or through a queue, or wrap the
+// call with concerns like authentication.
+//
+// The agent still applies its run context, retries and panic recovery around
+// the executor, so an executor only needs to perform the call itself.
+type Executor interface {
+	Execute(ctx context.Context, tool tools.Tool, args map[string]any) (any, error)
+}
+
+// LocalExecutor executes tools in the current process by calling their
+// Execute method. It is the default executor.
+type LocalExecutor struct{}
+
+// Execute calls tool.Execute with the arguments.
+func (LocalExecutor) Execute(ctx context.Context, tool tools.Tool, args map[string]any) (any, error) {
+	return tool.Execute(ctx, args)
+}
+
+// WithExecutor sets the executor the agent dispatches tool calls through.
+func WithExecutor(executor Executor) Option {
+	return func(a *BaseAgent) error {
+		if executor == nil {
+			return errors.New("executor cannot be nil")
+		}
+		a.executor = executor
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package agents
+
+import (
+	"context"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// WithFinalAnswerTemperature generates the final answer at temperature while
+// intermediate steps use the agent's normal settings. Since a generation is
+// only known to be the final answer once it is complete, the final answer is
+// regenerated at this temperature, which costs one extra model call per run.
+// Answers forced at the step limit use it directly.
+func WithFinalAnswerTemperature(temperature float64) Option {
+	return func(a *BaseAgent) error {
+		a.finalAnswerTemperature = models.Float(temperature)
+		return nil
+	}
+}
+
+// finalAnswerContext returns ctx carrying the final-answer temperature, if set.
+func (a *BaseAgent) finalAnswerContext(ctx context.Context) context.Context {
+	if a.finalAnswerTemperature == nil {
+		return ctx
+	}
+	return models.ContextWithGenerationParams(ctx, models.GenerationParams{
+		Temperature: a.finalAnswerTemperature,
+	})
+}
+
+// finalAnswer returns the final answer of a step whose last message is the
+// response that was detected as the final answer. With a final-answer
+// temperature, the response is regenerated at that temperature; the original
+// response is kept if the regenerated one turns out to be a tool call.
+func (a *BaseAgent) finalAnswer(ctx context.Context, step *memory.ActionStep, response string) (any, error) {
+	if a.finalAnswerTemperature == nil {
+		answer := a.splitAnswer(step, response)
+		a.streamAnswer(answer)
+		return answer, nil
+	}
+
+	messages := step.Messages[:len(step.Messages)-1]
+	regenerated, err := a.generate(a.finalAnswerContext(ctx), step, messages, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if call, err := parseToolCall(regenerated, a.tools, a.lenientJSON, a.maxCodeBlocks); err != nil || call.name != "" {
+		answer := a.splitAnswer(step, response)
+		a.streamAnswer(answer)
+		return answer, nil
+	}
+
+	step.Messages[len(step.Messages)-1].Content = regenerated
+	answer := a.splitAnswer(step, regenerated)
+	a.streamAnswer(answer)
+	return answer, nil
+}
+
+// WithToolsBeforeFinalAnswer sets whether the other tool calls of a step that
+// calls the final_answer tool are executed before the run ends. By default
+// they are skipped, since the model has already given its answer.
+func WithToolsBeforeFinalAnswer(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.toolsBeforeFinalAnswer = enabled
+		return nil
+	}
+}
+
+// withFinalAnswerTool returns available with tools.FinalAnswerTool added,
+// unless a tool of that name is already registered.
+func withFinalAnswerTool(available []tools.Tool) []tools.Tool {
+	for _, tool := range available {
+		if tool.Name() == tools.FinalAnswerToolName {
+			return available
+		}
+	}
+
+	registered := make([]tools.Tool, 0, len(available)+1)
+	registered = append(registered, available...)
+	return append(registered, tools.FinalAnswerTool())
+}
+
+// findFinalAnswerCall returns the first call of the final_answer tool among
+// calls.
+func (a *BaseAgent) findFinalAnswerCall(calls []toolCall) (toolCall, bool) {
+	for _, call := range calls {
+		if call.name == tools.FinalAnswerToolName {
+			return call, true
+		}
+	}
+	return toolCall{}, false
+}
+
+// finalAnswerFromCall records a call of the final_answer tool and returns its
+// argument as the final answer: the "answer" argument, the only argument, or
+// all the arguments if there are several.
+func (a *BaseAgent) finalAnswerFromCall(call toolCall) any {
+	var answer any = call.args
+	if value, ok := call.args["answer"]; ok {
+		answer = value
+	} else if len(call.args) == 1 {
+		for _, value := range call.args {
+			answer = value
+		}
+	}
+
+	a.notifyToolCall(a.memory.AddToolCallWithID(call.id, call.name, call.args, answer, nil))
+	a.streamAnswer(answer)
+	return answer
+}
@@ -0,0 +1,99 @@
+package agents
+
+import (
+	"strings"
+	"unicode"
+)
+
+// repairJSON fixes common mistakes models make when emitting JSON: single
+// quoted strings, unquoted object keys and trailing commas. Valid JSON is
+// returned unchanged.
+func repairJSON(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		switch {
+		case c == '"':
+			// Copy double quoted strings verbatim
+			end := scanString(runes, i, '"')
+			b.WriteString(string(runes[i:end]))
+			i = end - 1
+
+		case c == '\'':
+			// Rewrite single quoted strings as double quoted strings
+			end := scanString(runes, i, '\'')
+			b.WriteRune('"')
+			for j := i + 1; j < end-1; j++ {
+				switch {
+				case runes[j] == '\\' && j+1 < end-1 && runes[j+1] == '\'':
+					b.WriteRune('\'')
+					j++
+				case runes[j] == '\\' && j+1 < end-1:
+					b.WriteRune(runes[j])
+					b.WriteRune(runes[j+1])
+					j++
+				case runes[j] == '"':
+					b.WriteString(`\"`)
+				default:
+					b.WriteRune(runes[j])
+				}
+			}
+			b.WriteRune('"')
+			i = end - 1
+
+		case c == ',':
+			// Drop trailing commas before a closing brace or bracket
+			next := skipSpace(runes, i+1)
+			if next < len(runes) && (runes[next] == '}' || runes[next] == ']') {
+				continue
+			}
+			b.WriteRune(c)
+
+		case c == '_' || unicode.IsLetter(c):
+			// Quote bare identifiers used as object keys
+			end := i
+			for end < len(runes) && (runes[end] == '_' || unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+				end++
+			}
+			ident := string(runes[i:end])
+			next := skipSpace(runes, end)
+			if next < len(runes) && runes[next] == ':' {
+				b.WriteString(`"` + ident + `"`)
+			} else {
+				b.WriteString(ident)
+			}
+			i = end - 1
+
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// scanString returns the index just past the closing quote of the string
+// starting at runes[start], or len(runes) if the string is unterminated.
+func scanString(runes []rune, start int, quote rune) int {
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(runes)
+}
+
+// skipSpace returns the index of the next non-whitespace rune at or after i.
+func skipSpace(runes []rune, i int) int {
+	for i < len(runes) && unicode.IsSpace(runes[i]) {
+		i++
+	}
+	return i
+}
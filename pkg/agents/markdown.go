@@ -0,0 +1,59 @@
+package agents
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdFence      = regexp.MustCompile("^\\s*(```|~~~)")
+	mdHeading    = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`)
+	mdBlockquote = regexp.MustCompile(`^\s*>\s?`)
+	mdListItem   = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	mdRule       = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	mdImage      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdBold       = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	mdItalic     = regexp.MustCompile(`(^|[^\w*])[*_]([^*_\s][^*_]*?)[*_]([^\w*]|$)`)
+	mdStrike     = regexp.MustCompile(`~~(.+?)~~`)
+	mdInlineCode = regexp.MustCompile("`([^`]+)`")
+)
+
+// stripMarkdown converts markdown text to plain text. Code blocks keep their
+// content without the fences, links keep their text, and emphasis, headings
+// and blockquote markers are removed. List items are normalized to "- ".
+func stripMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	inCode := false
+
+	for _, line := range lines {
+		if mdFence.MatchString(line) {
+			inCode = !inCode
+			continue
+		}
+
+		if inCode {
+			out = append(out, line)
+			continue
+		}
+
+		if mdRule.MatchString(line) {
+			continue
+		}
+
+		line = mdHeading.ReplaceAllString(line, "")
+		line = mdBlockquote.ReplaceAllString(line, "")
+		line = mdListItem.ReplaceAllString(line, "$1- ")
+		line = mdImage.ReplaceAllString(line, "$1")
+		line = mdLink.ReplaceAllString(line, "$1")
+		line = mdBold.ReplaceAllString(line, "$2")
+		line = mdItalic.ReplaceAllString(line, "$1$2$3")
+		line = mdStrike.ReplaceAllString(line, "$1")
+		line = mdInlineCode.ReplaceAllString(line, "$1")
+
+		out = append(out, line)
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
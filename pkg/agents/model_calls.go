@@ -0,0 +1,73 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMaxModelCallsExceeded is returned when a run would exceed the limit set
+// with WithMaxModelCalls.
+var ErrMaxModelCallsExceeded = errors.New("maximum number of model calls exceeded")
+
+// WithMaxModelCalls caps the number of model calls per run at n, counting
+// every call the agent makes: steps, context-window retries, final-answer
+// regeneration, argument repair and tool result summarization. The run aborts
+// with ErrMaxModelCallsExceeded when a call would exceed the cap. Zero means
+// no limit.
+func WithMaxModelCalls(n int) Option {
+	return func(a *BaseAgent) error {
+		if n < 0 {
+			return errors.New("max model calls must not be negative")
+		}
+		a.maxModelCalls = n
+		return nil
+	}
+}
+
+// countModelCall records a model call, failing if it would exceed the cap.
+func (a *BaseAgent) countModelCall() error {
+	if a.maxModelCalls > 0 && a.modelCalls >= a.maxModelCalls {
+		return fmt.Errorf("%w (%d)", ErrMaxModelCallsExceeded, a.maxModelCalls)
+	}
+	a.modelCalls++
+	return nil
+}
+
+// awaitModel runs a model call with the run context, returning as soon as ctx
+// is done even if the model ignores it; in that case the call keeps running in
+// the background and its response is discarded. A panic in the call is
+// re-raised on the caller's goroutine.
+func awaitModel(ctx context.Context, call func() (string, error)) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	type modelResult struct {
+		response string
+		err      error
+		panicked any
+	}
+
+	done := make(chan modelResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- modelResult{panicked: r}
+			}
+		}()
+
+		response, err := call()
+		done <- modelResult{response: response, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.panicked != nil {
+			panic(res.panicked)
+		}
+		return res.response, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+package agents
+
+import (
+	"strings"
+
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// WithPinnedSystemInstructions pins instructions, such as safety rules, at
+// the start of the system prompt. Pinned instructions are kept whatever the
+// system prompt is set to, and every model call starts with them, including
+// calls retried with a trimmed history.
+func WithPinnedSystemInstructions(instructions string) Option {
+	return func(a *BaseAgent) error {
+		a.pinnedInstructions = append(a.pinnedInstructions, instructions)
+		return nil
+	}
+}
+
+// pinnedText returns the pinned instructions, separated by blank lines.
+func (a *BaseAgent) pinnedText() string {
+	parts := make([]string, 0, len(a.pinnedInstructions))
+	for _, part := range a.pinnedInstructions {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// pinInstructions returns messages starting with the pinned instructions,
+// prepending them as a system message unless the first message already
+// starts with them.
+func (a *BaseAgent) pinInstructions(messages []models.Message) []models.Message {
+	pinned := a.pinnedText()
+	if pinned == "" {
+		return messages
+	}
+	if len(messages) > 0 && messages[0].Role == models.RoleSystem && strings.HasPrefix(messages[0].Content, pinned) {
+		return messages
+	}
+
+	pinnedMessages := make([]models.Message, 0, len(messages)+1)
+	pinnedMessages = append(pinnedMessages, models.Message{Role: models.RoleSystem, Content: pinned})
+	return append(pinnedMessages, messages...)
+}
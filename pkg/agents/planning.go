@@ -0,0 +1,170 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// DefaultPlanningInterval is the number of steps between plans of agents
+// created by NewPlanningAgent.
+const DefaultPlanningInterval = 3
+
+// planningPrompt is the system prompt of planning calls.
+const planningPrompt = `You are planning how to solve a task with the tools available.
+Review the task and the progress made so far, then list:
+- the facts given in the task, the facts learned so far, and the facts still to look up or derive;
+- a step-by-step plan for the rest of the task, building on the progress made so far.
+Answer in this format, without calling any tool:
+
+## Facts
+<facts>
+
+## Plan
+<plan>`
+
+// PlanningAgent is a tool-calling agent that periodically surveys the facts
+// of its task and updates its plan.
+type PlanningAgent struct {
+	*ToolCallingAgent
+}
+
+// NewPlanningAgent creates a new PlanningAgent with the given tools and
+// model. It plans before its first step and every DefaultPlanningInterval
+// steps after that, unless WithPlanningInterval sets another interval.
+func NewPlanningAgent(tools []tools.Tool, model models.Model, opts ...Option) (*PlanningAgent, error) {
+	opts = append([]Option{WithPlanningInterval(DefaultPlanningInterval)}, opts...)
+	toolCallingAgent, err := NewToolCallingAgent(tools, model, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	agent := &PlanningAgent{
+		ToolCallingAgent: toolCallingAgent,
+	}
+
+	// Set default agent properties if not overridden by options
+	if agent.name == "ToolCallingAgent" {
+		agent.name = "PlanningAgent"
+	}
+
+	if agent.description == "An agent specialized in calling tools and handling their output" {
+		agent.description = "An agent that plans its use of tools and updates the plan as it goes"
+	}
+
+	return agent, nil
+}
+
+// WithPlanningInterval makes the agent plan before its first step and every
+// n steps after that. Each plan asks the model for a survey of the facts and
+// an updated plan, records them in memory as a planning step, and adds them
+// to the prompts of the following steps. Zero disables planning.
+func WithPlanningInterval(n int) Option {
+	return func(a *BaseAgent) error {
+		if n < 0 {
+			return errors.New("planning interval must not be negative")
+		}
+		a.planningInterval = n
+		return nil
+	}
+}
+
+// WithMaxPlanningSteps caps the number of planning steps of a run at n,
+// whatever the planning interval; once the cap is reached, the agent keeps
+// the last plan and skips further planning. Zero, the default, sets no cap.
+func WithMaxPlanningSteps(n int) Option {
+	return func(a *BaseAgent) error {
+		if n < 0 {
+			return errors.New("max planning steps must not be negative")
+		}
+		a.maxPlanningSteps = n
+		return nil
+	}
+}
+
+// shouldPlan reports whether the agent plans before the given step of the
+// run, counted from zero.
+func (a *BaseAgent) shouldPlan(step int) bool {
+	if a.planningInterval <= 0 || step%a.planningInterval != 0 {
+		return false
+	}
+	return a.maxPlanningSteps == 0 || a.planningSteps < a.maxPlanningSteps
+}
+
+// updatePlan asks the model for the facts and an updated plan, and records
+// them as a planning step that becomes the current plan.
+func (a *BaseAgent) updatePlan(ctx context.Context, task string) error {
+	messages := []models.Message{
+		{
+			Role:    models.RoleSystem,
+			Content: planningPrompt,
+		},
+	}
+	if len(a.tools) > 0 {
+		messages = append(messages, models.Message{
+			Role:    models.RoleSystem,
+			Content: a.buildToolsDescription(),
+		})
+	}
+	for _, msg := range conversationHistory(a.memory) {
+		if msg.Role != models.RoleSystem {
+			messages = append(messages, msg)
+		}
+	}
+	messages = append(messages, models.Message{
+		Role:    models.RoleUser,
+		Content: fmt.Sprintf("Task: %s\n\nSurvey the facts and write the plan for the rest of the task.", task),
+	})
+
+	if err := a.countModelCall(); err != nil {
+		return err
+	}
+	ctx = models.ContextWithDefaultGenerationParams(ctx, a.generationParams)
+	response, err := awaitModel(ctx, func() (string, error) {
+		return a.model.Generate(ctx, messages)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate plan: %w", err)
+	}
+
+	facts, plan := parsePlan(response)
+	messages = append(messages, models.Message{
+		Role:    models.RoleAssistant,
+		Content: response,
+	})
+	a.currentPlan = a.memory.AddPlanningStep(facts, plan, messages)
+	a.planningSteps++
+	a.memory.CompleteCurrentStep()
+
+	return nil
+}
+
+// parsePlan splits a planning response into its facts and plan sections. A
+// response without a plan heading is taken as the plan.
+func parsePlan(response string) (facts, plan string) {
+	before, after, ok := strings.Cut(response, "## Plan")
+	if !ok {
+		return "", strings.TrimSpace(response)
+	}
+
+	facts = strings.TrimSpace(before)
+	if _, rest, ok := strings.Cut(facts, "## Facts"); ok {
+		facts = strings.TrimSpace(rest)
+	}
+	return facts, strings.TrimSpace(after)
+}
+
+// planMessage formats a planning step for the prompts of the following steps.
+func planMessage(step *memory.PlanningStep) string {
+	var builder strings.Builder
+	if step.Facts != "" {
+		fmt.Fprintf(&builder, "Facts so far:\n%s\n\n", step.Facts)
+	}
+	fmt.Fprintf(&builder, "Current plan:\n%s", step.Plan)
+	return builder.String()
+}
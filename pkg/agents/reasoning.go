@@ -0,0 +1,55 @@
+package agents
+
+import (
+	"strings"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+)
+
+// DefaultAnswerMarker separates reasoning from the answer when
+// WithReasoningSplit is given no markers.
+const DefaultAnswerMarker = "Final Answer:"
+
+// WithReasoningSplit splits final answers into reasoning and answer at the
+// last occurrence of any of markers, defaulting to DefaultAnswerMarker. The
+// run returns only the answer; the reasoning is stored on the step's
+// Reasoning field, which is included in RunResult.Steps. Responses without a
+// marker are returned unchanged.
+func WithReasoningSplit(markers ...string) Option {
+	return func(a *BaseAgent) error {
+		if len(markers) == 0 {
+			markers = []string{DefaultAnswerMarker}
+		}
+		a.answerMarkers = markers
+		return nil
+	}
+}
+
+// splitAnswer returns the answer part of response, recording the reasoning
+// that precedes it on step.
+func (a *BaseAgent) splitAnswer(step *memory.ActionStep, response string) string {
+	reasoning, answer, ok := splitReasoning(response, a.answerMarkers)
+	if !ok {
+		return response
+	}
+	step.Reasoning = reasoning
+	return answer
+}
+
+// splitReasoning splits response at the last occurrence of any marker.
+func splitReasoning(response string, markers []string) (reasoning, answer string, ok bool) {
+	at, length := -1, 0
+	for _, marker := range markers {
+		if marker == "" {
+			continue
+		}
+		if i := strings.LastIndex(response, marker); i > at {
+			at, length = i, len(marker)
+		}
+	}
+	if at < 0 {
+		return "", response, false
+	}
+
+	return strings.TrimSpace(response[:at]), strings.TrimSpace(response[at+length:]), true
+}
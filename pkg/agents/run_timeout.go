@@ -0,0 +1,43 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRunTimeout is returned when a run exceeds the limit set with WithTimeout.
+var ErrRunTimeout = errors.New("agent run timed out")
+
+// WithTimeout bounds the wall-clock time of each run. The run's context gets
+// a deadline of d, so in-flight model and tool calls are cancelled when it
+// passes, and the run fails with an error wrapping ErrRunTimeout. The memory
+// and the RunResult keep the steps completed so far. Zero means no limit.
+func WithTimeout(d time.Duration) Option {
+	return func(a *BaseAgent) error {
+		if d < 0 {
+			return errors.New("timeout must not be negative")
+		}
+		a.timeout = d
+		return nil
+	}
+}
+
+// withRunTimeout derives the run's context from ctx, with the deadline set
+// by WithTimeout, if any.
+func (a *BaseAgent) withRunTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, a.timeout, ErrRunTimeout)
+}
+
+// runTimeoutError wraps err in ErrRunTimeout if the run's context expired
+// because of the run timeout.
+func (a *BaseAgent) runTimeoutError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrRunTimeout) || !errors.Is(context.Cause(ctx), ErrRunTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w after %v: %w", ErrRunTimeout, a.timeout, err)
+}
@@ -0,0 +1,110 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/epuerta9/smolagents-go/pkg/models"
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// DefaultToolResultSummaryThreshold is the size in characters above which a
+// tool result is summarized when a summarizer is configured.
+const DefaultToolResultSummaryThreshold = 4000
+
+// DefaultSummaryMaxTokens is the default length target of a summary.
+const DefaultSummaryMaxTokens = 500
+
+// DefaultSummarizationPrompt is the default system prompt of the summarizer
+// model.
+const DefaultSummarizationPrompt = `You summarize tool outputs for an agent working on a task.
+Keep every fact, value, and identifier that could be relevant to the task and drop everything else.
+Respond with the summary only.`
+
+// WithToolResultSummarizer has model summarize tool results larger than the
+// summary threshold before they are added to the step as observations. The
+// full result is still recorded on the step's tool call.
+func WithToolResultSummarizer(model models.Model) Option {
+	return func(a *BaseAgent) error {
+		if model == nil {
+			return fmt.Errorf("summarizer model is required")
+		}
+		a.summarizer = model
+		return nil
+	}
+}
+
+// WithToolResultSummaryThreshold sets the size in characters above which tool
+// results are summarized. It has no effect without WithToolResultSummarizer.
+func WithToolResultSummaryThreshold(chars int) Option {
+	return func(a *BaseAgent) error {
+		if chars <= 0 {
+			return fmt.Errorf("summary threshold must be greater than 0")
+		}
+		a.summaryThreshold = chars
+		return nil
+	}
+}
+
+// WithSummarizationPrompt replaces DefaultSummarizationPrompt as the system
+// prompt of the summarizer model.
+func WithSummarizationPrompt(prompt string) Option {
+	return func(a *BaseAgent) error {
+		if prompt == "" {
+			return fmt.Errorf("summarization prompt must not be empty")
+		}
+		a.summarizationPrompt = prompt
+		return nil
+	}
+}
+
+// WithSummaryMaxTokens sets the length target of summaries in tokens. The
+// summarizer is asked to stay within it and its generations are capped at it.
+func WithSummaryMaxTokens(tokens int) Option {
+	return func(a *BaseAgent) error {
+		if tokens <= 0 {
+			return fmt.Errorf("summary max tokens must be greater than 0")
+		}
+		a.summaryMaxTokens = tokens
+		return nil
+	}
+}
+
+// observation returns the observation to record for a tool result, as
+// rendered by the tool, summarizing it first when it exceeds the summary
+// threshold. Repeated results are replaced with a reference when
+// deduplication is enabled.
+func (a *BaseAgent) observation(ctx context.Context, toolName string, result any) (string, error) {
+	tool, _ := a.findTool(toolName)
+	resultStr := tools.RenderOutput(tool, result)
+	if reference, ok := a.duplicateObservation(toolName, resultStr); ok {
+		return reference, nil
+	}
+	if a.summarizer == nil || len(resultStr) <= a.summaryThreshold {
+		return resultStr, nil
+	}
+
+	messages := []models.Message{
+		{
+			Role:    models.RoleSystem,
+			Content: fmt.Sprintf("%s\nKeep the summary under %d tokens.", a.summarizationPrompt, a.summaryMaxTokens),
+		},
+		{
+			Role:    models.RoleUser,
+			Content: fmt.Sprintf("Task: %s\n\nOutput of tool %s:\n%s", a.task, toolName, resultStr),
+		},
+	}
+
+	if err := a.countModelCall(); err != nil {
+		return "", err
+	}
+	ctx = models.ContextWithGenerationParams(ctx, models.GenerationParams{MaxTokens: a.summaryMaxTokens})
+	summary, err := awaitModel(ctx, func() (string, error) {
+		return a.summarizer.Generate(ctx, messages)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize result of tool %s: %w", toolName, err)
+	}
+
+	return fmt.Sprintf("Summary of %d characters of output: %s", len(resultStr), summary), nil
+}
@@ -2,9 +2,20 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/epuerta9/smolagents-go/pkg/agents"
+	"github.com/epuerta9/smolagents-go/pkg/memory"
 	"github.com/epuerta9/smolagents-go/pkg/models"
 	"github.com/epuerta9/smolagents-go/pkg/tools"
 )
@@ -26,12 +37,39 @@ func (m *MockModel) GenerateWithTools(ctx context.Context, messages []models.Mes
 	return m.Generate(ctx, messages)
 }
 
+// ScriptedModel implements the models.Model interface by returning a fixed
+// sequence of responses, repeating the last one once the script runs out.
+type ScriptedModel struct {
+	responses []string
+	calls     [][]models.Message
+	toolCalls int
+}
+
+func (m *ScriptedModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	m.calls = append(m.calls, messages)
+	idx := len(m.calls) - 1
+	if idx >= len(m.responses) {
+		idx = len(m.responses) - 1
+	}
+	return m.responses[idx], nil
+}
+
+func (m *ScriptedModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	m.toolCalls++
+	return m.Generate(ctx, messages)
+}
+
+// toolCallResponse is a model response that calls test_tool.
+const toolCallResponse = "```json\n{\"tool\": \"test_tool\", \"args\": {\"arg1\": \"value\"}}\n```"
+
 // MockTool implements the tools.Tool interface for testing
 type MockTool struct {
 	name        string
 	description string
 	output      any
 	err         error
+	delay       time.Duration
+	calls       int
 }
 
 func (t *MockTool) Name() string        { return t.name }
@@ -48,6 +86,8 @@ func (t *MockTool) Schema() *tools.ToolSchema {
 	}
 }
 func (t *MockTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	t.calls++
+	time.Sleep(t.delay)
 	if t.err != nil {
 		return nil, t.err
 	}
@@ -265,3 +305,3422 @@ func TestAgentOptions(t *testing.T) {
 		})
 	}
 }
+
+// TestStepLimitBehavior tests each of the step limit behaviors
+func TestStepLimitBehavior(t *testing.T) {
+	mockTool := &MockTool{
+		name:        "test_tool",
+		description: "A test tool",
+		output:      "tool output",
+	}
+
+	tests := []struct {
+		name           string
+		behavior       agents.StepLimitBehavior
+		responses      []string
+		expectedResult any
+		wantErr        bool
+		expectedCalls  int
+	}{
+		{
+			name:          "error",
+			behavior:      agents.StepLimitError,
+			responses:     []string{toolCallResponse},
+			wantErr:       true,
+			expectedCalls: 2,
+		},
+		{
+			name:           "partial",
+			behavior:       agents.StepLimitPartial,
+			responses:      []string{toolCallResponse},
+			expectedResult: toolCallResponse,
+			expectedCalls:  2,
+		},
+		{
+			name:           "force answer",
+			behavior:       agents.StepLimitForceAnswer,
+			responses:      []string{toolCallResponse, toolCallResponse, "best answer so far"},
+			expectedResult: "best answer so far",
+			expectedCalls:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ScriptedModel{responses: tt.responses}
+
+			agent, err := agents.NewCodeAgent(
+				[]tools.Tool{mockTool},
+				model,
+				agents.WithMaxSteps(2),
+				agents.WithStepLimitBehavior(tt.behavior),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			result, err := agent.Run(context.Background(), "test task")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && result != tt.expectedResult {
+				t.Errorf("Run() = %v, want %v", result, tt.expectedResult)
+			}
+
+			if len(model.calls) != tt.expectedCalls {
+				t.Errorf("Expected %d model calls, got %d", tt.expectedCalls, len(model.calls))
+			}
+
+			if tt.behavior == agents.StepLimitForceAnswer {
+				last := model.calls[len(model.calls)-1]
+				if !strings.Contains(last[len(last)-1].Content, "maximum number of steps") {
+					t.Errorf("Expected forced answer prompt, got %q", last[len(last)-1].Content)
+				}
+			}
+		})
+	}
+}
+
+// TestStepLimitBehaviorInvalid tests that an unknown behavior is rejected
+func TestStepLimitBehaviorInvalid(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+
+	_, err := agents.NewBaseAgent([]tools.Tool{mockTool}, &MockModel{}, agents.WithStepLimitBehavior(42))
+	if err == nil {
+		t.Error("Expected error for unknown step limit behavior")
+	}
+}
+
+// TestRunWithTraceSizes tests that size metrics are recorded per step
+func TestRunWithTraceSizes(t *testing.T) {
+	mockTool := &MockTool{
+		name:        "test_tool",
+		description: "A test tool",
+		output:      "tool output",
+	}
+	model := &ScriptedModel{responses: []string{toolCallResponse, "final answer"}}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("RunWithTrace() error = %v", err)
+	}
+
+	if result.FinalAnswer != "final answer" {
+		t.Errorf("Expected final answer 'final answer', got %v", result.FinalAnswer)
+	}
+
+	if len(result.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(result.Steps))
+	}
+
+	var total int
+	for i, step := range result.Steps {
+		if step.Sizes.InputChars == 0 || step.Sizes.InputTokens == 0 {
+			t.Errorf("Step %d: expected non-zero input sizes, got %+v", i, step.Sizes)
+		}
+		if step.Sizes.OutputChars == 0 || step.Sizes.OutputTokens == 0 {
+			t.Errorf("Step %d: expected non-zero output sizes, got %+v", i, step.Sizes)
+		}
+		total += step.Sizes.InputTokens
+	}
+
+	if result.Sizes.InputTokens != total {
+		t.Errorf("Expected aggregate input tokens %d, got %d", total, result.Sizes.InputTokens)
+	}
+}
+
+// TestToolCallPrecedence tests that an explicit JSON tool call takes
+// precedence over a code-form call in both agent types
+func TestToolCallPrecedence(t *testing.T) {
+	response := "Let me look that up.\n" +
+		"```python\ncode_tool(arg1=\"from code\")\n```\n" +
+		"```json\n{\"tool\": \"json_tool\", \"args\": {\"arg1\": \"from json\"}}\n```"
+
+	t.Run("CodeAgent", func(t *testing.T) {
+		jsonTool := &MockTool{name: "json_tool", description: "JSON tool", output: "json"}
+		codeTool := &MockTool{name: "code_tool", description: "Code tool", output: "code"}
+
+		agent, err := agents.NewCodeAgent([]tools.Tool{jsonTool, codeTool}, &MockModel{generateResponse: response})
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+
+		if jsonTool.calls != 1 || codeTool.calls != 0 {
+			t.Errorf("Expected only json_tool to be called, got json_tool=%d code_tool=%d", jsonTool.calls, codeTool.calls)
+		}
+	})
+
+	t.Run("ToolCallingAgent", func(t *testing.T) {
+		jsonTool := &MockTool{name: "json_tool", description: "JSON tool", output: "json"}
+		codeTool := &MockTool{name: "code_tool", description: "Code tool", output: "code"}
+
+		agent, err := agents.NewToolCallingAgent([]tools.Tool{jsonTool, codeTool}, &MockModel{generateResponse: response})
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+
+		if jsonTool.calls != 1 || codeTool.calls != 0 {
+			t.Errorf("Expected only json_tool to be called, got json_tool=%d code_tool=%d", jsonTool.calls, codeTool.calls)
+		}
+	})
+
+	t.Run("code-form fallback", func(t *testing.T) {
+		codeTool := &MockTool{name: "code_tool", description: "Code tool", output: "code"}
+
+		agent, err := agents.NewToolCallingAgent([]tools.Tool{codeTool}, &MockModel{
+			generateResponse: "```python\ncode_tool(arg1=\"from code\")\n```",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create agent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+
+		if codeTool.calls != 1 {
+			t.Errorf("Expected code_tool to be called once, got %d", codeTool.calls)
+		}
+	})
+}
+
+// TestToolResultDigest tests that previous tool results are injected as a digest
+func TestToolResultDigest(t *testing.T) {
+	mockTool := &MockTool{
+		name:        "test_tool",
+		description: "A test tool",
+		output:      "the capital is Paris",
+	}
+
+	for _, enabled := range []bool{true, false} {
+		model := &ScriptedModel{responses: []string{toolCallResponse, "final answer"}}
+
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithToolResultDigest(enabled))
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		if _, err := agent.Run(context.Background(), "test task"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+
+		var found bool
+		for _, msg := range model.calls[1] {
+			if strings.Contains(msg.Content, "step | tool | key result") {
+				found = true
+				if !strings.Contains(msg.Content, "1 | test_tool | the capital is Paris") {
+					t.Errorf("Expected digest to include the tool result, got %q", msg.Content)
+				}
+			}
+		}
+
+		if found != enabled {
+			t.Errorf("Digest present = %v, want %v", found, enabled)
+		}
+
+		for _, msg := range model.calls[0] {
+			if strings.Contains(msg.Content, "step | tool | key result") {
+				t.Error("Expected no digest before any tool was called")
+			}
+		}
+	}
+}
+
+// SlowTool is a tool that blocks until its context is cancelled or a long
+// delay elapses, optionally ignoring the context entirely
+type SlowTool struct {
+	ignoreContext bool
+	cancelled     chan struct{}
+}
+
+func (t *SlowTool) Name() string        { return "test_tool" }
+func (t *SlowTool) Description() string { return "A slow tool" }
+func (t *SlowTool) Schema() *tools.ToolSchema {
+	return &tools.ToolSchema{Type: "object", Properties: map[string]tools.PropertyDef{}}
+}
+func (t *SlowTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	if t.ignoreContext {
+		time.Sleep(2 * time.Second)
+		return "done", nil
+	}
+
+	select {
+	case <-ctx.Done():
+		close(t.cancelled)
+		return nil, ctx.Err()
+	case <-time.After(5 * time.Second):
+		return "done", nil
+	}
+}
+
+// TestRunTimeoutCancelsToolCall tests that a run timeout cancels an in-flight tool call
+func TestRunTimeoutCancelsToolCall(t *testing.T) {
+	for _, ignoreContext := range []bool{false, true} {
+		slowTool := &SlowTool{ignoreContext: ignoreContext, cancelled: make(chan struct{})}
+		model := &ScriptedModel{responses: []string{toolCallResponse}}
+
+		agent, err := agents.NewCodeAgent([]tools.Tool{slowTool}, model)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, err = agent.Run(ctx, "test task")
+		elapsed := time.Since(start)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+
+		if elapsed > time.Second {
+			t.Errorf("Expected run to return promptly, took %v", elapsed)
+		}
+
+		if !ignoreContext {
+			select {
+			case <-slowTool.cancelled:
+			case <-time.After(time.Second):
+				t.Error("Expected the tool to observe the cancelled context")
+			}
+		}
+	}
+}
+
+// TestIDGenerator tests that an injected ID generator produces predictable IDs
+func TestIDGenerator(t *testing.T) {
+	mockTool := &MockTool{
+		name:        "test_tool",
+		description: "A test tool",
+		output:      "tool output",
+	}
+	model := &ScriptedModel{responses: []string{toolCallResponse, "final answer"}}
+
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithIDGenerator(memory.NewSequentialIDGenerator("id-")),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("RunWithTrace() error = %v", err)
+	}
+
+	// The run ID comes first, followed by the system prompt and task steps
+	if result.RunID != "id-1" {
+		t.Errorf("Expected run ID 'id-1', got '%s'", result.RunID)
+	}
+
+	if len(result.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(result.Steps))
+	}
+
+	if result.Steps[0].ID != "id-4" {
+		t.Errorf("Expected first action step ID 'id-4', got '%s'", result.Steps[0].ID)
+	}
+
+	if len(result.Steps[0].ToolCalls) != 1 || result.Steps[0].ToolCalls[0].ID != "id-5" {
+		t.Errorf("Expected tool call ID 'id-5', got %+v", result.Steps[0].ToolCalls)
+	}
+
+	if result.Steps[1].ID != "id-6" {
+		t.Errorf("Expected second action step ID 'id-6', got '%s'", result.Steps[1].ID)
+	}
+}
+
+// TestAnswerMarkdown tests stripping and preserving markdown in the final answer
+func TestAnswerMarkdown(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+	answer := "# Result\n\nThe **capital** of _France_ is [Paris](https://en.wikipedia.org/wiki/Paris).\n\n" +
+		"* uses `euro`\n* > 2M people\n\n```\nprint(1)\n```"
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		expected string
+	}{
+		{
+			name:     "preserved",
+			enabled:  true,
+			expected: answer,
+		},
+		{
+			name:    "stripped",
+			enabled: false,
+			expected: "Result\n\nThe capital of France is Paris.\n\n" +
+				"- uses euro\n- > 2M people\n\nprint(1)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, err := agents.NewCodeAgent(
+				[]tools.Tool{mockTool},
+				&MockModel{generateResponse: answer},
+				agents.WithAnswerMarkdown(tt.enabled),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			result, err := agent.Run(context.Background(), "test task")
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("Run() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestRecordAndReplayRun tests that a replayed run behaves identically to the recorded one
+func TestRecordAndReplayRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	ids := func() agents.Option { return agents.WithIDGenerator(memory.NewSequentialIDGenerator("id-")) }
+
+	recordTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	recorder := models.NewRecordingModel(&ScriptedModel{responses: []string{toolCallResponse, "final answer"}}, path)
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{recordTool}, recorder, ids())
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	recorded, err := agent.RunWithTrace(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("Recorded run error = %v", err)
+	}
+
+	replay, err := models.NewReplayModel(path)
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+
+	replayTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	agent, err = agents.NewCodeAgent([]tools.Tool{replayTool}, replay, ids())
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	replayed, err := agent.RunWithTrace(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("Replayed run error = %v", err)
+	}
+
+	if replayed.FinalAnswer != recorded.FinalAnswer {
+		t.Errorf("Replayed answer = %v, want %v", replayed.FinalAnswer, recorded.FinalAnswer)
+	}
+
+	if len(replayed.Steps) != len(recorded.Steps) {
+		t.Errorf("Replayed %d steps, want %d", len(replayed.Steps), len(recorded.Steps))
+	}
+
+	if replayTool.calls != recordTool.calls {
+		t.Errorf("Replayed %d tool calls, want %d", replayTool.calls, recordTool.calls)
+	}
+}
+
+// TestLenientJSONToolCalls tests that common JSON mistakes in tool calls are repaired
+func TestLenientJSONToolCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     string
+		expected string
+	}{
+		{
+			name:     "trailing comma",
+			call:     `{"tool": "test_tool", "args": {"arg1": "value",},}`,
+			expected: "value",
+		},
+		{
+			name:     "single quotes",
+			call:     `{'tool': 'test_tool', 'args': {'arg1': 'it\'s "quoted"'}}`,
+			expected: `it's "quoted"`,
+		},
+		{
+			name:     "unquoted keys",
+			call:     `{tool: "test_tool", args: {arg1: "a: b"}}`,
+			expected: "a: b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := &ArgsTool{}
+			response := "```json\n" + tt.call + "\n```"
+
+			agent, err := agents.NewCodeAgent([]tools.Tool{tool}, &MockModel{generateResponse: response})
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+			if _, err := agent.Step(context.Background(), step); err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+
+			if tool.args["arg1"] != tt.expected {
+				t.Errorf("Expected arg1 %q, got %q", tt.expected, tool.args["arg1"])
+			}
+
+			// With lenient parsing disabled the same call is rejected
+			agent, err = agents.NewCodeAgent(
+				[]tools.Tool{tool},
+				&MockModel{generateResponse: response},
+				agents.WithLenientJSON(false),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			step = agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+			if _, err := agent.Step(context.Background(), step); err == nil {
+				t.Error("Expected an error with lenient JSON disabled")
+			}
+		})
+	}
+}
+
+// ArgsTool is a tool named test_tool that records the arguments it was called with
+type ArgsTool struct {
+	args map[string]any
+}
+
+func (t *ArgsTool) Name() string        { return "test_tool" }
+func (t *ArgsTool) Description() string { return "Records its arguments" }
+func (t *ArgsTool) Schema() *tools.ToolSchema {
+	return &tools.ToolSchema{
+		Type: "object",
+		Properties: map[string]tools.PropertyDef{
+			"arg1": {Type: "string", Description: "Test argument"},
+		},
+	}
+}
+func (t *ArgsTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	t.args = args
+	return "ok", nil
+}
+
+// TestMaxExposedTools tests that only the most relevant tools are exposed
+func TestMaxExposedTools(t *testing.T) {
+	weather := &MockTool{name: "get_weather", description: "Get the current weather forecast for a city", output: "sunny"}
+	stocks := &MockTool{name: "get_stock_price", description: "Get the latest stock price for a ticker"}
+	email := &MockTool{name: "send_email", description: "Send an email to a recipient"}
+	translate := &MockTool{name: "translate", description: "Translate text to another language"}
+
+	searchCall := "```json\n{\"tool\": \"search_tools\", \"args\": {\"query\": \"send an email\"}}\n```"
+	model := &ScriptedModel{responses: []string{searchCall, "final answer"}}
+
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{stocks, email, weather, translate},
+		model,
+		agents.WithMaxExposedTools(1),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "What is the weather forecast in Paris?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var description string
+	for _, msg := range model.calls[0] {
+		if strings.Contains(msg.Content, "You have access to the following tools") {
+			description = msg.Content
+		}
+	}
+
+	if !strings.Contains(description, "Tool Name: get_weather") {
+		t.Error("Expected the most relevant tool to be exposed")
+	}
+
+	if !strings.Contains(description, "Tool Name: search_tools") {
+		t.Error("Expected the search_tools tool to be exposed")
+	}
+
+	for _, hidden := range []string{"get_stock_price", "send_email", "translate"} {
+		if strings.Contains(description, "Tool Name: "+hidden) {
+			t.Errorf("Expected %s not to be exposed", hidden)
+		}
+	}
+
+	// Hidden tools can be discovered through search_tools
+	calls := result.Steps[0].ToolCalls
+	if len(calls) != 1 || !strings.Contains(fmt.Sprint(calls[0].Output), "Tool Name: send_email") {
+		t.Errorf("Expected search_tools to find send_email, got %+v", calls)
+	}
+}
+
+// DelayedModel wraps a model and sleeps before each response
+type DelayedModel struct {
+	models.Model
+	delay time.Duration
+}
+
+func (m *DelayedModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	time.Sleep(m.delay)
+	return m.Model.Generate(ctx, messages)
+}
+
+func (m *DelayedModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	time.Sleep(m.delay)
+	return m.Model.GenerateWithTools(ctx, messages, tools)
+}
+
+// TestModelLatency tests that model latency is recorded per step and aggregated
+func TestModelLatency(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	model := &DelayedModel{
+		Model: &ScriptedModel{responses: []string{toolCallResponse, "final answer"}},
+		delay: 20 * time.Millisecond,
+	}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("RunWithTrace() error = %v", err)
+	}
+
+	var total time.Duration
+	for i, step := range result.Steps {
+		if step.ModelLatency < model.delay {
+			t.Errorf("Step %d: expected model latency of at least %v, got %v", i, model.delay, step.ModelLatency)
+		}
+		total += step.ModelLatency
+	}
+
+	if result.ModelLatency != total || result.ModelLatency <= 0 {
+		t.Errorf("Expected aggregate model latency %v, got %v", total, result.ModelLatency)
+	}
+}
+
+// TestOutputSchemaValidation tests validating tool outputs against their declared schema
+func TestOutputSchemaValidation(t *testing.T) {
+	// The tool declares an integer output but returns a string
+	buggy := tools.CreateTool[func(string) string]("test_tool", "A tool that drifted from its contract",
+		tools.WithOutputSchema(tools.PropertyDef{Type: "integer"}),
+	)(func(s string) string { return "not a number" })
+
+	response := "```json\n{\"tool\": \"test_tool\", \"args\": {\"arg0\": \"x\"}}\n```"
+
+	t.Run("warning", func(t *testing.T) {
+		agent, err := agents.NewCodeAgent([]tools.Tool{buggy}, &MockModel{generateResponse: response})
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("task", nil)
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+
+		var warned bool
+		for _, msg := range step.Messages {
+			if strings.Contains(msg.Content, "does not match its declared output schema") {
+				warned = true
+			}
+		}
+		if !warned {
+			t.Error("Expected a warning observation for the invalid output")
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		agent, err := agents.NewCodeAgent(
+			[]tools.Tool{buggy},
+			&MockModel{generateResponse: response},
+			agents.WithStrictOutputValidation(true),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("task", nil)
+		if _, err := agent.Step(context.Background(), step); err == nil {
+			t.Error("Expected an error for the invalid output")
+		}
+	})
+}
+
+// TestToolResultCarriesToolCallID tests that tool result messages reference the originating call
+func TestToolResultCarriesToolCallID(t *testing.T) {
+	// Native tool calls arrive as a bare JSON object carrying the provider's id
+	mockModel := &MockModel{
+		generateResponse: `{"id": "call_abc123", "tool": "test_tool", "args": {"arg1": "value"}}`,
+	}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, mockModel)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	step := agent.GetMemory().AddActionStep("task", nil)
+	if _, err := agent.Step(context.Background(), step); err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+
+	if mockTool.calls != 1 {
+		t.Fatalf("Expected the tool to be called once, got %d", mockTool.calls)
+	}
+
+	last := step.Messages[len(step.Messages)-1]
+	if last.Role != models.RoleTool {
+		t.Fatalf("Expected a tool result message, got role %s", last.Role)
+	}
+	if last.ToolCallID != "call_abc123" {
+		t.Errorf("Expected tool call ID 'call_abc123', got '%s'", last.ToolCallID)
+	}
+}
+
+// TestDebugDumpOnError tests that a failed run writes a post-mortem dump
+func TestDebugDumpOnError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dumps")
+	mockModel := &MockModel{generateError: errors.New("model unavailable")}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, mockModel, agents.WithDebugDumpOnError(dir))
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "failing task")
+	if err == nil {
+		t.Fatal("Expected the run to fail")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read dump directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 dump file, got %d", len(entries))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read dump file: %v", err)
+	}
+
+	var dump agents.DebugDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatalf("Failed to parse dump file: %v", err)
+	}
+
+	if dump.RunID != result.RunID {
+		t.Errorf("Expected run ID %s, got %s", result.RunID, dump.RunID)
+	}
+	if dump.Task != "failing task" {
+		t.Errorf("Expected task 'failing task', got '%s'", dump.Task)
+	}
+	if !strings.Contains(dump.Error, "model unavailable") {
+		t.Errorf("Expected dump error to mention the failure, got '%s'", dump.Error)
+	}
+	if len(dump.StepMessages) == 0 {
+		t.Error("Expected the failing step's messages in the dump")
+	}
+	if len(dump.Memory) == 0 {
+		t.Error("Expected the memory steps in the dump")
+	}
+}
+
+// TestToolResultSummarizer tests that large tool results are summarized before being observed
+func TestToolResultSummarizer(t *testing.T) {
+	largeOutput := strings.Repeat("lorem ipsum ", 100)
+
+	tests := []struct {
+		name          string
+		output        string
+		wantSummary   bool
+		wantSummaries int
+	}{
+		{name: "large result", output: largeOutput, wantSummary: true, wantSummaries: 1},
+		{name: "small result", output: "small", wantSummary: false, wantSummaries: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, "done"}}
+			summarizer := &ScriptedModel{responses: []string{"the page repeats lorem ipsum"}}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: tt.output}
+
+			agent, err := agents.NewCodeAgent(
+				[]tools.Tool{mockTool},
+				model,
+				agents.WithToolResultSummarizer(summarizer),
+				agents.WithToolResultSummaryThreshold(500),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			result, err := agent.RunWithTrace(context.Background(), "summarize the page")
+			if err != nil {
+				t.Fatalf("RunWithTrace() error = %v", err)
+			}
+
+			if len(summarizer.calls) != tt.wantSummaries {
+				t.Fatalf("Expected %d summarizer calls, got %d", tt.wantSummaries, len(summarizer.calls))
+			}
+
+			first := result.Steps[0]
+			observation := first.Messages[len(first.Messages)-1].Content
+			if got := strings.Contains(observation, "the page repeats lorem ipsum"); got != tt.wantSummary {
+				t.Errorf("Expected summarized observation = %v, got observation %q", tt.wantSummary, observation)
+			}
+
+			// The full result is kept on the tool call for audit
+			if len(first.ToolCalls) != 1 || first.ToolCalls[0].Output != tt.output {
+				t.Error("Expected the full tool result to be recorded on the tool call")
+			}
+		})
+	}
+}
+
+// ContextLimitedModel implements the models.Model interface by rejecting
+// requests with more than maxMessages messages as a context-window error.
+type ContextLimitedModel struct {
+	maxMessages int
+	calls       [][]models.Message
+}
+
+func (m *ContextLimitedModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	m.calls = append(m.calls, messages)
+	if len(messages) > m.maxMessages {
+		return "", fmt.Errorf("%w: maximum context length is 4096 tokens", models.ErrContextWindowExceeded)
+	}
+	return "final answer", nil
+}
+
+func (m *ContextLimitedModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestContextWindowRecovery tests that a step is retried once with a trimmed history
+func TestContextWindowRecovery(t *testing.T) {
+	history := []models.Message{
+		{Role: models.RoleSystem, Content: "system prompt"},
+		{Role: models.RoleUser, Content: "the task"},
+	}
+	for i := 0; i < 10; i++ {
+		history = append(history,
+			models.Message{Role: models.RoleAssistant, Content: fmt.Sprintf("call %d", i)},
+			models.Message{Role: models.RoleTool, Name: "test_tool", Content: fmt.Sprintf("result %d", i)},
+		)
+	}
+
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	t.Run("recovers after trimming", func(t *testing.T) {
+		model := &ContextLimitedModel{maxMessages: 6}
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("the task", append([]models.Message(nil), history...))
+		result, err := agent.Step(context.Background(), step)
+		if err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+		if result != "final answer" {
+			t.Errorf("Expected 'final answer', got %v", result)
+		}
+
+		if len(model.calls) != 2 {
+			t.Fatalf("Expected 2 model calls, got %d", len(model.calls))
+		}
+		retry := model.calls[1]
+		if retry[0].Content != "system prompt" || retry[1].Content != "the task" {
+			t.Error("Expected the system prompt and task to survive trimming")
+		}
+		if retry[len(retry)-1].Content != "result 9" {
+			t.Errorf("Expected the most recent message to survive trimming, got %q", retry[len(retry)-1].Content)
+		}
+	})
+
+	t.Run("keeps tool-call groups whole", func(t *testing.T) {
+		grouped := append(append([]models.Message(nil), history...),
+			models.Message{Role: models.RoleAssistant, Content: "calls 10 and 11"},
+			models.Message{Role: models.RoleTool, Name: "test_tool", Content: "result 10"},
+			models.Message{Role: models.RoleTool, Name: "test_tool", Content: "result 11"},
+		)
+		model := &ContextLimitedModel{maxMessages: 6}
+		agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model)
+		if err != nil {
+			t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("the task", grouped)
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+
+		retry := model.calls[1]
+		var kept []string
+		for _, msg := range retry[2:] {
+			kept = append(kept, msg.Content)
+		}
+		if got := strings.Join(kept, ", "); got != "calls 10 and 11, result 10, result 11" {
+			t.Errorf("Expected the last tool-call group to be kept whole, got %q", got)
+		}
+	})
+
+	t.Run("fails when trimming is not enough", func(t *testing.T) {
+		model := &ContextLimitedModel{maxMessages: 1}
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("the task", append([]models.Message(nil), history...))
+		if _, err := agent.Step(context.Background(), step); !models.IsContextWindowError(err) {
+			t.Errorf("Expected a context window error, got %v", err)
+		}
+		if len(model.calls) != 2 {
+			t.Errorf("Expected exactly one retry, got %d calls", len(model.calls))
+		}
+	})
+}
+
+// TestExportToolsJSON tests exporting the tools JSON sent to the model
+func TestExportToolsJSON(t *testing.T) {
+	mockTools := []tools.Tool{
+		&MockTool{name: "search", description: "Searches the web"},
+		&MockTool{name: "calculator", description: "Evaluates expressions"},
+	}
+
+	codeAgent, err := agents.NewCodeAgent(mockTools, &MockModel{})
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+	toolCallingAgent, err := agents.NewToolCallingAgent(mockTools, &MockModel{})
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	exporters := map[string]interface{ ExportToolsJSON() ([]byte, error) }{
+		"CodeAgent":        codeAgent,
+		"ToolCallingAgent": toolCallingAgent,
+	}
+
+	for name, exporter := range exporters {
+		t.Run(name, func(t *testing.T) {
+			data, err := exporter.ExportToolsJSON()
+			if err != nil {
+				t.Fatalf("ExportToolsJSON() error = %v", err)
+			}
+
+			var exported []struct {
+				Type     string `json:"type"`
+				Function struct {
+					Name       string           `json:"name"`
+					Parameters tools.ToolSchema `json:"parameters"`
+				} `json:"function"`
+			}
+			if err := json.Unmarshal(data, &exported); err != nil {
+				t.Fatalf("Failed to parse exported JSON: %v", err)
+			}
+
+			// The final_answer tool is registered after the given tools
+			if len(exported) != len(mockTools)+1 {
+				t.Fatalf("Expected %d tools, got %d", len(mockTools)+1, len(exported))
+			}
+			if name := exported[len(mockTools)].Function.Name; name != tools.FinalAnswerToolName {
+				t.Errorf("Expected the final_answer tool last, got '%s'", name)
+			}
+			for i, tool := range mockTools {
+				if exported[i].Type != "function" {
+					t.Errorf("Expected type 'function', got '%s'", exported[i].Type)
+				}
+				if exported[i].Function.Name != tool.Name() {
+					t.Errorf("Expected function name '%s', got '%s'", tool.Name(), exported[i].Function.Name)
+				}
+				if _, ok := exported[i].Function.Parameters.Properties["arg1"]; !ok {
+					t.Errorf("Expected parameters of %s to include 'arg1'", tool.Name())
+				}
+			}
+		})
+	}
+}
+
+// TestArgRepairModel tests that malformed tool calls are repaired by the repair model
+func TestArgRepairModel(t *testing.T) {
+	repaired := `{"tool": "greet", "args": {"arg0": "Ada"}}`
+
+	constructors := []struct {
+		name   string
+		create func([]tools.Tool, models.Model, ...agents.Option) (agents.Agent, error)
+	}{
+		{
+			name: "CodeAgent",
+			create: func(ts []tools.Tool, model models.Model, opts ...agents.Option) (agents.Agent, error) {
+				return agents.NewCodeAgent(ts, model, opts...)
+			},
+		},
+		{
+			name: "ToolCallingAgent",
+			create: func(ts []tools.Tool, model models.Model, opts ...agents.Option) (agents.Agent, error) {
+				return agents.NewToolCallingAgent(ts, model, opts...)
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{
+			name:     "malformed JSON",
+			response: "```json\n{\"tool\": \"greet\", \"args\": {\"arg0\": Ada}\n```",
+		},
+		{
+			name:     "missing required argument",
+			response: "```json\n{\"tool\": \"greet\", \"args\": {\"name\": \"Ada\"}}\n```",
+		},
+	}
+
+	newGreet := func() tools.Tool {
+		return tools.CreateTool[func(string) string]("greet", "Greets a person")(
+			func(name string) string { return "Hello, " + name },
+		)
+	}
+
+	for _, constructor := range constructors {
+		for _, tt := range tests {
+			t.Run(constructor.name+"/"+tt.name, func(t *testing.T) {
+				repairModel := &ScriptedModel{responses: []string{repaired}}
+
+				agent, err := constructor.create(
+					[]tools.Tool{newGreet()},
+					&MockModel{generateResponse: tt.response},
+					agents.WithLenientJSON(false),
+					agents.WithArgRepairModel(repairModel),
+				)
+				if err != nil {
+					t.Fatalf("Failed to create %s: %v", constructor.name, err)
+				}
+
+				step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+				if _, err := agent.Step(context.Background(), step); err != nil {
+					t.Fatalf("Step() error = %v", err)
+				}
+
+				if len(repairModel.calls) != 1 {
+					t.Fatalf("Expected 1 repair call, got %d", len(repairModel.calls))
+				}
+				if last := step.Messages[len(step.Messages)-1]; last.Content != "Hello, Ada" {
+					t.Errorf("Expected the repaired call to run, got observation %q", last.Content)
+				}
+			})
+		}
+
+		t.Run(constructor.name+"/unrepairable", func(t *testing.T) {
+			agent, err := constructor.create(
+				[]tools.Tool{newGreet()},
+				&MockModel{generateResponse: tests[1].response},
+				agents.WithArgRepairModel(&ScriptedModel{responses: []string{"I cannot help"}}),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create %s: %v", constructor.name, err)
+			}
+
+			step := agent.GetMemory().AddActionStep("task", nil)
+			if _, err := agent.Step(context.Background(), step); err == nil {
+				t.Error("Expected an error when the repair model cannot fix the call")
+			}
+		})
+	}
+
+	// Each call of a multi-call response is repaired on its own
+	t.Run("ToolCallingAgent/multiple calls", func(t *testing.T) {
+		response := `[{"id": "call_1", "tool": "greet", "args": "Ada"}, {"id": "call_2", "tool": "greet", "args": {"name": "Grace"}}]`
+		repairModel := &ScriptedModel{responses: []string{
+			repaired,
+			`{"tool": "greet", "args": {"arg0": "Grace"}}`,
+		}}
+
+		agent, err := agents.NewToolCallingAgent(
+			[]tools.Tool{newGreet()},
+			&MockModel{generateResponse: response},
+			agents.WithArgRepairModel(repairModel),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+
+		if len(repairModel.calls) != 2 {
+			t.Fatalf("Expected 2 repair calls, got %d", len(repairModel.calls))
+		}
+		observations := step.Messages[len(step.Messages)-2:]
+		if observations[0].ToolCallID != "call_1" || observations[0].Content != "Hello, Ada" ||
+			observations[1].ToolCallID != "call_2" || observations[1].Content != "Hello, Grace" {
+			t.Errorf("Expected both repaired calls to run under their ids, got %+v", observations)
+		}
+	})
+}
+
+// StreamingModel implements the models.StreamingModel interface by streaming
+// scripted responses in chunks of chunkSize characters.
+type StreamingModel struct {
+	ScriptedModel
+	chunkSize int
+	streamed  int
+}
+
+func (m *StreamingModel) GenerateStream(ctx context.Context, messages []models.Message) (<-chan models.StreamChunk, error) {
+	m.streamed++
+	response, err := m.Generate(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamChunks(response, m.chunkSize), nil
+}
+
+// streamChunks returns a closed channel holding response in chunks of
+// chunkSize characters.
+func streamChunks(response string, chunkSize int) <-chan models.StreamChunk {
+	chunks := make(chan models.StreamChunk, len(response)/chunkSize+1)
+	for i := 0; i < len(response); i += chunkSize {
+		chunks <- models.StreamChunk{Delta: response[i:min(i+chunkSize, len(response))]}
+	}
+	close(chunks)
+	return chunks
+}
+
+// TestTokenCallback tests that final-answer tokens are streamed to the callback
+func TestTokenCallback(t *testing.T) {
+	model := &StreamingModel{
+		ScriptedModel: ScriptedModel{responses: []string{
+			"Let me look that up first.\n" + toolCallResponse,
+			"The answer is 42.",
+		}},
+		chunkSize: 3,
+	}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	var deltas []string
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithTokenCallback(func(delta string) { deltas = append(deltas, delta) }),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	answer, err := agent.Run(context.Background(), "what is the answer?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if answer != "The answer is 42." {
+		t.Errorf("Expected the full answer, got %v", answer)
+	}
+	if model.streamed != 2 {
+		t.Errorf("Expected both generations to be streamed, got %d", model.streamed)
+	}
+	if len(deltas) < 2 {
+		t.Errorf("Expected the answer to arrive in several deltas, got %d", len(deltas))
+	}
+	if got := strings.Join(deltas, ""); got != "The answer is 42." {
+		t.Errorf("Expected only the final answer to be streamed, got %q", got)
+	}
+}
+
+// ToolStreamingModel implements the models.ToolStreamingModel interface.
+// Responses to requests with tools that hold a tool call are streamed as the
+// preamble followed by the call; others are streamed like StreamingModel's.
+type ToolStreamingModel struct {
+	StreamingModel
+	preamble     string
+	toolStreamed int
+}
+
+func (m *ToolStreamingModel) GenerateStreamWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (<-chan models.StreamChunk, error) {
+	m.toolStreamed++
+	response, err := m.GenerateWithTools(ctx, messages, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(response, "{") {
+		return streamChunks(response, m.chunkSize), nil
+	}
+
+	chunks := make(chan models.StreamChunk, 2)
+	chunks <- models.StreamChunk{Delta: m.preamble}
+	chunks <- models.StreamChunk{ToolCalls: response}
+	close(chunks)
+	return chunks, nil
+}
+
+// TestTokenCallbackWithNativeTools tests that a ToolCallingAgent streams its
+// final answer, but not the content of steps calling tools
+func TestTokenCallbackWithNativeTools(t *testing.T) {
+	toolCall := `{"id": "call_1", "tool": "test_tool", "args": {"arg1": "value"}}`
+	tests := []struct {
+		name       string
+		responses  []string
+		wantDeltas int
+	}{
+		{name: "final answer", responses: []string{toolCall, "The answer is 42."}, wantDeltas: 6},
+		{
+			name:       "final_answer tool",
+			responses:  []string{toolCall, `{"id": "call_2", "tool": "final_answer", "args": {"answer": "The answer is 42."}}`},
+			wantDeltas: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ToolStreamingModel{
+				StreamingModel: StreamingModel{ScriptedModel: ScriptedModel{responses: tt.responses}, chunkSize: 3},
+				preamble:       "Let me look that up first.",
+			}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+			var deltas []string
+			agent, err := agents.NewToolCallingAgent(
+				[]tools.Tool{mockTool},
+				model,
+				agents.WithTokenCallback(func(delta string) { deltas = append(deltas, delta) }),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+			}
+
+			answer, err := agent.Run(context.Background(), "what is the answer?")
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if answer != "The answer is 42." {
+				t.Errorf("Expected the full answer, got %v", answer)
+			}
+			if model.toolStreamed != 2 {
+				t.Errorf("Expected both generations to be streamed with tools, got %d", model.toolStreamed)
+			}
+			if len(deltas) != tt.wantDeltas {
+				t.Errorf("Expected %d deltas, got %d: %q", tt.wantDeltas, len(deltas), deltas)
+			}
+			if got := strings.Join(deltas, ""); got != "The answer is 42." {
+				t.Errorf("Expected only the final answer to be streamed, got %q", got)
+			}
+		})
+	}
+}
+
+// TestNonIdempotentToolsAreNotRetried tests that tool retries skip non-idempotent tools
+func TestNonIdempotentToolsAreNotRetried(t *testing.T) {
+	tests := []struct {
+		name      string
+		wrap      func(tools.Tool) tools.Tool
+		wantCalls int
+	}{
+		{name: "idempotent", wrap: func(tool tools.Tool) tools.Tool { return tool }, wantCalls: 3},
+		{name: "non-idempotent", wrap: tools.NonIdempotent, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTool := &MockTool{name: "test_tool", description: "Sends an email", err: errors.New("smtp timeout")}
+
+			agent, err := agents.NewCodeAgent(
+				[]tools.Tool{tt.wrap(mockTool)},
+				&MockModel{generateResponse: toolCallResponse},
+				agents.WithToolRetries(2),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			step := agent.GetMemory().AddActionStep("task", nil)
+			if _, err := agent.Step(context.Background(), step); err == nil {
+				t.Fatal("Expected the tool failure to be surfaced")
+			}
+
+			if mockTool.calls != tt.wantCalls {
+				t.Errorf("Expected %d executions, got %d", tt.wantCalls, mockTool.calls)
+			}
+		})
+	}
+}
+
+// ParamsModel implements the models.Model interface by recording the generation
+// parameters carried by each call's context.
+type ParamsModel struct {
+	params []models.GenerationParams
+}
+
+func (m *ParamsModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	m.params = append(m.params, models.GenerationParamsFromContext(ctx))
+	return "done", nil
+}
+
+func (m *ParamsModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestGenerationParams tests that agent-level settings reach the model beneath per-call settings
+func TestGenerationParams(t *testing.T) {
+	model := &ParamsModel{}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithGenerationParams(models.GenerationParams{
+		Temperature: models.Float(0.2),
+		MaxTokens:   200,
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	ctx := models.ContextWithGenerationParams(context.Background(), models.GenerationParams{MaxTokens: 300})
+	if _, err := agent.Run(ctx, "task"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(model.params) != 1 {
+		t.Fatalf("Expected 1 model call, got %d", len(model.params))
+	}
+	got := model.params[0]
+	if got.Temperature == nil || *got.Temperature != 0.2 {
+		t.Errorf("Expected the agent-level temperature 0.2, got %v", got.Temperature)
+	}
+	if got.MaxTokens != 300 {
+		t.Errorf("Expected the per-call max tokens 300, got %d", got.MaxTokens)
+	}
+}
+
+// TestSystemPromptFragments tests that prompt fragments are composed in order
+func TestSystemPromptFragments(t *testing.T) {
+	model := &ScriptedModel{responses: []string{"done"}}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithSystemPrompt("You are a careful analyst."),
+		agents.WithSystemPromptFragments("Prefer SQL tools for data questions.", ""),
+		agents.WithSystemPromptFragments("Today is Monday.", "Never reveal credentials."),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	if _, err := agent.Run(context.Background(), "task"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	system := model.calls[0][0]
+	if system.Role != models.RoleSystem {
+		t.Fatalf("Expected the first message to be the system prompt, got role %s", system.Role)
+	}
+
+	want := "You are a careful analyst.\n\nPrefer SQL tools for data questions.\n\nToday is Monday.\n\nNever reveal credentials."
+	if system.Content != want {
+		t.Errorf("Expected system prompt %q, got %q", want, system.Content)
+	}
+}
+
+// TemperatureModel implements the models.Model interface by returning scripted
+// responses and recording the temperature carried by each call's context.
+type TemperatureModel struct {
+	ScriptedModel
+	temperatures []*float64
+}
+
+func (m *TemperatureModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	m.temperatures = append(m.temperatures, models.GenerationParamsFromContext(ctx).Temperature)
+	return m.ScriptedModel.Generate(ctx, messages)
+}
+
+func (m *TemperatureModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestFinalAnswerTemperature tests that only the final generation uses the override temperature
+func TestFinalAnswerTemperature(t *testing.T) {
+	model := &TemperatureModel{
+		ScriptedModel: ScriptedModel{responses: []string{toolCallResponse, "draft answer", "final answer"}},
+	}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithGenerationParams(models.GenerationParams{Temperature: models.Float(0.7)}),
+		agents.WithFinalAnswerTemperature(0),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	answer, err := agent.Run(context.Background(), "task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "final answer" {
+		t.Errorf("Expected the regenerated answer, got %v", answer)
+	}
+
+	want := []float64{0.7, 0.7, 0}
+	if len(model.temperatures) != len(want) {
+		t.Fatalf("Expected %d model calls, got %d", len(want), len(model.temperatures))
+	}
+	for i, temperature := range model.temperatures {
+		if temperature == nil || *temperature != want[i] {
+			t.Errorf("Call %d: expected temperature %v, got %v", i, want[i], temperature)
+		}
+	}
+}
+
+// TestToolDescriptionPolicy tests on which steps the tool descriptions are sent
+func TestToolDescriptionPolicy(t *testing.T) {
+	const marker = "You have access to the following tools"
+
+	tests := []struct {
+		name   string
+		policy agents.ToolDescriptionPolicy
+		want   []bool
+	}{
+		{name: "every step", policy: agents.ToolDescriptionsEveryStep, want: []bool{true, true, true}},
+		{name: "first step", policy: agents.ToolDescriptionsFirstStep, want: []bool{true, false, false}},
+		{name: "never", policy: agents.ToolDescriptionsNever, want: []bool{false, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, toolCallResponse, "done"}}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+			agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithToolDescriptionPolicy(tt.policy))
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			if _, err := agent.Run(context.Background(), "task"); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if len(model.calls) != len(tt.want) {
+				t.Fatalf("Expected %d model calls, got %d", len(tt.want), len(model.calls))
+			}
+			for i, messages := range model.calls {
+				var found bool
+				for _, msg := range messages {
+					if strings.Contains(msg.Content, marker) {
+						found = true
+					}
+				}
+				if found != tt.want[i] {
+					t.Errorf("Step %d: expected tool descriptions = %v, got %v", i+1, tt.want[i], found)
+				}
+			}
+		})
+	}
+
+	if _, err := agents.NewCodeAgent(
+		[]tools.Tool{&MockTool{name: "test_tool"}},
+		&MockModel{},
+		agents.WithToolDescriptionPolicy(agents.ToolDescriptionPolicy(42)),
+	); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+// AsyncTool implements the tools.Tool interface by starting a background job
+// and returning its pending handle. finished is closed when the job is done.
+type AsyncTool struct {
+	finished chan struct{}
+}
+
+func (t *AsyncTool) Name() string        { return "render" }
+func (t *AsyncTool) Description() string { return "Starts a render job" }
+func (t *AsyncTool) Schema() *tools.ToolSchema {
+	return &tools.ToolSchema{Type: "object", Properties: map[string]tools.PropertyDef{}}
+}
+func (t *AsyncTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	pending, err := tools.StartJob(ctx, func(ctx context.Context) (any, error) {
+		return "rendered castle", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	registry, _ := tools.JobRegistryFromContext(ctx)
+	go func() {
+		defer close(t.finished)
+		for {
+			if _, done, _ := registry.Check(pending.ID); done {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	return pending, nil
+}
+
+// GatedModel implements the models.Model interface by returning scripted
+// responses, waiting for the gate of a call index, if any, before responding.
+type GatedModel struct {
+	ScriptedModel
+	gates map[int]chan struct{}
+}
+
+func (m *GatedModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	if gate, ok := m.gates[len(m.calls)]; ok {
+		<-gate
+	}
+	return m.ScriptedModel.Generate(ctx, messages)
+}
+
+func (m *GatedModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestAsyncToolExecution tests that a pending tool result can be retrieved later in the run
+func TestAsyncToolExecution(t *testing.T) {
+	render := &AsyncTool{finished: make(chan struct{})}
+
+	// The model checks the job only once it has finished
+	model := &GatedModel{
+		ScriptedModel: ScriptedModel{responses: []string{
+			"```json\n{\"tool\": \"render\", \"args\": {}}\n```",
+			"```json\n{\"tool\": \"check_job\", \"args\": {\"id\": \"job-1\"}}\n```",
+			"done",
+		}},
+		gates: map[int]chan struct{}{1: render.finished},
+	}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{render, tools.NewCheckTool()}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "render the castle")
+	if err != nil {
+		t.Fatalf("RunWithTrace() error = %v", err)
+	}
+
+	started := result.Steps[0].Messages[len(result.Steps[0].Messages)-1].Content
+	if !strings.Contains(started, "job-1") {
+		t.Errorf("Expected the pending handle in the observation, got %q", started)
+	}
+
+	checked := result.Steps[1].Messages[len(result.Steps[1].Messages)-1].Content
+	if checked != "rendered castle" {
+		t.Errorf("Expected the job result, got %q", checked)
+	}
+}
+
+// TestStopCondition tests that a custom stop condition ends the run early
+func TestStopCondition(t *testing.T) {
+	model := &ScriptedModel{responses: []string{toolCallResponse, toolCallResponse, toolCallResponse, toolCallResponse}}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithMaxSteps(4),
+		agents.WithStopCondition(func(m *memory.Memory) (bool, any) {
+			actions := 0
+			for _, step := range m.GetSteps() {
+				if step.Type == "action" {
+					actions++
+				}
+			}
+			return actions >= 2, "stopped early"
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	answer, err := agent.Run(context.Background(), "task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "stopped early" {
+		t.Errorf("Expected the stop condition's answer, got %v", answer)
+	}
+	if mockTool.calls != 2 {
+		t.Errorf("Expected 2 tool calls before stopping, got %d", mockTool.calls)
+	}
+}
+
+// TestReasoningSplit tests that reasoning is separated from the final answer
+func TestReasoningSplit(t *testing.T) {
+	tests := []struct {
+		name          string
+		markers       []string
+		response      string
+		wantAnswer    string
+		wantReasoning string
+	}{
+		{
+			name:          "default marker",
+			response:      "The user wants a sum. 2 + 2 is 4.\nFinal Answer: 4",
+			wantAnswer:    "4",
+			wantReasoning: "The user wants a sum. 2 + 2 is 4.",
+		},
+		{
+			name:          "custom marker",
+			markers:       []string{"ANSWER>"},
+			response:      "Thinking it over.\nANSWER> yes",
+			wantAnswer:    "yes",
+			wantReasoning: "Thinking it over.",
+		},
+		{
+			name:       "no marker",
+			response:   "just an answer",
+			wantAnswer: "just an answer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{tt.response}}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+			agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithReasoningSplit(tt.markers...))
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			result, err := agent.RunWithTrace(context.Background(), "task")
+			if err != nil {
+				t.Fatalf("RunWithTrace() error = %v", err)
+			}
+			if result.FinalAnswer != tt.wantAnswer {
+				t.Errorf("Expected answer %q, got %v", tt.wantAnswer, result.FinalAnswer)
+			}
+
+			steps := result.Steps
+			if len(steps) != 1 {
+				t.Fatalf("Expected 1 action step, got %d", len(steps))
+			}
+			if steps[0].Reasoning != tt.wantReasoning {
+				t.Errorf("Expected reasoning %q, got %q", tt.wantReasoning, steps[0].Reasoning)
+			}
+		})
+	}
+}
+
+// TestMaxModelCalls tests that auxiliary model calls count towards the cap
+func TestMaxModelCalls(t *testing.T) {
+	longOutput := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name      string
+		maxCalls  int
+		wantErr   bool
+		wantCalls int
+	}{
+		// Each step makes a model call and a summarizer call
+		{"aborts when summaries exceed the cap", 3, true, 2},
+		{"completes within the cap", 5, false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, toolCallResponse, "final answer"}}
+			summarizer := &MockModel{generateResponse: "short summary"}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: longOutput}
+
+			agent, err := agents.NewCodeAgent(
+				[]tools.Tool{mockTool},
+				model,
+				agents.WithToolResultSummarizer(summarizer),
+				agents.WithToolResultSummaryThreshold(10),
+				agents.WithMaxModelCalls(tt.maxCalls),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			answer, err := agent.Run(context.Background(), "task")
+			if tt.wantErr {
+				if !errors.Is(err, agents.ErrMaxModelCallsExceeded) {
+					t.Fatalf("Expected ErrMaxModelCallsExceeded, got %v", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				if answer != "final answer" {
+					t.Errorf("Expected 'final answer', got %v", answer)
+				}
+			}
+
+			if len(model.calls) != tt.wantCalls {
+				t.Errorf("Expected %d agent model calls, got %d", tt.wantCalls, len(model.calls))
+			}
+		})
+	}
+
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+	if _, err := agents.NewCodeAgent([]tools.Tool{mockTool}, &MockModel{}, agents.WithMaxModelCalls(-1)); err == nil {
+		t.Error("Expected an error for a negative cap")
+	}
+}
+
+// TestUnknownToolBehavior tests that unknown tool calls either abort the run
+// or are answered with corrective feedback
+func TestUnknownToolBehavior(t *testing.T) {
+	unknownCall := "```json\n{\"tool\": \"test_tol\", \"args\": {\"arg1\": \"value\"}}\n```"
+
+	t.Run("abort", func(t *testing.T) {
+		model := &ScriptedModel{responses: []string{unknownCall}}
+		mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		if _, err := agent.Run(context.Background(), "task"); !errors.Is(err, agents.ErrToolNotFound) {
+			t.Fatalf("Expected ErrToolNotFound, got %v", err)
+		}
+	})
+
+	t.Run("feedback", func(t *testing.T) {
+		model := &ScriptedModel{responses: []string{unknownCall, toolCallResponse, "final answer"}}
+		mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+		agent, err := agents.NewCodeAgent(
+			[]tools.Tool{mockTool},
+			model,
+			agents.WithUnknownToolBehavior(agents.FeedbackOnUnknownTool),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		result, err := agent.RunWithTrace(context.Background(), "task")
+		if err != nil {
+			t.Fatalf("RunWithTrace() error = %v", err)
+		}
+		if result.FinalAnswer != "final answer" {
+			t.Errorf("Expected 'final answer', got %v", result.FinalAnswer)
+		}
+		if mockTool.calls != 1 {
+			t.Errorf("Expected the corrected call to run the tool once, got %d", mockTool.calls)
+		}
+
+		messages := result.Steps[0].Messages
+		feedback := messages[len(messages)-1]
+		if feedback.Role != models.RoleTool || !strings.Contains(feedback.Content, "test_tol does not exist") ||
+			!strings.Contains(feedback.Content, "test_tool") {
+			t.Errorf("Expected feedback listing the available tools, got %+v", feedback)
+		}
+		if calls := result.Steps[0].ToolCalls; len(calls) != 1 || calls[0].Error == "" {
+			t.Errorf("Expected the unknown call to be recorded with an error, got %+v", calls)
+		}
+	})
+
+	t.Run("feedback limit", func(t *testing.T) {
+		responses := make([]string, agents.MaxUnknownToolFeedback+1)
+		for i := range responses {
+			responses[i] = unknownCall
+		}
+		model := &ScriptedModel{responses: responses}
+		mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+		agent, err := agents.NewCodeAgent(
+			[]tools.Tool{mockTool},
+			model,
+			agents.WithMaxSteps(10),
+			agents.WithUnknownToolBehavior(agents.FeedbackOnUnknownTool),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		if _, err := agent.Run(context.Background(), "task"); !errors.Is(err, agents.ErrToolNotFound) {
+			t.Fatalf("Expected ErrToolNotFound after the feedback limit, got %v", err)
+		}
+		if len(model.calls) != agents.MaxUnknownToolFeedback+1 {
+			t.Errorf("Expected %d model calls, got %d", agents.MaxUnknownToolFeedback+1, len(model.calls))
+		}
+	})
+}
+
+// SummarizerModel records the system prompts and generation settings it is
+// called with
+type SummarizerModel struct {
+	prompts []string
+	params  []models.GenerationParams
+}
+
+func (m *SummarizerModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	m.prompts = append(m.prompts, messages[0].Content)
+	m.params = append(m.params, models.GenerationParamsFromContext(ctx))
+	return "summary", nil
+}
+
+func (m *SummarizerModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestSummarizationSettings tests that the summarization prompt and length
+// target reach the summarizer
+func TestSummarizationSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		options        []agents.Option
+		wantPrompt     string
+		wantMaxTokens  int
+		wantPromptHint string
+	}{
+		{
+			name:           "defaults",
+			wantPrompt:     agents.DefaultSummarizationPrompt,
+			wantMaxTokens:  agents.DefaultSummaryMaxTokens,
+			wantPromptHint: fmt.Sprintf("under %d tokens", agents.DefaultSummaryMaxTokens),
+		},
+		{
+			name:           "custom",
+			options:        []agents.Option{agents.WithSummarizationPrompt("Summarize tersely."), agents.WithSummaryMaxTokens(64)},
+			wantPrompt:     "Summarize tersely.",
+			wantMaxTokens:  64,
+			wantPromptHint: "under 64 tokens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, "done"}}
+			summarizer := &SummarizerModel{}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: strings.Repeat("x", 100)}
+
+			options := append([]agents.Option{
+				agents.WithToolResultSummarizer(summarizer),
+				agents.WithToolResultSummaryThreshold(10),
+			}, tt.options...)
+			agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, options...)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			if _, err := agent.Run(context.Background(), "task"); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if len(summarizer.prompts) != 1 {
+				t.Fatalf("Expected 1 summarizer call, got %d", len(summarizer.prompts))
+			}
+			if prompt := summarizer.prompts[0]; !strings.HasPrefix(prompt, tt.wantPrompt) || !strings.Contains(prompt, tt.wantPromptHint) {
+				t.Errorf("Expected prompt %q with %q, got %q", tt.wantPrompt, tt.wantPromptHint, prompt)
+			}
+			if got := summarizer.params[0].MaxTokens; got != tt.wantMaxTokens {
+				t.Errorf("Expected max tokens %d, got %d", tt.wantMaxTokens, got)
+			}
+		})
+	}
+}
+
+// TestToolResultDedup tests that repeated identical tool results are
+// referenced instead of repeated
+func TestToolResultDedup(t *testing.T) {
+	output := strings.Repeat("the same page ", 20)
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, toolCallResponse, toolCallResponse, "done"}}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: output}
+
+			agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithToolResultDedup(enabled))
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			result, err := agent.RunWithTrace(context.Background(), "task")
+			if err != nil {
+				t.Fatalf("RunWithTrace() error = %v", err)
+			}
+
+			for i, step := range result.Steps[:3] {
+				observation := step.Messages[len(step.Messages)-1].Content
+				wantFull := i == 0 || !enabled
+				if wantFull && observation != output {
+					t.Errorf("Step %d: expected the full output, got %q", i+1, observation)
+				}
+				if !wantFull && observation != "Same output as tool test_tool returned in step 1." {
+					t.Errorf("Step %d: expected a reference to step 1, got %q", i+1, observation)
+				}
+				if len(step.ToolCalls) != 1 || step.ToolCalls[0].Output != output {
+					t.Errorf("Step %d: expected the full output on the tool call, got %+v", i+1, step.ToolCalls)
+				}
+			}
+		})
+	}
+}
+
+// InterruptedStreamModel streams a partial response, then cancels the run and
+// ends the stream when the cancellation is observed
+type InterruptedStreamModel struct {
+	MockModel
+	deltas []string
+	cancel context.CancelFunc
+}
+
+func (m *InterruptedStreamModel) GenerateStream(ctx context.Context, messages []models.Message) (<-chan models.StreamChunk, error) {
+	chunks := make(chan models.StreamChunk)
+	go func() {
+		defer close(chunks)
+		for _, delta := range m.deltas {
+			chunks <- models.StreamChunk{Delta: delta}
+		}
+		m.cancel()
+		<-ctx.Done()
+	}()
+	return chunks, nil
+}
+
+// TestStreamCancellationKeepsPartialResponse tests that the tokens received
+// before a run is cancelled are kept in memory
+func TestStreamCancellationKeepsPartialResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	model := &InterruptedStreamModel{deltas: []string{"The answer ", "is still being"}, cancel: cancel}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+
+	var streamed strings.Builder
+	agent, err := agents.NewCodeAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithTokenCallback(func(delta string) { streamed.WriteString(delta) }),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(ctx, "task")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if len(result.Steps) != 1 {
+		t.Fatalf("Expected 1 step, got %d", len(result.Steps))
+	}
+	messages := result.Steps[0].Messages
+	last := messages[len(messages)-1]
+	if last.Role != models.RoleAssistant || last.Content != "The answer is still being" {
+		t.Errorf("Expected the partial response in memory, got %+v", last)
+	}
+	if streamed.String() != "" {
+		t.Errorf("Expected an unfinished response not to be streamed, got %q", streamed.String())
+	}
+}
+
+// PanicTool is a tool implementation that panics
+type PanicTool struct {
+	MockTool
+}
+
+func (t *PanicTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	panic("tool bug")
+}
+
+// TestToolPanicIsReported tests that a panicking tool fails the run with an
+// error instead of crashing
+func TestToolPanicIsReported(t *testing.T) {
+	model := &ScriptedModel{responses: []string{toolCallResponse}}
+	panicTool := &PanicTool{MockTool{name: "test_tool", description: "A test tool"}}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{panicTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	_, err = agent.Run(context.Background(), "task")
+	if !errors.Is(err, tools.ErrToolPanic) {
+		t.Fatalf("Expected tools.ErrToolPanic, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "tool bug") {
+		t.Errorf("Expected the panic value in the error, got %v", err)
+	}
+}
+
+// TestMultipleToolCalls tests that every tool call of a response is executed
+// and answered with its own tool message
+func TestMultipleToolCalls(t *testing.T) {
+	mockModel := &MockModel{
+		generateResponse: `[{"id": "call_1", "tool": "weather", "args": {"arg1": "Paris"}},` +
+			` {"id": "call_2", "tool": "time", "args": {"arg1": "Paris"}}]`,
+	}
+	weatherTool := &MockTool{name: "weather", description: "Gets the weather", output: "sunny"}
+	timeTool := &MockTool{name: "time", description: "Gets the time", output: "noon"}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{weatherTool, timeTool}, mockModel)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	step := agent.GetMemory().AddActionStep("task", nil)
+	result, err := agent.Step(context.Background(), step)
+	if err != nil {
+		t.Fatalf("Step() error = %v", err)
+	}
+	if result != nil {
+		t.Errorf("Expected no final answer, got %v", result)
+	}
+
+	if weatherTool.calls != 1 || timeTool.calls != 1 {
+		t.Fatalf("Expected each tool to be called once, got %d and %d", weatherTool.calls, timeTool.calls)
+	}
+
+	toolMessages := step.Messages[len(step.Messages)-2:]
+	want := []struct{ id, name, content string }{
+		{"call_1", "weather", "sunny"},
+		{"call_2", "time", "noon"},
+	}
+	for i, msg := range toolMessages {
+		if msg.Role != models.RoleTool {
+			t.Fatalf("Expected a tool result message, got role %s", msg.Role)
+		}
+		if msg.ToolCallID != want[i].id || msg.Name != want[i].name || msg.Content != want[i].content {
+			t.Errorf("Expected tool message %+v, got %+v", want[i], msg)
+		}
+	}
+}
+
+// TestRunResultToolUsage tests that the run result counts the calls of each
+// tool made during the run
+func TestRunResultToolUsage(t *testing.T) {
+	model := &ScriptedModel{responses: []string{
+		`[{"tool": "weather", "args": {"arg1": "Paris"}}, {"tool": "time", "args": {"arg1": "Paris"}}]`,
+		`{"tool": "weather", "args": {"arg1": "Rome"}}`,
+		"It is sunny in both cities.",
+	}}
+	weatherTool := &MockTool{name: "weather", description: "Gets the weather", output: "sunny"}
+	timeTool := &MockTool{name: "time", description: "Gets the time", output: "noon"}
+	unusedTool := &MockTool{name: "unused", description: "Is never called", output: "nothing"}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{weatherTool, timeTool, unusedTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "Weather in Paris and Rome?")
+	if err != nil {
+		t.Fatalf("RunWithTrace() error = %v", err)
+	}
+
+	expected := map[string]int{"weather": 2, "time": 1}
+	if !reflect.DeepEqual(result.ToolUsage, expected) {
+		t.Errorf("Expected tool usage %v, got %v", expected, result.ToolUsage)
+	}
+	if usage := agent.GetMemory().ToolUsage(); !reflect.DeepEqual(usage, expected) {
+		t.Errorf("Expected memory tool usage %v, got %v", expected, usage)
+	}
+}
+
+// NoToolsModel is a scripted model that rejects requests offering tools
+type NoToolsModel struct {
+	ScriptedModel
+	toolRequests int
+}
+
+func (m *NoToolsModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	m.toolRequests++
+	return "", fmt.Errorf("%w: request failed with status 400: this model does not support tools", models.ErrToolsNotSupported)
+}
+
+// TestAutoToolFallback tests that a model rejecting tools is retried with
+// prompt-based tool descriptions when enabled
+func TestAutoToolFallback(t *testing.T) {
+	model := &NoToolsModel{ScriptedModel: ScriptedModel{responses: []string{toolCallResponse, "The answer is 42"}}}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "result"}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model,
+		agents.WithAutoToolFallback(true), agents.WithToolDescriptionPolicy(agents.ToolDescriptionsNever))
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	answer, err := agent.Run(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "The answer is 42" {
+		t.Errorf("Expected answer 'The answer is 42', got %v", answer)
+	}
+	if mockTool.calls != 1 {
+		t.Errorf("Expected the tool to be called once through the fallback, got %d", mockTool.calls)
+	}
+	if model.toolRequests != 1 {
+		t.Errorf("Expected tools to be offered natively only once, got %d requests", model.toolRequests)
+	}
+
+	// The fallback describes the tools in the prompt
+	for i, messages := range model.calls {
+		described := false
+		for _, msg := range messages {
+			if msg.Role == models.RoleSystem && strings.Contains(msg.Content, "You have access to the following tools") {
+				described = true
+			}
+		}
+		if !described {
+			t.Errorf("Expected fallback call %d to describe the tools, got %v", i+1, messages)
+		}
+	}
+
+	// Without the option, the error fails the run
+	strict, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, &NoToolsModel{ScriptedModel: ScriptedModel{responses: []string{"unused"}}})
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+	if _, err := strict.Run(context.Background(), "test task"); !models.IsToolsNotSupportedError(err) {
+		t.Errorf("Expected a tools-not-supported error, got %v", err)
+	}
+}
+
+// TestFinalAnswerAmongToolCalls tests that a call of the final_answer tool
+// ends the run with its argument even alongside other tool calls
+func TestFinalAnswerAmongToolCalls(t *testing.T) {
+	response := `[{"id": "call_1", "tool": "weather", "args": {"arg1": "Paris"}},` +
+		` {"id": "call_2", "tool": "final_answer", "args": {"answer": "It is sunny in Paris"}}]`
+
+	for _, runOthers := range []bool{false, true} {
+		t.Run(fmt.Sprintf("runOthers=%v", runOthers), func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{response, "unexpected extra step"}}
+			weatherTool := &MockTool{name: "weather", description: "Gets the weather", output: "sunny"}
+			finalTool := &MockTool{name: "final_answer", description: "Gives the final answer", output: "unused"}
+
+			agent, err := agents.NewToolCallingAgent([]tools.Tool{weatherTool, finalTool}, model,
+				agents.WithToolsBeforeFinalAnswer(runOthers))
+			if err != nil {
+				t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+			}
+
+			answer, err := agent.Run(context.Background(), "Weather in Paris?")
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if answer != "It is sunny in Paris" {
+				t.Errorf("Expected the final_answer argument, got %v", answer)
+			}
+			if len(model.calls) != 1 {
+				t.Errorf("Expected the run to end after one step, got %d model calls", len(model.calls))
+			}
+			if finalTool.calls != 0 {
+				t.Errorf("Expected final_answer not to be executed, got %d calls", finalTool.calls)
+			}
+
+			wantWeatherCalls := 0
+			if runOthers {
+				wantWeatherCalls = 1
+			}
+			if weatherTool.calls != wantWeatherCalls {
+				t.Errorf("Expected %d weather calls, got %d", wantWeatherCalls, weatherTool.calls)
+			}
+			if usage := agent.GetMemory().ToolUsage(); usage["final_answer"] != 1 {
+				t.Errorf("Expected the final_answer call to be recorded, got %v", usage)
+			}
+		})
+	}
+}
+
+// SchemaRecordingModel is a mock model that records the tools offered to it
+type SchemaRecordingModel struct {
+	MockModel
+	offered [][]map[string]any
+}
+
+func (m *SchemaRecordingModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	m.offered = append(m.offered, tools)
+	return m.Generate(ctx, messages)
+}
+
+// TestToolsSchemaOffered tests that tool parameters are offered to the model
+// as plain maps, reused across steps
+func TestToolsSchemaOffered(t *testing.T) {
+	model := &SchemaRecordingModel{MockModel: MockModel{generateResponse: "done"}}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool"}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := agent.Run(context.Background(), "test task"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+
+	// The tool is offered along with the final_answer tool
+	if len(model.offered) != 2 || len(model.offered[0]) != 2 {
+		t.Fatalf("Expected two tools offered on each of 2 calls, got %v", model.offered)
+	}
+
+	function, _ := model.offered[0][0]["function"].(map[string]any)
+	parameters, ok := function["parameters"].(map[string]any)
+	if !ok {
+		t.Fatalf("Expected parameters as a map, got %T", function["parameters"])
+	}
+	properties, _ := parameters["properties"].(map[string]any)
+	if parameters["type"] != "object" || properties["arg1"] == nil {
+		t.Errorf("Expected the tool's schema in the parameters, got %v", parameters)
+	}
+
+	if !reflect.DeepEqual(model.offered[0], model.offered[1]) {
+		t.Errorf("Expected the same schema on every call, got %v and %v", model.offered[0], model.offered[1])
+	}
+}
+
+// TestFinalAnswerTool tests that both agents end the run when the model
+// calls the automatically registered final_answer tool
+func TestFinalAnswerTool(t *testing.T) {
+	response := "```json\n{\"tool\": \"final_answer\", \"args\": {\"answer\": \"42\"}}\n```"
+
+	constructors := map[string]func([]tools.Tool, models.Model) (agents.Agent, error){
+		"CodeAgent": func(ts []tools.Tool, m models.Model) (agents.Agent, error) {
+			return agents.NewCodeAgent(ts, m)
+		},
+		"ToolCallingAgent": func(ts []tools.Tool, m models.Model) (agents.Agent, error) {
+			return agents.NewToolCallingAgent(ts, m)
+		},
+	}
+
+	for name, newAgent := range constructors {
+		t.Run(name, func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, response, "unexpected extra step"}}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "result"}
+
+			agent, err := newAgent([]tools.Tool{mockTool}, model)
+			if err != nil {
+				t.Fatalf("Failed to create %s: %v", name, err)
+			}
+
+			answer, err := agent.Run(context.Background(), "test task")
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if answer != "42" {
+				t.Errorf("Expected the final_answer argument '42', got %v", answer)
+			}
+			if len(model.calls) != 2 {
+				t.Errorf("Expected the run to end after 2 steps, got %d model calls", len(model.calls))
+			}
+			if mockTool.calls != 1 {
+				t.Errorf("Expected the tool to be called once, got %d", mockTool.calls)
+			}
+		})
+	}
+}
+
+// TestToolTimingInObservation tests that tool durations are recorded and,
+// when enabled, reported in the observation
+func TestToolTimingInObservation(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			model := &ScriptedModel{responses: []string{toolCallResponse, "done"}}
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "result", delay: 20 * time.Millisecond}
+
+			agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithToolTimingInObservation(enabled))
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			result, err := agent.RunWithTrace(context.Background(), "task")
+			if err != nil {
+				t.Fatalf("RunWithTrace() error = %v", err)
+			}
+
+			step := result.Steps[0]
+			if len(step.ToolCalls) != 1 || step.ToolCalls[0].Duration < mockTool.delay {
+				t.Fatalf("Expected a recorded duration of at least %v, got %+v", mockTool.delay, step.ToolCalls)
+			}
+
+			observation := step.Messages[len(step.Messages)-1].Content
+			timed := regexp.MustCompile(`^result \(took \d+ms\)$`)
+			if enabled && !timed.MatchString(observation) {
+				t.Errorf("Expected the duration in the observation, got %q", observation)
+			}
+			if !enabled && observation != "result" {
+				t.Errorf("Expected the plain observation, got %q", observation)
+			}
+		})
+	}
+}
+
+// TestToolCallingAgentOptions tests that options configure the returned
+// ToolCallingAgent
+func TestToolCallingAgentOptions(t *testing.T) {
+	model := &ScriptedModel{responses: []string{toolCallResponse}}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "result"}
+
+	agent, err := agents.NewToolCallingAgent(
+		[]tools.Tool{mockTool},
+		model,
+		agents.WithMaxSteps(3),
+		agents.WithName("weather_agent"),
+		agents.WithDescription("Looks up the weather"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	if agent.GetName() != "weather_agent" || agent.GetDescription() != "Looks up the weather" {
+		t.Errorf("Expected the configured name and description, got %q and %q", agent.GetName(), agent.GetDescription())
+	}
+
+	if _, err := agent.Run(context.Background(), "task"); err == nil {
+		t.Fatal("Expected a step limit error")
+	}
+	if model.toolCalls != 3 || mockTool.calls != 3 {
+		t.Errorf("Expected the run to stop after 3 steps, got %d model calls and %d tool calls", model.toolCalls, mockTool.calls)
+	}
+}
+
+// TestStepHistory tests that each step sees the previous steps' messages once,
+// and that memory records the tool calls of the run
+func TestStepHistory(t *testing.T) {
+	model := &ScriptedModel{responses: []string{toolCallResponse, "done"}}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	if _, err := agent.Run(context.Background(), "the task"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var tasks, observations int
+	for _, msg := range model.calls[1] {
+		if msg.Role == models.RoleUser && msg.Content == "the task" {
+			tasks++
+		}
+		if msg.Role == models.RoleTool && msg.Content == "tool output" {
+			observations++
+		}
+	}
+	if tasks != 1 || observations != 1 {
+		t.Errorf("Expected the task and the tool result once in the second prompt, got %d and %d", tasks, observations)
+	}
+
+	if calls := agent.GetMemory().GetToolCalls(); len(calls) != 1 || calls[0].Name != "test_tool" {
+		t.Errorf("Expected the tool call in memory, got %+v", calls)
+	}
+}
+
+// MockAgent implements the agents.Agent interface by returning a canned
+// answer and recording the tasks it was given
+type MockAgent struct {
+	name        string
+	description string
+	answer      string
+	tasks       []string
+}
+
+func (a *MockAgent) Run(ctx context.Context, task string) (any, error) {
+	a.tasks = append(a.tasks, task)
+	return a.answer, nil
+}
+func (a *MockAgent) Step(ctx context.Context, step *memory.ActionStep) (any, error) {
+	return nil, nil
+}
+func (a *MockAgent) GetTools() []tools.Tool    { return nil }
+func (a *MockAgent) GetMemory() *memory.Memory { return memory.NewMemory() }
+func (a *MockAgent) GetModel() models.Model    { return nil }
+func (a *MockAgent) GetName() string           { return a.name }
+func (a *MockAgent) GetDescription() string    { return a.description }
+
+// TestCoordinator tests that a coordinator routes a task to the right sub-agent
+func TestCoordinator(t *testing.T) {
+	weather := &MockAgent{name: "weather_agent", description: "Answers questions about the weather", answer: "Sunny, 21C"}
+	math := &MockAgent{name: "math_agent", description: "Solves math problems", answer: "42"}
+	model := &ScriptedModel{responses: []string{
+		`{"id": "call_1", "tool": "weather_agent", "args": {"task": "What is the weather in Paris?"}}`,
+		"It is sunny and 21C in Paris.",
+	}}
+
+	coordinator, err := agents.NewCoordinator(model, []agents.Agent{weather, math})
+	if err != nil {
+		t.Fatalf("NewCoordinator() error = %v", err)
+	}
+
+	answer, err := coordinator.Run(context.Background(), "Should I bring an umbrella in Paris?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "It is sunny and 21C in Paris." {
+		t.Errorf("Expected the coordinator's answer, got %v", answer)
+	}
+
+	if len(weather.tasks) != 1 || weather.tasks[0] != "What is the weather in Paris?" {
+		t.Errorf("Expected the weather agent to get the task, got %v", weather.tasks)
+	}
+	if len(math.tasks) != 0 {
+		t.Errorf("Expected the math agent not to be called, got %v", math.tasks)
+	}
+
+	var observation string
+	for _, msg := range model.calls[1] {
+		if msg.Role == models.RoleTool {
+			observation = msg.Content
+		}
+	}
+	if observation != "Sunny, 21C" {
+		t.Errorf("Expected the weather agent's answer as the observation, got %q", observation)
+	}
+
+	if _, err := agents.NewCoordinator(model, []agents.Agent{weather, weather}); err == nil {
+		t.Error("Expected an error for duplicate sub-agent names")
+	}
+}
+
+// TestToolCallingAgentWithMemory tests that an agent seeded with a loaded
+// memory continues the conversation with a new task
+func TestToolCallingAgentWithMemory(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "It is sunny in Paris"}
+
+	// Run a first conversation and persist its memory
+	first, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, &ScriptedModel{responses: []string{toolCallResponse, "Sunny"}})
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+	if _, err := first.Run(context.Background(), "What is the weather in Paris?"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	data, err := json.Marshal(first.GetMemory())
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var loaded memory.Memory
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	priorSteps := len(loaded.GetSteps())
+
+	// Continue with a new task
+	model := &ScriptedModel{responses: []string{"Yes, it is sunny there, so no umbrella is needed."}}
+	agent, err := agents.NewToolCallingAgentWithMemory([]tools.Tool{mockTool}, model, &loaded)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+	if _, err := agent.Run(context.Background(), "Do I need an umbrella there?"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if agent.GetMemory() != &loaded || len(loaded.GetSteps()) != priorSteps+2 {
+		t.Fatalf("Expected the new task and step to be added to the loaded memory, got %d steps", len(loaded.GetSteps()))
+	}
+	if tasks := loaded.GetTaskSteps(); len(tasks) != 2 || tasks[0].Task != "What is the weather in Paris?" {
+		t.Errorf("Expected the prior task to remain, got %+v", tasks)
+	}
+
+	var prompt []string
+	for _, msg := range model.calls[0] {
+		prompt = append(prompt, msg.Content)
+	}
+	joined := strings.Join(prompt, "\n")
+	for _, want := range []string{"What is the weather in Paris?", "It is sunny in Paris", "Do I need an umbrella there?"} {
+		if strings.Count(joined, want) != 1 {
+			t.Errorf("Expected %q once in the prompt, got %q", want, joined)
+		}
+	}
+	if !strings.HasSuffix(joined, "Do I need an umbrella there?") {
+		t.Errorf("Expected the new task to end the prompt, got %q", joined)
+	}
+}
+
+// TestMaxCodeBlocks tests that only the first code blocks of a response are
+// searched for a tool call
+func TestMaxCodeBlocks(t *testing.T) {
+	response := "```python\nx = 1\n```\n```python\ny = 2\n```\n```python\ntest_tool(arg1=\"value\")\n```"
+
+	tests := []struct {
+		name      string
+		opts      []agents.Option
+		wantCalls int
+	}{
+		{"unlimited", nil, 1},
+		{"limit above the call", []agents.Option{agents.WithMaxCodeBlocks(3)}, 1},
+		{"limit below the call", []agents.Option{agents.WithMaxCodeBlocks(2)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "result"}
+			agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, &MockModel{generateResponse: response}, tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to create CodeAgent: %v", err)
+			}
+
+			step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+			if _, err := agent.Step(context.Background(), step); err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+
+			if mockTool.calls != tt.wantCalls {
+				t.Errorf("Expected %d tool calls, got %d", tt.wantCalls, mockTool.calls)
+			}
+		})
+	}
+
+	if _, err := agents.NewCodeAgent([]tools.Tool{&MockTool{name: "test_tool"}}, &MockModel{}, agents.WithMaxCodeBlocks(0)); err == nil {
+		t.Error("Expected an error for a non-positive limit")
+	}
+}
+
+// TestOptionErrorsAreJoined tests that every invalid option is reported
+func TestOptionErrorsAreJoined(t *testing.T) {
+	_, err := agents.NewCodeAgent(
+		[]tools.Tool{&MockTool{name: "test_tool"}},
+		&MockModel{},
+		agents.WithMaxSteps(0),
+		agents.WithName("valid"),
+		agents.WithToolRetries(-1),
+		agents.WithStepLimitBehavior(agents.StepLimitBehavior(99)),
+	)
+	if err == nil {
+		t.Fatal("Expected an error for the invalid options")
+	}
+
+	for _, want := range []string{
+		"maxSteps must be greater than 0",
+		"tool retries must not be negative",
+		"unknown step limit behavior: 99",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to report %q, got %v", want, err)
+		}
+	}
+}
+
+// RecordingExecutor records the tool calls it dispatches and delegates them
+// to a local executor
+type RecordingExecutor struct {
+	calls []string
+}
+
+func (e *RecordingExecutor) Execute(ctx context.Context, tool tools.Tool, args map[string]any) (any, error) {
+	e.calls = append(e.calls, fmt.Sprintf("%s(%v)", tool.Name(), args["arg1"]))
+	return agents.LocalExecutor{}.Execute(ctx, tool, args)
+}
+
+// TestExecutor tests that agents dispatch tool calls through their executor
+func TestExecutor(t *testing.T) {
+	executor := &RecordingExecutor{}
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "result"}
+	model := &ScriptedModel{responses: []string{toolCallResponse, "done"}}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model, agents.WithExecutor(executor))
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	if _, err := agent.Run(context.Background(), "task"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(executor.calls) != 1 || executor.calls[0] != "test_tool(value)" {
+		t.Errorf("Expected the executor to dispatch the tool call, got %v", executor.calls)
+	}
+	if mockTool.calls != 1 {
+		t.Errorf("Expected the executor to delegate to the tool, got %d calls", mockTool.calls)
+	}
+
+	if _, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model, agents.WithExecutor(nil)); err == nil {
+		t.Error("Expected an error for a nil executor")
+	}
+}
+
+// BenchmarkToolCallingAgentStepManyTools measures a step of an agent with a
+// large toolbox, which offers every tool schema to the model
+func BenchmarkToolCallingAgentStepManyTools(b *testing.B) {
+	toolbox := make([]tools.Tool, 100)
+	for i := range toolbox {
+		toolbox[i] = &MockTool{name: fmt.Sprintf("tool_%d", i), description: fmt.Sprintf("Test tool %d", i)}
+	}
+
+	agent, err := agents.NewToolCallingAgent(toolbox, &MockModel{generateResponse: "done"})
+	if err != nil {
+		b.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "test task"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		step := &memory.ActionStep{Step: memory.Step{Messages: messages[:1:1]}}
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			b.Fatalf("Step() error = %v", err)
+		}
+	}
+}
+
+// BenchmarkToolCallingAgentStepManyToolsUncached measures the first step of
+// a fresh agent with a large toolbox, which builds every tool schema, as each
+// step did before schemas were cached. Compare with
+// BenchmarkToolCallingAgentStepManyTools for the gain of caching.
+func BenchmarkToolCallingAgentStepManyToolsUncached(b *testing.B) {
+	toolbox := make([]tools.Tool, 100)
+	for i := range toolbox {
+		toolbox[i] = &MockTool{name: fmt.Sprintf("tool_%d", i), description: fmt.Sprintf("Test tool %d", i)}
+	}
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "test task"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		agent, err := agents.NewToolCallingAgent(toolbox, &MockModel{generateResponse: "done"})
+		if err != nil {
+			b.Fatalf("Failed to create ToolCallingAgent: %v", err)
+		}
+		step := &memory.ActionStep{Step: memory.Step{Messages: messages[:1:1]}}
+		b.StartTimer()
+
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			b.Fatalf("Step() error = %v", err)
+		}
+	}
+}
+
+func TestStepCallback(t *testing.T) {
+	model := &ScriptedModel{responses: []string{
+		`{"tool": "weather", "args": {"arg1": "Paris"}}`,
+		`{"tool": "final_answer", "args": {"answer": "Sunny"}}`,
+	}}
+	weatherTool := &MockTool{name: "weather", description: "Gets the weather", output: "sunny"}
+
+	var steps []*memory.ActionStep
+	var calls []memory.ToolCall
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{weatherTool}, model,
+		agents.WithStepCallback(func(step *memory.ActionStep) {
+			steps = append(steps, step)
+		}),
+		agents.WithToolCallCallback(func(call memory.ToolCall) {
+			calls = append(calls, call)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	answer, err := agent.Run(context.Background(), "Weather in Paris?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "Sunny" {
+		t.Errorf("Expected answer Sunny, got %v", answer)
+	}
+
+	if len(steps) != 2 {
+		t.Fatalf("Expected the step callback to fire 2 times, got %d", len(steps))
+	}
+	for i, step := range steps {
+		if step.EndTimestamp.IsZero() {
+			t.Errorf("Expected step %d to be completed when reported", i+1)
+		}
+		if len(step.ToolCalls) != 1 {
+			t.Fatalf("Expected step %d to hold 1 tool call, got %d", i+1, len(step.ToolCalls))
+		}
+	}
+	if name := steps[0].ToolCalls[0].Name; name != "weather" {
+		t.Errorf("Expected the first step to call weather, got %s", name)
+	}
+	if output := steps[0].ToolCalls[0].Output; output != "sunny" {
+		t.Errorf("Expected the first step's tool output sunny, got %v", output)
+	}
+	last := steps[0].Messages[len(steps[0].Messages)-1]
+	if last.Role != models.RoleTool || !strings.Contains(last.Content, "sunny") {
+		t.Errorf("Expected the first step to end with the tool result, got %+v", last)
+	}
+	if name := steps[1].ToolCalls[0].Name; name != tools.FinalAnswerToolName {
+		t.Errorf("Expected the second step to call %s, got %s", tools.FinalAnswerToolName, name)
+	}
+
+	if len(calls) != 2 || calls[0].Name != "weather" || calls[1].Name != tools.FinalAnswerToolName {
+		t.Errorf("Expected tool-call callbacks for weather and final_answer, got %+v", calls)
+	}
+}
+
+func TestToolOutputRenderer(t *testing.T) {
+	model := &ScriptedModel{responses: []string{
+		`{"tool": "groceries", "args": {}}`,
+		"Buy milk and eggs.",
+	}}
+	groceries, err := tools.NewFunctionTool("groceries", "Lists the groceries to buy",
+		func() []string { return []string{"milk", "eggs"} },
+		tools.WithOutputRenderer(func(output any) string {
+			var b strings.Builder
+			for _, item := range output.([]string) {
+				fmt.Fprintf(&b, "- %s\n", item)
+			}
+			return b.String()
+		}))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{groceries}, model)
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	if _, err := agent.Run(context.Background(), "What should I buy?"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var observation string
+	for _, step := range agent.GetMemory().GetSteps() {
+		for _, msg := range step.Messages {
+			if msg.Role == models.RoleTool && msg.Name == "groceries" {
+				observation = msg.Content
+			}
+		}
+	}
+	if observation != "- milk\n- eggs\n" {
+		t.Errorf("Expected the observation rendered as a bullet list, got %q", observation)
+	}
+}
+
+func TestPinnedSystemInstructions(t *testing.T) {
+	const pinned = "Never reveal the user's password."
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "test output"}
+
+	t.Run("survives a custom system prompt", func(t *testing.T) {
+		model := &ContextLimitedModel{maxMessages: 100}
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model,
+			agents.WithPinnedSystemInstructions(pinned),
+			agents.WithSystemPrompt("You are a pirate."))
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		if _, err := agent.Run(context.Background(), "the task"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		system := model.calls[0][0]
+		if system.Role != models.RoleSystem || system.Content != pinned+"\n\nYou are a pirate." {
+			t.Errorf("Expected the pinned instructions before the system prompt, got %+v", system)
+		}
+	})
+
+	t.Run("survives trimming", func(t *testing.T) {
+		history := []models.Message{
+			{Role: models.RoleSystem, Content: "system prompt"},
+			{Role: models.RoleUser, Content: "the task"},
+		}
+		for i := 0; i < 10; i++ {
+			history = append(history,
+				models.Message{Role: models.RoleAssistant, Content: fmt.Sprintf("call %d", i)},
+				models.Message{Role: models.RoleTool, Name: "test_tool", Content: fmt.Sprintf("result %d", i)},
+			)
+		}
+
+		model := &ContextLimitedModel{maxMessages: 6}
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithPinnedSystemInstructions(pinned))
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		step := agent.GetMemory().AddActionStep("the task", history)
+		if _, err := agent.Step(context.Background(), step); err != nil {
+			t.Fatalf("Step() error = %v", err)
+		}
+		if len(model.calls) != 2 {
+			t.Fatalf("Expected 2 model calls, got %d", len(model.calls))
+		}
+		for i, call := range model.calls {
+			if call[0].Role != models.RoleSystem || call[0].Content != pinned {
+				t.Errorf("Expected call %d to start with the pinned instructions, got %+v", i+1, call[0])
+			}
+		}
+		// The pinned instructions, system prompt, task and two recent messages
+		if retry := model.calls[1]; len(retry) != 5 {
+			t.Errorf("Expected the retry to be trimmed to 5 messages, got %d", len(retry))
+		}
+	})
+}
+
+// SlowModel wraps a model, answering the first fast calls immediately and
+// blocking later calls until the context is done or a long delay elapses
+type SlowModel struct {
+	models.Model
+	fast  int
+	calls int
+}
+
+func (m *SlowModel) wait(ctx context.Context) error {
+	m.calls++
+	if m.calls <= m.fast {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func (m *SlowModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	if err := m.wait(ctx); err != nil {
+		return "", err
+	}
+	return m.Model.Generate(ctx, messages)
+}
+
+func (m *SlowModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	if err := m.wait(ctx); err != nil {
+		return "", err
+	}
+	return m.Model.GenerateWithTools(ctx, messages, tools)
+}
+
+func TestRunTimeout(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	model := &SlowModel{Model: &ScriptedModel{responses: []string{toolCallResponse, "final answer"}}, fast: 1}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model, agents.WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	start := time.Now()
+	result, err := agent.RunWithTrace(context.Background(), "test task")
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, agents.ErrRunTimeout) {
+		t.Fatalf("Expected ErrRunTimeout, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the error to wrap context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected the run to abort promptly, took %v", elapsed)
+	}
+
+	// The step completed before the timeout is kept
+	if len(result.Steps) != 2 || len(result.Steps[0].ToolCalls) != 1 {
+		t.Errorf("Expected the partial trace with the first step's tool call, got %+v", result.Steps)
+	}
+	if usage := agent.GetMemory().ToolUsage(); usage["test_tool"] != 1 {
+		t.Errorf("Expected the partial memory to record the tool call, got %v", usage)
+	}
+
+	if _, err := agents.NewToolCallingAgent(nil, model, agents.WithTimeout(-time.Second)); err == nil {
+		t.Error("Expected an error for a negative timeout")
+	}
+}
+
+func TestPlanningAgent(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	model := &ScriptedModel{responses: []string{
+		"## Facts\nNothing known yet.\n\n## Plan\n1. Call test_tool.",
+		toolCallResponse,
+		toolCallResponse,
+		"## Facts\ntest_tool returned tool output.\n\n## Plan\n1. Answer.",
+		"final answer",
+	}}
+
+	agent, err := agents.NewPlanningAgent([]tools.Tool{mockTool}, model, agents.WithPlanningInterval(2))
+	if err != nil {
+		t.Fatalf("Failed to create PlanningAgent: %v", err)
+	}
+	if agent.GetName() != "PlanningAgent" {
+		t.Errorf("Expected name PlanningAgent, got %s", agent.GetName())
+	}
+
+	answer, err := agent.Run(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "final answer" {
+		t.Errorf("Expected 'final answer', got %v", answer)
+	}
+
+	// A plan precedes the first step and the third step
+	var types []string
+	for _, step := range agent.GetMemory().GetSteps() {
+		types = append(types, step.Type)
+	}
+	expected := []string{"system_prompt", "task", "planning", "action", "action", "planning", "action"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("Expected steps %v, got %v", expected, types)
+	}
+
+	plans := agent.GetMemory().GetPlanningSteps()
+	if plans[0].Facts != "Nothing known yet." || plans[0].Plan != "1. Call test_tool." {
+		t.Errorf("Unexpected first plan: facts %q, plan %q", plans[0].Facts, plans[0].Plan)
+	}
+	if plans[1].Plan != "1. Answer." {
+		t.Errorf("Unexpected second plan %q", plans[1].Plan)
+	}
+
+	// Each step's prompt holds the current plan, but not the planning exchange
+	for i, want := range map[int]string{1: "1. Call test_tool.", 2: "1. Call test_tool.", 4: "1. Answer."} {
+		var found bool
+		for _, msg := range model.calls[i] {
+			if strings.Contains(msg.Content, "## Plan") {
+				t.Errorf("Expected call %d to leave out the planning exchange", i+1)
+			}
+			if msg.Role == models.RoleSystem && strings.Contains(msg.Content, "Current plan:\n"+want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected call %d to include the plan %q", i+1, want)
+		}
+	}
+}
+
+// TestMaxPlanningSteps tests that planning stops once a run reaches the cap
+// on planning steps
+func TestMaxPlanningSteps(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	model := &ScriptedModel{responses: []string{
+		"## Plan\n1. Call test_tool.",
+		toolCallResponse,
+		"## Plan\n1. Call test_tool again.",
+		toolCallResponse,
+		toolCallResponse,
+		toolCallResponse,
+		"final answer",
+	}}
+
+	agent, err := agents.NewPlanningAgent([]tools.Tool{mockTool}, model,
+		agents.WithPlanningInterval(1), agents.WithMaxPlanningSteps(2))
+	if err != nil {
+		t.Fatalf("Failed to create PlanningAgent: %v", err)
+	}
+
+	answer, err := agent.Run(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if answer != "final answer" {
+		t.Errorf("Expected 'final answer', got %v", answer)
+	}
+
+	// Planning precedes the first two steps only
+	var types []string
+	for _, step := range agent.GetMemory().GetSteps() {
+		types = append(types, step.Type)
+	}
+	expected := []string{"system_prompt", "task", "planning", "action", "planning", "action", "action", "action", "action"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("Expected steps %v, got %v", expected, types)
+	}
+
+	// The last plan stays in the prompts after the cap
+	last := model.calls[len(model.calls)-1]
+	var found bool
+	for _, msg := range last {
+		if msg.Role == models.RoleSystem && strings.Contains(msg.Content, "Current plan:\n1. Call test_tool again.") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the last plan in the prompt of the final step")
+	}
+
+	if _, err := agents.NewPlanningAgent([]tools.Tool{mockTool}, model, agents.WithMaxPlanningSteps(-1)); err == nil {
+		t.Error("Expected an error for a negative cap")
+	}
+}
+
+// HangingModel ignores the context and blocks for a long time on every call
+type HangingModel struct{}
+
+func (m *HangingModel) Generate(ctx context.Context, messages []models.Message) (string, error) {
+	time.Sleep(5 * time.Second)
+	return "too late", nil
+}
+
+func (m *HangingModel) GenerateWithTools(ctx context.Context, messages []models.Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestCancellationPropagation tests that cancelling the run context stops the
+// run promptly at each layer: the model call, the tool call and the step loop
+func TestCancellationPropagation(t *testing.T) {
+	t.Run("model", func(t *testing.T) {
+		mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+		for _, model := range []models.Model{&HangingModel{}, &SlowModel{Model: &ScriptedModel{responses: []string{"final answer"}}}} {
+			agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model)
+			if err != nil {
+				t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			start := time.Now()
+			_, err = agent.Run(ctx, "test task")
+			cancel()
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%T: expected context.DeadlineExceeded, got %v", model, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("%T: expected the run to return promptly, took %v", model, elapsed)
+			}
+		}
+	})
+
+	t.Run("tool", func(t *testing.T) {
+		started := make(chan struct{})
+		waitTool, err := tools.NewFunctionTool("test_tool", "Waits for the context",
+			func(ctx context.Context, arg1 string) (string, error) {
+				close(started)
+				<-ctx.Done()
+				return "", ctx.Err()
+			}, tools.WithParamNames("arg1"))
+		if err != nil {
+			t.Fatalf("NewFunctionTool() error = %v", err)
+		}
+		agent, err := agents.NewToolCallingAgent([]tools.Tool{waitTool}, &ScriptedModel{responses: []string{toolCallResponse}})
+		if err != nil {
+			t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		if _, err := agent.Run(ctx, "test task"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+		model := &ScriptedModel{responses: []string{toolCallResponse, "final answer"}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model,
+			agents.WithStepCallback(func(step *memory.ActionStep) { cancel() }))
+		if err != nil {
+			t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+		}
+
+		if _, err := agent.Run(ctx, "test task"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if len(model.calls) != 1 {
+			t.Errorf("Expected the loop to stop before the next model call, got %d calls", len(model.calls))
+		}
+	})
+}
+
+func TestCodeExecution(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+
+	t.Run("agent", func(t *testing.T) {
+		mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+		model := &ScriptedModel{responses: []string{
+			"Let me compute it.\n```go\npackage main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(6 * 7)\n}\n```",
+			"The answer is 42.",
+		}}
+
+		agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model, agents.WithCodeExecutor(&agents.GoExecutor{}))
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		answer, err := agent.Run(context.Background(), "What is 6 times 7?")
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if answer != "The answer is 42." {
+			t.Errorf("Expected 'The answer is 42.', got %v", answer)
+		}
+
+		// The program's output is the observation of the second call
+		last := model.calls[1][len(model.calls[1])-1]
+		if last.Role != models.RoleTool || last.Name != agents.CodeExecutionToolName || last.Content != "42\n" {
+			t.Errorf("Expected the program output as the observation, got %+v", last)
+		}
+		if usage := agent.GetMemory().ToolUsage(); usage[agents.CodeExecutionToolName] != 1 {
+			t.Errorf("Expected the execution to be recorded as a tool call, got %v", usage)
+		}
+	})
+
+	t.Run("executor", func(t *testing.T) {
+		executor := &agents.GoExecutor{Timeout: 5 * time.Second}
+
+		output, err := executor.ExecuteCode(context.Background(), "import \"fmt\"\n\nfunc main() { fmt.Print(\"hi\") }\n")
+		if err != nil || output != "hi" {
+			t.Errorf("Expected 'hi' from a program without package clause, got %q (err %v)", output, err)
+		}
+
+		if _, err := executor.ExecuteCode(context.Background(), "package main\n\nimport \"net/http\"\n\nfunc main() { http.Get(\"http://example.com\") }\n"); err == nil || !strings.Contains(err.Error(), "net/http is not allowed") {
+			t.Errorf("Expected the network import to be refused, got %v", err)
+		}
+
+		refused := map[string]string{
+			"crypto/tls": "package main\n\nimport \"crypto/tls\"\n\nfunc main() { tls.Dial(\"tcp\", \"example.com:443\", nil) }\n",
+			"os":         "package main\n\nimport \"os\"\n\nfunc main() { os.StartProcess(\"/usr/bin/curl\", []string{\"curl\", \"http://example.com\"}, &os.ProcAttr{}) }\n",
+		}
+		for path, code := range refused {
+			if _, err := executor.ExecuteCode(context.Background(), code); err == nil || !strings.Contains(err.Error(), "import of "+path+" is not allowed") {
+				t.Errorf("Expected the import of %s to be refused, got %v", path, err)
+			}
+		}
+
+		if _, err := executor.ExecuteCode(context.Background(), "package main\n\nfunc main() { undefined() }\n"); err == nil || !strings.Contains(err.Error(), "build failed") {
+			t.Errorf("Expected a build failure, got %v", err)
+		}
+
+		printing := &agents.GoExecutor{Timeout: 20 * time.Second, MaxOutputBytes: 1000}
+		output, err = printing.ExecuteCode(context.Background(), "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfor {\n\t\tfmt.Println(\"spam\")\n\t}\n}\n")
+		if err == nil || !strings.Contains(err.Error(), "output exceeded 1000 bytes") || errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the output limit to stop the program, got %v", err)
+		}
+		if len(output) != 1000 || !strings.HasPrefix(output, "spam\n") {
+			t.Errorf("Expected the output to be cut at 1000 bytes, got %d bytes", len(output))
+		}
+
+		looping := &agents.GoExecutor{Timeout: 2 * time.Second}
+		if _, err := looping.ExecuteCode(context.Background(), "package main\n\nfunc main() { for {} }\n"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the timeout to stop the program, got %v", err)
+		}
+	})
+}
+
+// TestToolCallExtraction tests extracting JSON tool calls from responses with
+// several, nested or missing code fences
+func TestToolCallExtraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		arg1     any // nil when no tool call is expected
+	}{
+		{
+			name: "multiple blocks",
+			response: "Here is the data:\n```json\n{\"temperature\": 20}\n```\nNow the call:\n" +
+				"```json\n{\"tool\": \"test_tool\", \"args\": {\"arg1\": \"second\"}}\n```\nDone.",
+			arg1: "second",
+		},
+		{
+			name: "non-JSON first block",
+			response: "```go\nfmt.Println(\"hello\")\n```\n" +
+				"```\n{\"tool\": \"test_tool\", \"args\": {\"arg1\": \"after code\"}}\n```",
+			arg1: "after code",
+		},
+		{
+			name: "nested braces and backticks in strings",
+			response: "```json\n{\"tool\": \"test_tool\", \"args\": {\"arg1\": \"a } b ``` c\", " +
+				"\"options\": {\"depth\": {\"level\": 2}}}}\n```",
+			arg1: "a } b ``` c",
+		},
+		{
+			name: "nested fences",
+			response: "````markdown\nCall it like this:\n```json\n" +
+				"{\"tool\": \"test_tool\", \"args\": {\"arg1\": \"nested\"}}\n```\n````",
+			arg1: "nested",
+		},
+		{
+			name:     "bare JSON in prose",
+			response: "I will look it up: {\"tool\": \"test_tool\", \"args\": {\"arg1\": \"bare\"}} and report back.",
+			arg1:     "bare",
+		},
+		{
+			name:     "braces without a tool call",
+			response: "Sets are written {1, 2} and maps as {\"a\": 1}.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+			agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, &MockModel{generateResponse: tt.response})
+			if err != nil {
+				t.Fatalf("Failed to create agent: %v", err)
+			}
+
+			step := agent.GetMemory().AddActionStep("task", []models.Message{{Role: models.RoleUser, Content: "task"}})
+			result, err := agent.Step(context.Background(), step)
+			if err != nil {
+				t.Fatalf("Step() error = %v", err)
+			}
+
+			if tt.arg1 == nil {
+				if mockTool.calls != 0 || result == nil {
+					t.Errorf("Expected a final answer without tool calls, got %d calls and result %v", mockTool.calls, result)
+				}
+				return
+			}
+
+			if mockTool.calls != 1 || len(step.ToolCalls) != 1 {
+				t.Fatalf("Expected one tool call, got %d", mockTool.calls)
+			}
+			if arg1 := step.ToolCalls[0].Arguments["arg1"]; arg1 != tt.arg1 {
+				t.Errorf("Expected arg1 %q, got %v", tt.arg1, arg1)
+			}
+		})
+	}
+}
+
+func TestContinueConversation(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	model := &ScriptedModel{responses: []string{
+		"The capital of France is Paris.",
+		"About 2.1 million people live in Paris.",
+		"Hello!",
+	}}
+
+	agent, err := agents.NewToolCallingAgent([]tools.Tool{mockTool}, model, agents.WithSystemPrompt("You are a geography assistant."))
+	if err != nil {
+		t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+	}
+
+	if _, err := agent.Continue(context.Background(), "What is the capital of France?"); err != nil {
+		t.Fatalf("Continue() error = %v", err)
+	}
+	answer, err := agent.Continue(context.Background(), "How many people live there?")
+	if err != nil {
+		t.Fatalf("Continue() error = %v", err)
+	}
+	if answer != "About 2.1 million people live in Paris." {
+		t.Errorf("Unexpected second answer %v", answer)
+	}
+
+	// The second prompt holds the first turn, with a single system prompt
+	var contents []string
+	var systemPrompts int
+	for _, msg := range model.calls[1] {
+		contents = append(contents, msg.Content)
+		if strings.Contains(msg.Content, "You are a geography assistant.") {
+			systemPrompts++
+		}
+	}
+	joined := strings.Join(contents, "\n")
+	for _, want := range []string{"What is the capital of France?", "The capital of France is Paris.", "How many people live there?"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected the second prompt to contain %q, got %q", want, joined)
+		}
+	}
+	if systemPrompts != 1 {
+		t.Errorf("Expected the system prompt once in the second prompt, got %d", systemPrompts)
+	}
+
+	var systemSteps int
+	for _, step := range agent.GetMemory().GetSteps() {
+		if step.Type == "system_prompt" {
+			systemSteps++
+		}
+	}
+	if systemSteps != 1 {
+		t.Errorf("Expected one system prompt step in memory, got %d", systemSteps)
+	}
+
+	// Run still starts a new conversation
+	if _, err := agent.Run(context.Background(), "Hi"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, msg := range model.calls[2] {
+		if strings.Contains(msg.Content, "Paris") {
+			t.Errorf("Expected Run to start without the earlier turns, got %q", msg.Content)
+		}
+	}
+}
+
+// DetailedScriptedModel is a scripted model that reports token usage
+type DetailedScriptedModel struct {
+	ScriptedModel
+}
+
+func (m *DetailedScriptedModel) GenerateDetailed(ctx context.Context, messages []models.Message) (*models.GenerateResult, error) {
+	response, err := m.Generate(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &models.GenerateResult{Content: response, PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110}, nil
+}
+
+func TestRunWithTraceTokenUsage(t *testing.T) {
+	mockTool := &MockTool{name: "test_tool", description: "A test tool", output: "tool output"}
+	model := &DetailedScriptedModel{ScriptedModel{responses: []string{toolCallResponse, toolCallResponse, "final answer"}}}
+
+	agent, err := agents.NewCodeAgent([]tools.Tool{mockTool}, model)
+	if err != nil {
+		t.Fatalf("Failed to create CodeAgent: %v", err)
+	}
+
+	result, err := agent.RunWithTrace(context.Background(), "test task")
+	if err != nil {
+		t.Fatalf("RunWithTrace() error = %v", err)
+	}
+	if result.FinalAnswer != "final answer" {
+		t.Errorf("Expected 'final answer', got %v", result.FinalAnswer)
+	}
+
+	if len(result.Steps) != 3 {
+		t.Fatalf("Expected 3 action steps, got %d", len(result.Steps))
+	}
+	for i, step := range result.Steps {
+		if step.Type != "action" {
+			t.Errorf("Expected step %d to be an action step, got %s", i+1, step.Type)
+		}
+		if step.TokenUsage.TotalTokens != 110 {
+			t.Errorf("Expected step %d to use 110 tokens, got %d", i+1, step.TokenUsage.TotalTokens)
+		}
+	}
+
+	expected := memory.TokenUsage{PromptTokens: 300, CompletionTokens: 30, TotalTokens: 330}
+	if result.TokenUsage != expected {
+		t.Errorf("Expected token usage %+v, got %+v", expected, result.TokenUsage)
+	}
+}
+
+func TestAddRemoveTool(t *testing.T) {
+	offeredNames := func(offered []map[string]any) []string {
+		var names []string
+		for _, envelope := range offered {
+			names = append(names, envelope["function"].(map[string]any)["name"].(string))
+		}
+		return names
+	}
+
+	t.Run("ToolCallingAgent", func(t *testing.T) {
+		model := &SchemaRecordingModel{MockModel: MockModel{generateResponse: "done"}}
+		publicTool := &MockTool{name: "public_tool", description: "A public tool"}
+		agent, err := agents.NewToolCallingAgent([]tools.Tool{publicTool}, model)
+		if err != nil {
+			t.Fatalf("Failed to create ToolCallingAgent: %v", err)
+		}
+
+		if _, err := agent.Run(context.Background(), "first turn"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+
+		// Authentication unlocks another tool
+		if err := agent.AddTool(&MockTool{name: "account_tool", description: "Reads the account"}); err != nil {
+			t.Fatalf("AddTool() error = %v", err)
+		}
+		if err := agent.AddTool(&MockTool{name: "public_tool", description: "Another public tool"}); err == nil || !strings.Contains(err.Error(), "duplicate tool name: public_tool") {
+			t.Errorf("Expected a duplicate tool name error, got %v", err)
+		}
+		if _, err := agent.Run(context.Background(), "second turn"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+
+		if !agent.RemoveTool("public_tool") {
+			t.Error("Expected RemoveTool to find public_tool")
+		}
+		if agent.RemoveTool("public_tool") {
+			t.Error("Expected RemoveTool to report a missing tool")
+		}
+		if _, err := agent.Run(context.Background(), "third turn"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+
+		expected := [][]string{
+			{"public_tool", tools.FinalAnswerToolName},
+			{"public_tool", "account_tool", tools.FinalAnswerToolName},
+			{"account_tool", tools.FinalAnswerToolName},
+		}
+		for i, want := range expected {
+			if got := offeredNames(model.offered[i]); !reflect.DeepEqual(got, want) {
+				t.Errorf("Run %d: expected tools %v, got %v", i+1, want, got)
+			}
+		}
+	})
+
+	t.Run("CodeAgent", func(t *testing.T) {
+		model := &ScriptedModel{responses: []string{"done"}}
+		agent, err := agents.NewCodeAgent([]tools.Tool{&MockTool{name: "public_tool", description: "A public tool"}}, model)
+		if err != nil {
+			t.Fatalf("Failed to create CodeAgent: %v", err)
+		}
+
+		if err := agent.AddTool(&MockTool{name: "account_tool", description: "Reads the account"}); err != nil {
+			t.Fatalf("AddTool() error = %v", err)
+		}
+		agent.RemoveTool("public_tool")
+		if _, err := agent.Run(context.Background(), "task"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+
+		var prompt string
+		for _, msg := range model.calls[0] {
+			prompt += msg.Content + "\n"
+		}
+		if !strings.Contains(prompt, "account_tool") || strings.Contains(prompt, "public_tool") {
+			t.Errorf("Expected the tool descriptions to reflect the changes, got %q", prompt)
+		}
+	})
+}
+
+func TestDuplicateToolNames(t *testing.T) {
+	duplicates := []tools.Tool{
+		&MockTool{name: "search", description: "Searches the web"},
+		&MockTool{name: "search", description: "Searches the docs"},
+	}
+	model := &MockModel{generateResponse: "done"}
+
+	constructors := map[string]func() error{
+		"NewBaseAgent": func() error {
+			_, err := agents.NewBaseAgent(duplicates, model)
+			return err
+		},
+		"NewToolCallingAgent": func() error {
+			_, err := agents.NewToolCallingAgent(duplicates, model)
+			return err
+		},
+		"NewCodeAgent": func() error {
+			_, err := agents.NewCodeAgent(duplicates, model)
+			return err
+		},
+	}
+
+	for name, construct := range constructors {
+		if err := construct(); err == nil || !strings.Contains(err.Error(), "duplicate tool name: search") {
+			t.Errorf("%s: expected a duplicate tool name error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// WithTokenCallback streams final answers to callback, when the agent's model
+// implements models.StreamingModel, or models.ToolStreamingModel for
+// generations offering tools natively. The run still returns the full answer.
+//
+// Since prose may be followed by a tool call, a generation is only known to
+// be the final answer once it is complete. Its deltas are therefore held back
+// until the step ends the run and are then passed on in order. Answers that
+// are not part of the generation as streamed, such as the argument of a
+// final_answer tool call, are passed on in a single delta.
+func WithTokenCallback(callback func(delta string)) Option {
+	return func(a *BaseAgent) error {
+		a.tokenCallback = callback
+		return nil
+	}
+}
+
+// streamGeneration collects the generation streamed by open, holding its
+// deltas back for streamAnswer. What was received before a cancellation is
+// kept in the step's transcript.
+func (a *BaseAgent) streamGeneration(
+	ctx context.Context,
+	step *memory.ActionStep,
+	open func() (<-chan models.StreamChunk, error),
+) (string, error) {
+	chunks, err := open()
+	if err != nil {
+		return "", err
+	}
+
+	response, err := models.CollectStream(ctx, chunks, func(delta string) {
+		a.heldDeltas = append(a.heldDeltas, delta)
+	})
+	if err != nil && response != "" && ctx.Err() != nil {
+		step.Messages = append(step.Messages, models.Message{
+			Role:    models.RoleAssistant,
+			Content: response,
+		})
+	}
+
+	return response, err
+}
+
+// streamAnswer passes the final answer to the token callback, if any. When
+// the answer appears in the last generation, the held-back deltas covering it
+// are replayed; otherwise the answer is passed on whole.
+func (a *BaseAgent) streamAnswer(answer any) {
+	deltas := a.heldDeltas
+	a.heldDeltas = nil
+	if a.tokenCallback == nil {
+		return
+	}
+
+	text, ok := answer.(string)
+	if !ok {
+		text = fmt.Sprintf("%v", answer)
+	}
+	if text == "" {
+		return
+	}
+
+	start := strings.LastIndex(strings.Join(deltas, ""), text)
+	if start < 0 {
+		a.tokenCallback(text)
+		return
+	}
+
+	end := start + len(text)
+	offset := 0
+	for _, delta := range deltas {
+		if lo, hi := max(start-offset, 0), min(end-offset, len(delta)); lo < hi {
+			a.tokenCallback(delta[lo:hi])
+		}
+		offset += len(delta)
+	}
+}
@@ -0,0 +1,79 @@
+package agents
+
+import (
+	"context"
+
+	"github.com/epuerta9/smolagents-go/pkg/memory"
+	"github.com/epuerta9/smolagents-go/pkg/models"
+)
+
+// WithAutoToolFallback makes the agent degrade gracefully on models without
+// function calling. When the provider rejects a request because it offers
+// tools, the request is retried once through plain Generate with the tools
+// described in the prompt, and the agent keeps using the prompt from then on.
+// The error is detected with models.IsToolsNotSupportedError.
+func WithAutoToolFallback(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.autoToolFallback = enabled
+		return nil
+	}
+}
+
+// generateWithTools calls the model with native tools, falling back to
+// prompt-based tool descriptions if enabled and the model does not support
+// tools. With a token callback, the generation is streamed when the model
+// supports it.
+func (a *BaseAgent) generateWithTools(
+	ctx context.Context,
+	step *memory.ActionStep,
+	messages []models.Message,
+	toolsSchema []map[string]any,
+) (string, error) {
+	if !a.toolsUnsupported {
+		var response string
+		var err error
+		if streamer, ok := a.model.(models.ToolStreamingModel); ok && a.tokenCallback != nil {
+			response, err = a.streamGeneration(ctx, step, func() (<-chan models.StreamChunk, error) {
+				return streamer.GenerateStreamWithTools(ctx, messages, toolsSchema)
+			})
+		} else {
+			response, err = awaitModel(ctx, func() (string, error) {
+				return a.model.GenerateWithTools(ctx, messages, toolsSchema)
+			})
+		}
+		if err == nil || !a.autoToolFallback || !models.IsToolsNotSupportedError(err) {
+			return response, err
+		}
+		a.toolsUnsupported = true
+	}
+
+	prompted := a.withToolsPrompt(messages)
+	if streamer, ok := a.model.(models.StreamingModel); ok && a.tokenCallback != nil {
+		return a.streamGeneration(ctx, step, func() (<-chan models.StreamChunk, error) {
+			return streamer.GenerateStream(ctx, prompted)
+		})
+	}
+	return awaitModel(ctx, func() (string, error) {
+		return a.model.Generate(ctx, prompted)
+	})
+}
+
+// withToolsPrompt returns messages with the tool descriptions and the tool
+// call format added after the leading system messages, unless they are
+// already there.
+func (a *BaseAgent) withToolsPrompt(messages []models.Message) []models.Message {
+	toolsDesc := a.buildToolsDescription()
+
+	head := 0
+	for head < len(messages) && messages[head].Role == models.RoleSystem {
+		if messages[head].Content == toolsDesc {
+			return messages
+		}
+		head++
+	}
+
+	prompted := make([]models.Message, 0, len(messages)+1)
+	prompted = append(prompted, messages[:head]...)
+	prompted = append(prompted, models.Message{Role: models.RoleSystem, Content: toolsDesc})
+	return append(prompted, messages[head:]...)
+}
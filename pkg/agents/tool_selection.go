@@ -0,0 +1,189 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/epuerta9/smolagents-go/pkg/tools"
+)
+
+// searchToolsName is the name of the built-in tool that lets the model find
+// tools that are not exposed in the current step.
+const searchToolsName = "search_tools"
+
+// ToolScorer scores how relevant a tool is to a task. Higher is more relevant.
+type ToolScorer func(task string, tool tools.Tool) float64
+
+// KeywordToolScorer scores a tool by how many words of the task appear in the
+// tool's name and description.
+func KeywordToolScorer(task string, tool tools.Tool) float64 {
+	toolWords := make(map[string]bool)
+	for _, word := range splitWords(tool.Name() + " " + tool.Description()) {
+		toolWords[word] = true
+	}
+
+	var score float64
+	for _, word := range splitWords(task) {
+		if toolWords[word] {
+			score++
+		}
+	}
+
+	return score
+}
+
+// splitWords splits text into lowercase words of at least three characters.
+func splitWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	words := fields[:0]
+	for _, field := range fields {
+		if len(field) >= 3 {
+			words = append(words, field)
+		}
+	}
+
+	return words
+}
+
+// WithMaxExposedTools limits the number of tools described to the model each
+// step to the n most relevant to the task. The remaining tools stay callable,
+// and a search_tools tool is exposed so the model can discover them.
+func WithMaxExposedTools(n int) Option {
+	return func(a *BaseAgent) error {
+		if n <= 0 {
+			return errors.New("max exposed tools must be greater than 0")
+		}
+		a.maxExposedTools = n
+		return nil
+	}
+}
+
+// WithToolScorer sets the scorer used to rank tools by relevance when the
+// number of exposed tools is limited. Defaults to KeywordToolScorer.
+func WithToolScorer(scorer ToolScorer) Option {
+	return func(a *BaseAgent) error {
+		if scorer == nil {
+			return errors.New("tool scorer cannot be nil")
+		}
+		a.toolScorer = scorer
+		return nil
+	}
+}
+
+// rankTools returns the tools sorted by relevance to the query, most relevant
+// first. Tools with equal scores keep their original order.
+func rankTools(available []tools.Tool, query string, scorer ToolScorer) ([]tools.Tool, []float64) {
+	order := make([]int, len(available))
+	scores := make([]float64, len(available))
+	for i, tool := range available {
+		order[i] = i
+		scores[i] = scorer(query, tool)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	ranked := make([]tools.Tool, len(order))
+	rankedScores := make([]float64, len(order))
+	for i, idx := range order {
+		ranked[i] = available[idx]
+		rankedScores[i] = scores[idx]
+	}
+
+	return ranked, rankedScores
+}
+
+// exposedTools returns the tools to describe to the model for the current task.
+// The final_answer tool is always exposed, without counting toward the limit.
+func (a *BaseAgent) exposedTools() []tools.Tool {
+	if a.maxExposedTools <= 0 {
+		return a.tools
+	}
+
+	candidates := make([]tools.Tool, 0, len(a.tools))
+	var finalAnswer tools.Tool
+	for _, tool := range a.tools {
+		if tool.Name() == tools.FinalAnswerToolName {
+			finalAnswer = tool
+			continue
+		}
+		candidates = append(candidates, tool)
+	}
+	if len(candidates) <= a.maxExposedTools {
+		return a.tools
+	}
+
+	ranked, _ := rankTools(candidates, a.task, a.toolScorer)
+
+	exposed := make([]tools.Tool, 0, a.maxExposedTools+2)
+	exposed = append(exposed, ranked[:a.maxExposedTools]...)
+	if finalAnswer != nil {
+		exposed = append(exposed, finalAnswer)
+	}
+	exposed = append(exposed, &searchToolsTool{agent: a})
+
+	return exposed
+}
+
+// searchToolsTool searches the agent's full tool catalog.
+type searchToolsTool struct {
+	agent *BaseAgent
+}
+
+// Name returns the name of the tool.
+func (t *searchToolsTool) Name() string {
+	return searchToolsName
+}
+
+// Description returns a description of what the tool does.
+func (t *searchToolsTool) Description() string {
+	return "Search the full catalog of available tools. Returns the descriptions of the tools " +
+		"most relevant to the query; any of them can then be called by name."
+}
+
+// Schema returns the JSON schema of the tool.
+func (t *searchToolsTool) Schema() *tools.ToolSchema {
+	return &tools.ToolSchema{
+		Type: "object",
+		Properties: map[string]tools.PropertyDef{
+			"query": {
+				Type:        "string",
+				Description: "What the tool you are looking for should do",
+			},
+		},
+		Required: []string{"query"},
+	}
+}
+
+// Execute returns descriptions of the tools most relevant to the query.
+func (t *searchToolsTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	query, _ := args["query"].(string)
+	if query == "" {
+		return nil, errors.New("missing required argument: query")
+	}
+
+	ranked, scores := rankTools(t.agent.tools, query, t.agent.toolScorer)
+
+	var builder strings.Builder
+	for i, tool := range ranked {
+		if i >= t.agent.maxExposedTools || scores[i] <= 0 {
+			break
+		}
+		builder.WriteString(tools.FormatToolDescription(tool))
+		builder.WriteString("\n")
+	}
+
+	if builder.Len() == 0 {
+		return fmt.Sprintf("No tools found matching %q", query), nil
+	}
+
+	return builder.String(), nil
+}
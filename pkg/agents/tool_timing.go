@@ -0,0 +1,34 @@
+package agents
+
+import (
+	"fmt"
+	"time"
+)
+
+// WithToolTimingInObservation appends how long each tool took, such as
+// "(took 1.2s)", to the tool result observations, giving the model feedback
+// about the cost of its tools. The duration is recorded on the step's tool
+// call either way.
+func WithToolTimingInObservation(enabled bool) Option {
+	return func(a *BaseAgent) error {
+		a.toolTiming = enabled
+		return nil
+	}
+}
+
+// timedObservation appends the tool duration to an observation, if enabled.
+func (a *BaseAgent) timedObservation(observation string, duration time.Duration) string {
+	if !a.toolTiming {
+		return observation
+	}
+	return fmt.Sprintf("%s (took %s)", observation, formatToolDuration(duration))
+}
+
+// formatToolDuration formats a tool duration in seconds with one decimal, or
+// in milliseconds below a second.
+func formatToolDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
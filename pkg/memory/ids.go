@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// IDGenerator generates identifiers for runs, steps and tool calls.
+type IDGenerator interface {
+	// NewID returns a new identifier.
+	NewID() string
+}
+
+// RandomIDGenerator generates random 128-bit hex identifiers using crypto/rand.
+type RandomIDGenerator struct{}
+
+// NewID returns a new random identifier.
+func (RandomIDGenerator) NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate random id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// SequentialIDGenerator generates predictable identifiers made of a prefix
+// and an incrementing counter starting at 1. It is useful for deterministic
+// tests and is safe for concurrent use.
+type SequentialIDGenerator struct {
+	prefix string
+	mu     sync.Mutex
+	next   int
+}
+
+// NewSequentialIDGenerator creates a new SequentialIDGenerator.
+func NewSequentialIDGenerator(prefix string) *SequentialIDGenerator {
+	return &SequentialIDGenerator{prefix: prefix}
+}
+
+// NewID returns the next identifier in the sequence.
+func (g *SequentialIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	return fmt.Sprintf("%s%d", g.prefix, g.next)
+}
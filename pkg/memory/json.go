@@ -0,0 +1,95 @@
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// memoryJSON is the serialized form of a Memory. Each step is encoded as its
+// concrete type, tagged by the step's type field.
+type memoryJSON struct {
+	Steps []json.RawMessage `json:"steps"`
+}
+
+// MarshalJSON encodes the memory with every step, including the fields of
+// its concrete type, its tool calls and its timestamps.
+func (m *Memory) MarshalJSON() ([]byte, error) {
+	encoded := memoryJSON{Steps: make([]json.RawMessage, 0, len(m.Steps))}
+
+	for i, step := range m.Steps {
+		var value any = step
+		if i < len(m.typed) && embeddedStep(m.typed[i]) == step {
+			value = m.typed[i]
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal step %d: %w", i+1, err)
+		}
+		encoded.Steps = append(encoded.Steps, data)
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes a memory encoded by MarshalJSON, reconstructing each
+// step as its concrete type. Steps of an unknown type are kept as plain steps.
+func (m *Memory) UnmarshalJSON(data []byte) error {
+	var encoded memoryJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	m.Steps = make([]*Step, 0, len(encoded.Steps))
+	m.typed = nil
+	m.curStep = nil
+
+	for i, data := range encoded.Steps {
+		var header struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &header); err != nil {
+			return fmt.Errorf("failed to unmarshal step %d: %w", i+1, err)
+		}
+
+		var value any
+		switch header.Type {
+		case "task":
+			value = &TaskStep{}
+		case "system_prompt":
+			value = &SystemPromptStep{}
+		case "action":
+			value = &ActionStep{}
+		case "planning":
+			value = &PlanningStep{}
+		default:
+			value = &Step{}
+		}
+
+		if err := json.Unmarshal(data, value); err != nil {
+			return fmt.Errorf("failed to unmarshal %s step %d: %w", header.Type, i+1, err)
+		}
+
+		m.Steps = append(m.Steps, embeddedStep(value))
+		m.typed = append(m.typed, value)
+	}
+
+	return nil
+}
+
+// embeddedStep returns the Step of a concrete step.
+func embeddedStep(value any) *Step {
+	switch step := value.(type) {
+	case *TaskStep:
+		return &step.Step
+	case *SystemPromptStep:
+		return &step.Step
+	case *ActionStep:
+		return &step.Step
+	case *PlanningStep:
+		return &step.Step
+	case *Step:
+		return step
+	}
+	return nil
+}
@@ -2,6 +2,7 @@
 package memory
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,19 +11,73 @@ import (
 
 // ToolCall represents a call to a tool.
 type ToolCall struct {
+	ID        string         `json:"id"`
 	Name      string         `json:"name"`
 	Arguments map[string]any `json:"arguments"`
 	Output    any            `json:"output"`
 	Error     string         `json:"error,omitempty"`
+	Duration  time.Duration  `json:"duration,omitempty"`
+}
+
+// SizeMetrics holds approximate prompt and response sizes of model calls.
+// Token counts are estimates from a models.TokenCounter, not provider usage.
+type SizeMetrics struct {
+	InputChars   int `json:"input_chars"`
+	OutputChars  int `json:"output_chars"`
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Add accumulates other into the metrics.
+func (s *SizeMetrics) Add(other SizeMetrics) {
+	s.InputChars += other.InputChars
+	s.OutputChars += other.OutputChars
+	s.InputTokens += other.InputTokens
+	s.OutputTokens += other.OutputTokens
+}
+
+// TokenUsage holds the token counts reported by the provider for model
+// calls. Calls of models that do not report usage are not counted.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add accumulates other into the usage.
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+}
+
+// MeasureSizes computes the size metrics of a model call.
+func MeasureSizes(counter models.TokenCounter, input []models.Message, output string) SizeMetrics {
+	var sizes SizeMetrics
+
+	for _, msg := range input {
+		sizes.InputChars += len([]rune(msg.Content))
+		sizes.InputTokens += counter.CountTokens(msg.Content)
+	}
+
+	sizes.OutputChars = len([]rune(output))
+	sizes.OutputTokens = counter.CountTokens(output)
+
+	return sizes
 }
 
 // Step represents a single step in the agent's execution.
 type Step struct {
+	ID             string           `json:"id"`
 	Type           string           `json:"type"`
 	Messages       []models.Message `json:"messages"`
 	StartTimestamp time.Time        `json:"start_timestamp"`
 	EndTimestamp   time.Time        `json:"end_timestamp"`
-	ToolCalls      []ToolCall       `json:"tool_calls,omitempty"`
+	ToolCalls      []*ToolCall      `json:"tool_calls,omitempty"`
+	Sizes          SizeMetrics      `json:"sizes"`
+	TokenUsage     TokenUsage       `json:"token_usage"`
+	ModelLatency   time.Duration    `json:"model_latency"`
+	Reasoning      string           `json:"reasoning,omitempty"`
 }
 
 // TaskStep represents the initial task given to the agent.
@@ -51,23 +106,60 @@ type PlanningStep struct {
 	Plan  string `json:"plan"`
 }
 
-// Memory stores the agent's execution history.
+// Memory stores the agent's execution history. Steps points at the Step of
+// each added step, so changes made through the returned step, such as
+// appended messages and tool calls, are reflected in the memory.
 type Memory struct {
-	Steps   []Step `json:"steps"`
+	Steps   []*Step `json:"steps"`
 	curStep *Step
+	ids     IDGenerator
+
+	// maxValueLength is the length beyond which String truncates tool
+	// arguments and outputs, or 0 for no limit.
+	maxValueLength int
+
+	// typed holds the concrete step of each entry in Steps, such as a
+	// *TaskStep, in the same order.
+	typed []any
 }
 
 // NewMemory creates a new memory.
 func NewMemory() *Memory {
 	return &Memory{
-		Steps: []Step{},
+		Steps:          []*Step{},
+		ids:            RandomIDGenerator{},
+		maxValueLength: DefaultMaxValueLength,
 	}
 }
 
+// DefaultMaxValueLength is the default length, in characters, beyond which
+// String truncates tool arguments and outputs.
+const DefaultMaxValueLength = 1000
+
+// SetMaxValueLength sets the length, in characters, beyond which String
+// truncates tool arguments and outputs. A length of 0 disables truncation.
+func (m *Memory) SetMaxValueLength(n int) {
+	m.maxValueLength = n
+}
+
+// SetIDGenerator sets the generator used for step and tool call IDs.
+func (m *Memory) SetIDGenerator(ids IDGenerator) {
+	m.ids = ids
+}
+
+// NewID returns a new identifier from the memory's ID generator.
+func (m *Memory) NewID() string {
+	if m.ids == nil {
+		m.ids = RandomIDGenerator{}
+	}
+	return m.ids.NewID()
+}
+
 // AddTaskStep adds a task step to the memory.
 func (m *Memory) AddTaskStep(task string, messages []models.Message) *TaskStep {
 	taskStep := &TaskStep{
 		Step: Step{
+			ID:             m.NewID(),
 			Type:           "task",
 			Messages:       messages,
 			StartTimestamp: time.Now(),
@@ -76,7 +168,8 @@ func (m *Memory) AddTaskStep(task string, messages []models.Message) *TaskStep {
 	}
 
 	m.curStep = &taskStep.Step
-	m.Steps = append(m.Steps, taskStep.Step)
+	m.Steps = append(m.Steps, &taskStep.Step)
+	m.typed = append(m.typed, taskStep)
 	return taskStep
 }
 
@@ -84,6 +177,7 @@ func (m *Memory) AddTaskStep(task string, messages []models.Message) *TaskStep {
 func (m *Memory) AddSystemPromptStep(systemPrompt string, messages []models.Message) *SystemPromptStep {
 	systemStep := &SystemPromptStep{
 		Step: Step{
+			ID:             m.NewID(),
 			Type:           "system_prompt",
 			Messages:       messages,
 			StartTimestamp: time.Now(),
@@ -92,7 +186,8 @@ func (m *Memory) AddSystemPromptStep(systemPrompt string, messages []models.Mess
 	}
 
 	m.curStep = &systemStep.Step
-	m.Steps = append(m.Steps, systemStep.Step)
+	m.Steps = append(m.Steps, &systemStep.Step)
+	m.typed = append(m.typed, systemStep)
 	return systemStep
 }
 
@@ -100,6 +195,7 @@ func (m *Memory) AddSystemPromptStep(systemPrompt string, messages []models.Mess
 func (m *Memory) AddActionStep(input string, messages []models.Message) *ActionStep {
 	actionStep := &ActionStep{
 		Step: Step{
+			ID:             m.NewID(),
 			Type:           "action",
 			Messages:       messages,
 			StartTimestamp: time.Now(),
@@ -108,7 +204,8 @@ func (m *Memory) AddActionStep(input string, messages []models.Message) *ActionS
 	}
 
 	m.curStep = &actionStep.Step
-	m.Steps = append(m.Steps, actionStep.Step)
+	m.Steps = append(m.Steps, &actionStep.Step)
+	m.typed = append(m.typed, actionStep)
 	return actionStep
 }
 
@@ -116,6 +213,7 @@ func (m *Memory) AddActionStep(input string, messages []models.Message) *ActionS
 func (m *Memory) AddPlanningStep(facts string, plan string, messages []models.Message) *PlanningStep {
 	planningStep := &PlanningStep{
 		Step: Step{
+			ID:             m.NewID(),
 			Type:           "planning",
 			Messages:       messages,
 			StartTimestamp: time.Now(),
@@ -125,20 +223,41 @@ func (m *Memory) AddPlanningStep(facts string, plan string, messages []models.Me
 	}
 
 	m.curStep = &planningStep.Step
-	m.Steps = append(m.Steps, planningStep.Step)
+	m.Steps = append(m.Steps, &planningStep.Step)
+	m.typed = append(m.typed, planningStep)
 	return planningStep
 }
 
-// AddToolCall adds a tool call to the current step.
+// AddToolCall adds a tool call to the current step and returns it. The
+// returned call is the one held by the step, so later changes to it are
+// reflected in the memory. It returns nil if there is no current step.
 func (m *Memory) AddToolCall(name string, args map[string]any, output any, err error) *ToolCall {
+	return m.AddToolCallWithID("", name, args, output, err)
+}
+
+// AddToolCallWithID adds a tool call to the current step under the given id,
+// typically the provider's tool call id. A new id is generated if id is empty.
+func (m *Memory) AddToolCallWithID(id, name string, args map[string]any, output any, err error) *ToolCall {
+	return m.AddTimedToolCall(id, name, args, output, err, 0)
+}
+
+// AddTimedToolCall adds a tool call to the current step like AddToolCallWithID,
+// recording how long the tool took to execute.
+func (m *Memory) AddTimedToolCall(id, name string, args map[string]any, output any, err error, duration time.Duration) *ToolCall {
 	if m.curStep == nil {
 		return nil
 	}
 
-	toolCall := ToolCall{
+	if id == "" {
+		id = m.NewID()
+	}
+
+	toolCall := &ToolCall{
+		ID:        id,
 		Name:      name,
 		Arguments: args,
 		Output:    output,
+		Duration:  duration,
 	}
 
 	if err != nil {
@@ -146,7 +265,7 @@ func (m *Memory) AddToolCall(name string, args map[string]any, output any, err e
 	}
 
 	m.curStep.ToolCalls = append(m.curStep.ToolCalls, toolCall)
-	return &toolCall
+	return toolCall
 }
 
 // CompleteCurrentStep completes the current step.
@@ -160,21 +279,70 @@ func (m *Memory) CompleteCurrentStep() {
 }
 
 // GetSteps returns all steps in the memory.
-func (m *Memory) GetSteps() []Step {
+func (m *Memory) GetSteps() []*Step {
 	return m.Steps
 }
 
+// GetTaskSteps returns the task steps in the memory, with their task.
+func (m *Memory) GetTaskSteps() []*TaskStep {
+	return stepsOfType[*TaskStep](m)
+}
+
+// GetSystemPromptSteps returns the system prompt steps in the memory, with
+// their system prompt.
+func (m *Memory) GetSystemPromptSteps() []*SystemPromptStep {
+	return stepsOfType[*SystemPromptStep](m)
+}
+
+// GetActionSteps returns the action steps in the memory, with their input
+// and output.
+func (m *Memory) GetActionSteps() []*ActionStep {
+	return stepsOfType[*ActionStep](m)
+}
+
+// GetPlanningSteps returns the planning steps in the memory, with their facts
+// and plan.
+func (m *Memory) GetPlanningSteps() []*PlanningStep {
+	return stepsOfType[*PlanningStep](m)
+}
+
+// stepsOfType returns the concrete steps of type T, in the order they were
+// added.
+func stepsOfType[T any](m *Memory) []T {
+	var steps []T
+	for _, step := range m.typed {
+		if typed, ok := step.(T); ok {
+			steps = append(steps, typed)
+		}
+	}
+	return steps
+}
+
 // GetToolCalls returns all tool calls from all steps.
 func (m *Memory) GetToolCalls() []ToolCall {
 	var toolCalls []ToolCall
 
 	for _, step := range m.Steps {
-		toolCalls = append(toolCalls, step.ToolCalls...)
+		for _, call := range step.ToolCalls {
+			toolCalls = append(toolCalls, *call)
+		}
 	}
 
 	return toolCalls
 }
 
+// ToolUsage returns the number of calls of each tool across all steps, keyed
+// by tool name. Tools that were never called are absent.
+func (m *Memory) ToolUsage() map[string]int {
+	usage := make(map[string]int)
+
+	for _, call := range m.GetToolCalls() {
+		usage[call.Name]++
+	}
+
+	return usage
+}
+
 // GetMessages returns all messages from all steps.
 func (m *Memory) GetMessages() []models.Message {
 	var messages []models.Message
@@ -186,7 +354,9 @@ func (m *Memory) GetMessages() []models.Message {
 	return messages
 }
 
-// String returns a string representation of the memory.
+// String returns a string representation of the memory. Tool arguments and
+// outputs are rendered as indented JSON and truncated to the maximum value
+// length.
 func (m *Memory) String() string {
 	var s string
 
@@ -199,12 +369,12 @@ func (m *Memory) String() string {
 
 		for j, toolCall := range step.ToolCalls {
 			s += fmt.Sprintf("  Tool Call %d: %s\n", j+1, toolCall.Name)
-			s += fmt.Sprintf("    Arguments: %v\n", toolCall.Arguments)
+			s += fmt.Sprintf("    Arguments: %s\n", m.formatValue(toolCall.Arguments))
 
 			if toolCall.Error != "" {
 				s += fmt.Sprintf("    Error: %s\n", toolCall.Error)
 			} else {
-				s += fmt.Sprintf("    Output: %v\n", toolCall.Output)
+				s += fmt.Sprintf("    Output: %s\n", m.formatValue(toolCall.Output))
 			}
 		}
 
@@ -213,3 +383,26 @@ func (m *Memory) String() string {
 
 	return s
 }
+
+// formatValue renders a tool argument or output for String. Strings are
+// shown as is and other values as JSON indented under the tool call, falling
+// back to %v for values that cannot be marshaled.
+func (m *Memory) formatValue(value any) string {
+	var formatted string
+	switch v := value.(type) {
+	case string:
+		formatted = v
+	default:
+		data, err := json.MarshalIndent(value, "    ", "  ")
+		if err != nil {
+			formatted = fmt.Sprintf("%v", value)
+		} else {
+			formatted = string(data)
+		}
+	}
+
+	if runes := []rune(formatted); m.maxValueLength > 0 && len(runes) > m.maxValueLength {
+		formatted = fmt.Sprintf("%s... (%d more characters)", string(runes[:m.maxValueLength]), len(runes)-m.maxValueLength)
+	}
+	return formatted
+}
@@ -1,8 +1,10 @@
 package memory
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -172,6 +174,23 @@ func TestMemoryToolCall(t *testing.T) {
 	}
 }
 
+// TestToolCallStaysLive tests that a returned tool call stays part of the
+// memory as more calls are added
+func TestToolCallStaysLive(t *testing.T) {
+	mem := NewMemory()
+	mem.AddActionStep("Use tools", nil)
+
+	first := mem.AddToolCall("tool", nil, "pending", nil)
+	for i := 0; i < 100; i++ {
+		mem.AddToolCall("tool", nil, i, nil)
+	}
+	first.Output = "done"
+
+	if output := mem.Steps[0].ToolCalls[0].Output; output != "done" {
+		t.Errorf("Expected the change to the first call to be recorded, got %v", output)
+	}
+}
+
 // TestMemoryCompleteStep tests completing a step
 func TestMemoryCompleteStep(t *testing.T) {
 	mem := NewMemory()
@@ -238,6 +257,45 @@ func TestMemoryGetSteps(t *testing.T) {
 	}
 }
 
+// TestMemoryTypedSteps tests that the fields of each step type can be read
+// back from memory
+func TestMemoryTypedSteps(t *testing.T) {
+	mem := NewMemory()
+
+	mem.AddSystemPromptStep("Be helpful", nil)
+	mem.CompleteCurrentStep()
+	mem.AddTaskStep("Find the weather", nil)
+	mem.CompleteCurrentStep()
+	mem.AddPlanningStep("Paris is in France", "Call the weather tool", nil)
+	mem.CompleteCurrentStep()
+	action := mem.AddActionStep("Step input", nil)
+	action.Output = "sunny"
+	mem.AddToolCall("weather", nil, "sunny", nil)
+	mem.CompleteCurrentStep()
+
+	if steps := mem.GetSystemPromptSteps(); len(steps) != 1 || steps[0].SystemPrompt != "Be helpful" {
+		t.Errorf("Expected the system prompt to be kept, got %+v", steps)
+	}
+	if steps := mem.GetTaskSteps(); len(steps) != 1 || steps[0].Task != "Find the weather" {
+		t.Errorf("Expected the task to be kept, got %+v", steps)
+	}
+	if steps := mem.GetPlanningSteps(); len(steps) != 1 || steps[0].Facts != "Paris is in France" || steps[0].Plan != "Call the weather tool" {
+		t.Errorf("Expected the facts and plan to be kept, got %+v", steps)
+	}
+
+	steps := mem.GetActionSteps()
+	if len(steps) != 1 || steps[0].Input != "Step input" || steps[0].Output != "sunny" {
+		t.Fatalf("Expected the input and output to be kept, got %+v", steps)
+	}
+	if len(steps[0].ToolCalls) != 1 || steps[0].EndTimestamp.IsZero() {
+		t.Errorf("Expected the action step to share its tool calls and completion, got %+v", steps[0].Step)
+	}
+
+	if len(mem.GetSteps()) != 4 {
+		t.Errorf("Expected 4 steps, got %d", len(mem.GetSteps()))
+	}
+}
+
 // TestMemoryGetToolCalls tests getting all tool calls from memory
 func TestMemoryGetToolCalls(t *testing.T) {
 	mem := NewMemory()
@@ -257,24 +315,45 @@ func TestMemoryGetToolCalls(t *testing.T) {
 	mem.CompleteCurrentStep()
 
 	// Get tool calls
-	_ = mem.GetToolCalls()
+	toolCalls := mem.GetToolCalls()
 
-	// Skip this check as tool calls might not be properly recorded in tests
-	/*
-		// Check tool calls
-		if len(toolCalls) != 3 {
-			t.Errorf("Expected 3 tool calls, got %d", len(toolCalls))
-		}
+	// Check tool calls
+	if len(toolCalls) != 3 {
+		t.Fatalf("Expected 3 tool calls, got %d", len(toolCalls))
+	}
 
-		names := []string{toolCalls[0].Name, toolCalls[1].Name, toolCalls[2].Name}
-		expected := []string{"tool1", "tool2", "tool3"}
+	names := []string{toolCalls[0].Name, toolCalls[1].Name, toolCalls[2].Name}
+	expected := []string{"tool1", "tool2", "tool3"}
 
-		for _, exp := range expected {
-			if !containsString(names, exp) {
-				t.Errorf("Expected tool call %s to be in the list, got %v", exp, names)
-			}
+	for _, exp := range expected {
+		if !slices.Contains(names, exp) {
+			t.Errorf("Expected tool call %s to be in the list, got %v", exp, names)
 		}
-	*/
+	}
+}
+
+// TestMemoryToolUsage tests counting tool calls per tool across steps
+func TestMemoryToolUsage(t *testing.T) {
+	mem := NewMemory()
+
+	if usage := mem.ToolUsage(); len(usage) != 0 {
+		t.Errorf("Expected no tool usage for empty memory, got %v", usage)
+	}
+
+	mem.AddActionStep("Action 1", nil)
+	mem.AddToolCall("search", nil, "output1", nil)
+	mem.AddToolCall("search", nil, "output2", nil)
+	mem.AddToolCall("calculator", nil, nil, errors.New("failed"))
+	mem.CompleteCurrentStep()
+
+	mem.AddActionStep("Action 2", nil)
+	mem.AddToolCall("search", nil, "output3", nil)
+	mem.CompleteCurrentStep()
+
+	expected := map[string]int{"search": 3, "calculator": 1}
+	if usage := mem.ToolUsage(); !reflect.DeepEqual(usage, expected) {
+		t.Errorf("Expected tool usage %v, got %v", expected, usage)
+	}
 }
 
 // TestMemoryGetMessages tests getting all messages from memory
@@ -341,4 +420,175 @@ func TestMemoryString(t *testing.T) {
 	if !strings.Contains(str, "Tool Call 1: test_tool") {
 		t.Error("Expected string to mention tool call name")
 	}
+
+	if !strings.Contains(str, "    Arguments: {\n      \"arg\": \"value\"\n    }\n") {
+		t.Errorf("Expected arguments as indented JSON, got:\n%s", str)
+	}
+
+	if !strings.Contains(str, "    Output: result\n") {
+		t.Errorf("Expected string output to be shown as is, got:\n%s", str)
+	}
+}
+
+// TestMemoryStringTruncation tests that long tool values are truncated
+func TestMemoryStringTruncation(t *testing.T) {
+	mem := NewMemory()
+	mem.SetMaxValueLength(10)
+
+	mem.AddActionStep("Use tool", nil)
+	mem.AddToolCall("test_tool", nil, strings.Repeat("x", 25), nil)
+	mem.CompleteCurrentStep()
+
+	if str := mem.String(); !strings.Contains(str, "Output: xxxxxxxxxx... (15 more characters)\n") {
+		t.Errorf("Expected output truncated to 10 characters, got:\n%s", str)
+	}
+
+	mem.SetMaxValueLength(0)
+	if str := mem.String(); !strings.Contains(str, strings.Repeat("x", 25)) {
+		t.Errorf("Expected output not to be truncated, got:\n%s", str)
+	}
+}
+
+// TestMeasureSizes tests measuring the size of a model call
+func TestMeasureSizes(t *testing.T) {
+	input := []models.Message{
+		{Role: models.RoleSystem, Content: "You are helpful."},
+		{Role: models.RoleUser, Content: "Hi"},
+	}
+
+	sizes := MeasureSizes(models.ApproxTokenCounter{CharsPerToken: 4}, input, "Hello there")
+
+	if sizes.InputChars != 18 {
+		t.Errorf("Expected 18 input chars, got %d", sizes.InputChars)
+	}
+	if sizes.OutputChars != 11 {
+		t.Errorf("Expected 11 output chars, got %d", sizes.OutputChars)
+	}
+	if sizes.InputTokens != 5 {
+		t.Errorf("Expected 5 input tokens, got %d", sizes.InputTokens)
+	}
+	if sizes.OutputTokens != 3 {
+		t.Errorf("Expected 3 output tokens, got %d", sizes.OutputTokens)
+	}
+
+	var total SizeMetrics
+	total.Add(sizes)
+	total.Add(sizes)
+	if total.InputChars != 36 || total.OutputTokens != 6 {
+		t.Errorf("Expected metrics to accumulate, got %+v", total)
+	}
+}
+
+// TestSequentialIDGenerator tests that injected generators produce predictable IDs
+func TestSequentialIDGenerator(t *testing.T) {
+	mem := NewMemory()
+	mem.SetIDGenerator(NewSequentialIDGenerator("id-"))
+
+	taskStep := mem.AddTaskStep("task", nil)
+	mem.CompleteCurrentStep()
+	actionStep := mem.AddActionStep("input", nil)
+	toolCall := mem.AddToolCall("tool", nil, "output", nil)
+
+	if taskStep.ID != "id-1" {
+		t.Errorf("Expected task step ID 'id-1', got '%s'", taskStep.ID)
+	}
+	if actionStep.ID != "id-2" {
+		t.Errorf("Expected action step ID 'id-2', got '%s'", actionStep.ID)
+	}
+	if toolCall.ID != "id-3" {
+		t.Errorf("Expected tool call ID 'id-3', got '%s'", toolCall.ID)
+	}
+}
+
+// TestAddToolCallWithID tests recording a tool call under a provider's id
+func TestAddToolCallWithID(t *testing.T) {
+	mem := NewMemory()
+	mem.SetIDGenerator(NewSequentialIDGenerator("id-"))
+	mem.AddActionStep("input", nil)
+
+	if toolCall := mem.AddToolCallWithID("call_abc123", "tool", nil, "output", nil); toolCall.ID != "call_abc123" {
+		t.Errorf("Expected tool call ID 'call_abc123', got '%s'", toolCall.ID)
+	}
+	if toolCall := mem.AddToolCallWithID("", "tool", nil, "output", nil); toolCall.ID != "id-2" {
+		t.Errorf("Expected generated tool call ID 'id-2', got '%s'", toolCall.ID)
+	}
+}
+
+// TestRandomIDGenerator tests that the default generator produces unique IDs
+func TestRandomIDGenerator(t *testing.T) {
+	gen := RandomIDGenerator{}
+	a, b := gen.NewID(), gen.NewID()
+
+	if len(a) != 32 {
+		t.Errorf("Expected a 32 character hex ID, got '%s'", a)
+	}
+	if a == b {
+		t.Errorf("Expected unique IDs, got '%s' twice", a)
+	}
+}
+
+// TestMemoryJSONRoundTrip tests that a saved memory reloads with all its
+// steps, fields, tool calls and timestamps
+func TestMemoryJSONRoundTrip(t *testing.T) {
+	mem := NewMemory()
+	mem.AddSystemPromptStep("Be helpful", []models.Message{{Role: models.RoleSystem, Content: "Be helpful"}})
+	mem.CompleteCurrentStep()
+	mem.AddTaskStep("Find the weather", []models.Message{{Role: models.RoleUser, Content: "Find the weather"}})
+	mem.CompleteCurrentStep()
+	mem.AddPlanningStep("Paris is in France", "Call the weather tool", nil)
+	mem.CompleteCurrentStep()
+	action := mem.AddActionStep("Step input", []models.Message{{Role: models.RoleAssistant, Content: "Calling weather"}})
+	action.Output = "sunny"
+	mem.AddTimedToolCall("call_1", "weather", map[string]any{"city": "Paris"}, "sunny", nil, 2*time.Second)
+	mem.AddToolCall("forecast", nil, nil, errors.New("unavailable"))
+	mem.CompleteCurrentStep()
+
+	data, err := json.Marshal(mem)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var loaded Memory
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if len(loaded.GetSteps()) != len(mem.GetSteps()) {
+		t.Fatalf("Expected %d steps, got %d", len(mem.GetSteps()), len(loaded.GetSteps()))
+	}
+	for i, step := range loaded.GetSteps() {
+		original := mem.GetSteps()[i]
+		if step.ID != original.ID || step.Type != original.Type || !reflect.DeepEqual(step.Messages, original.Messages) {
+			t.Errorf("Step %d: expected %+v, got %+v", i+1, original, step)
+		}
+		if !step.StartTimestamp.Equal(original.StartTimestamp) || !step.EndTimestamp.Equal(original.EndTimestamp) {
+			t.Errorf("Step %d: expected the timestamps to be kept", i+1)
+		}
+	}
+
+	if steps := loaded.GetSystemPromptSteps(); len(steps) != 1 || steps[0].SystemPrompt != "Be helpful" {
+		t.Errorf("Expected the system prompt step, got %+v", steps)
+	}
+	if steps := loaded.GetTaskSteps(); len(steps) != 1 || steps[0].Task != "Find the weather" {
+		t.Errorf("Expected the task step, got %+v", steps)
+	}
+	if steps := loaded.GetPlanningSteps(); len(steps) != 1 || steps[0].Facts != "Paris is in France" || steps[0].Plan != "Call the weather tool" {
+		t.Errorf("Expected the planning step, got %+v", steps)
+	}
+
+	actions := loaded.GetActionSteps()
+	if len(actions) != 1 || actions[0].Input != "Step input" || actions[0].Output != "sunny" {
+		t.Fatalf("Expected the action step, got %+v", actions)
+	}
+	calls := loaded.GetToolCalls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %d", len(calls))
+	}
+	if calls[0].ID != "call_1" || calls[0].Name != "weather" || calls[0].Arguments["city"] != "Paris" ||
+		calls[0].Output != "sunny" || calls[0].Duration != 2*time.Second {
+		t.Errorf("Unexpected first tool call: %+v", calls[0])
+	}
+	if calls[1].Name != "forecast" || calls[1].Error != "unavailable" {
+		t.Errorf("Unexpected second tool call: %+v", calls[1])
+	}
 }
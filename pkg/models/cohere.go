@@ -0,0 +1,315 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// CohereModel is a model that uses Cohere's v2 Chat API.
+type CohereModel struct {
+	Model          string
+	ApiKey         string
+	ApiURL         string
+	MaxTokens      int
+	Temperature    *float64
+	TopP           *float64
+	Stop           []string
+	Seed           *int64
+	Client         *http.Client
+	limiter        *rate.Limiter
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+
+	// NormalizeMessages enables NormalizeMessages for every request.
+	NormalizeMessages bool
+
+	// Headers are added to every request.
+	Headers map[string]string
+}
+
+// NewCohereModel creates a new CohereModel. The API key is read from the
+// COHERE_API_KEY environment variable unless set with WithApiKey.
+func NewCohereModel(model string, options ...Option) *CohereModel {
+	m := &CohereModel{
+		Model:     model,
+		ApiKey:    os.Getenv("COHERE_API_KEY"),
+		ApiURL:    "https://api.cohere.com/v2/chat",
+		MaxTokens: 1024,
+		Client: &http.Client{
+			Timeout: defaultTimeout,
+		},
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// cohereMessage is a message in Cohere's chat format.
+type cohereMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content,omitempty"`
+	ToolCalls  []cohereToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// cohereToolCall is a tool call in Cohere's chat format.
+type cohereToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// cohereTool is a tool definition in Cohere's chat format.
+type cohereTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+// cohereRequest is the request body of the chat endpoint.
+type cohereRequest struct {
+	Model         string          `json:"model"`
+	Messages      []cohereMessage `json:"messages"`
+	Tools         []cohereTool    `json:"tools,omitempty"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	P             *float64        `json:"p,omitempty"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Seed          *int64          `json:"seed,omitempty"`
+}
+
+// cohereResponse is the response body of the chat endpoint.
+type cohereResponse struct {
+	Message struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		ToolCalls []cohereToolCall `json:"tool_calls"`
+	} `json:"message"`
+	Usage struct {
+		Tokens struct {
+			InputTokens  float64 `json:"input_tokens"`
+			OutputTokens float64 `json:"output_tokens"`
+		} `json:"tokens"`
+	} `json:"usage"`
+}
+
+// Generate generates a response for the given messages.
+func (m *CohereModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	result, err := m.GenerateDetailed(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// GenerateDetailed generates a response for the given messages and returns
+// it with the token usage reported by the API.
+func (m *CohereModel) GenerateDetailed(ctx context.Context, messages []Message) (*GenerateResult, error) {
+	return m.generate(ctx, messages, nil)
+}
+
+// GenerateWithTools generates a response for the given messages,
+// with the tools provided as JSON schema.
+func (m *CohereModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	result, err := m.generate(ctx, messages, tools)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// generate sends a chat request, retrying retryable failures according to
+// the model's retry settings.
+func (m *CohereModel) generate(ctx context.Context, messages []Message, tools []map[string]any) (*GenerateResult, error) {
+	jsonPayload, err := json.Marshal(m.buildRequest(ctx, messages, tools))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	policy := retryPolicy{maxRetries: m.MaxRetries, baseDelay: m.RetryBaseDelay}
+	return withRetry(ctx, policy, func() (*GenerateResult, error) {
+		return m.doRequest(ctx, jsonPayload)
+	})
+}
+
+// buildRequest builds the request body for the given messages and tools.
+func (m *CohereModel) buildRequest(ctx context.Context, messages []Message, tools []map[string]any) cohereRequest {
+	params := resolveGenerationParams(ctx, GenerationParams{
+		MaxTokens:   m.MaxTokens,
+		Temperature: m.Temperature,
+		TopP:        m.TopP,
+		Stop:        m.Stop,
+	})
+
+	req := cohereRequest{
+		Model:         m.Model,
+		Messages:      make([]cohereMessage, 0, len(messages)),
+		MaxTokens:     params.MaxTokens,
+		Temperature:   params.Temperature,
+		P:             params.TopP,
+		StopSequences: params.Stop,
+		Seed:          m.Seed,
+	}
+
+	if m.NormalizeMessages {
+		messages = NormalizeMessages(messages)
+	}
+	for _, msg := range messages {
+		req.Messages = append(req.Messages, cohereMessageFor(msg))
+	}
+
+	for _, tool := range tools {
+		functionData, ok := tool["function"].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		name, ok := functionData["name"].(string)
+		if !ok {
+			continue
+		}
+
+		var definition cohereTool
+		definition.Type = "function"
+		definition.Function.Name = name
+		definition.Function.Description, _ = functionData["description"].(string)
+		definition.Function.Parameters, _ = functionData["parameters"].(map[string]any)
+		req.Tools = append(req.Tools, definition)
+	}
+
+	return req
+}
+
+// cohereMessageFor converts a message to Cohere's format. Assistant messages
+// holding tool calls in the agents' {"id", "tool", "args"} format are sent
+// as tool calls, so that the tool results that follow can refer to them.
+func cohereMessageFor(msg Message) cohereMessage {
+	switch msg.Role {
+	case RoleAssistant:
+		if calls := parseAgentToolCalls(msg.Content); calls != nil {
+			toolCalls := make([]cohereToolCall, 0, len(calls))
+			for _, call := range calls {
+				var toolCall cohereToolCall
+				toolCall.ID = call.ID
+				toolCall.Type = "function"
+				toolCall.Function.Name = call.Tool
+				toolCall.Function.Arguments = string(call.Args)
+				toolCalls = append(toolCalls, toolCall)
+			}
+			return cohereMessage{Role: "assistant", ToolCalls: toolCalls}
+		}
+		return cohereMessage{Role: "assistant", Content: msg.Content}
+	case RoleTool:
+		id := msg.ToolCallID
+		if id == "" {
+			id = msg.Name
+		}
+		return cohereMessage{Role: "tool", ToolCallID: id, Content: msg.Content}
+	default:
+		return cohereMessage{Role: string(msg.Role), Content: msg.Content}
+	}
+}
+
+// doRequest performs a single request against the API.
+func (m *CohereModel) doRequest(ctx context.Context, jsonPayload []byte) (*GenerateResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.ApiURL, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range m.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if m.ApiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.ApiKey))
+	}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+
+	if err := waitForRateLimit(ctx, m.limiter); err != nil {
+		return nil, err
+	}
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+		apiErr.RetryAfter, _ = retryAfter(resp)
+		return nil, classifyError(apiErr)
+	}
+
+	var response cohereResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	result := &GenerateResult{
+		PromptTokens:     int(response.Usage.Tokens.InputTokens),
+		CompletionTokens: int(response.Usage.Tokens.OutputTokens),
+	}
+	result.TotalTokens = result.PromptTokens + result.CompletionTokens
+
+	// Return the tool calls in the format the agents expect
+	if len(response.Message.ToolCalls) > 0 {
+		calls := make([]nativeToolCall, 0, len(response.Message.ToolCalls))
+		for _, toolCall := range response.Message.ToolCalls {
+			calls = append(calls, nativeToolCall{
+				ID:        toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+
+		content, err := formatToolCalls(calls)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Content = content
+		return result, nil
+	}
+
+	var content strings.Builder
+	for _, part := range response.Message.Content {
+		if part.Type == "text" {
+			content.WriteString(part.Text)
+		}
+	}
+	if content.Len() == 0 {
+		return nil, ErrEmptyResponse
+	}
+
+	result.Content = content.String()
+	return result, nil
+}
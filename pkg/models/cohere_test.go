@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestCohereModel creates a CohereModel whose requests go to server.
+func newTestCohereModel(server *httptest.Server, options ...Option) *CohereModel {
+	model := NewCohereModel("command-r-plus", append([]Option{WithApiKey("test-key")}, options...)...)
+	model.ApiURL = server.URL
+	return model
+}
+
+// TestCohereModelGenerate tests a text response and the request format
+func TestCohereModelGenerate(t *testing.T) {
+	var body map[string]any
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "abc",
+			"finish_reason": "COMPLETE",
+			"message": {"role": "assistant", "content": [{"type": "text", "text": "Hello there"}]},
+			"usage": {"tokens": {"input_tokens": 12, "output_tokens": 3}}
+		}`))
+	}))
+	defer server.Close()
+
+	model := newTestCohereModel(server, WithTemperature(0.3), WithTopP(0.9), WithStopSequences("END"))
+	result, err := model.GenerateDetailed(context.Background(), []Message{
+		{Role: RoleSystem, Content: "Be brief."},
+		{Role: RoleUser, Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateDetailed() error = %v", err)
+	}
+
+	want := GenerateResult{Content: "Hello there", PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}
+	if *result != want {
+		t.Errorf("Expected %+v, got %+v", want, *result)
+	}
+
+	if auth != "Bearer test-key" {
+		t.Errorf("Expected bearer authorization, got %q", auth)
+	}
+	if body["model"] != "command-r-plus" || body["temperature"] != 0.3 || body["p"] != 0.9 {
+		t.Errorf("Unexpected request settings: %v", body)
+	}
+	if stop, _ := body["stop_sequences"].([]any); len(stop) != 1 || stop[0] != "END" {
+		t.Errorf("Expected stop_sequences [END], got %v", body["stop_sequences"])
+	}
+	messages, _ := body["messages"].([]any)
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %v", body["messages"])
+	}
+	if first := messages[0].(map[string]any); first["role"] != "system" || first["content"] != "Be brief." {
+		t.Errorf("Unexpected system message: %v", first)
+	}
+}
+
+// TestCohereModelGenerateWithTools tests tool translation in both directions
+func TestCohereModelGenerateWithTools(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"finish_reason": "TOOL_CALL",
+			"message": {
+				"role": "assistant",
+				"tool_plan": "I will look up the weather.",
+				"tool_calls": [{
+					"id": "call_2",
+					"type": "function",
+					"function": {"name": "get_weather", "arguments": "{\"location\": \"Paris\"}"}
+				}]
+			}
+		}`))
+	}))
+	defer server.Close()
+
+	tools := []map[string]any{{
+		"type": "function",
+		"function": map[string]any{
+			"name":        "get_weather",
+			"description": "Gets the weather",
+			"parameters": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"location": map[string]any{"type": "string"}},
+			},
+		},
+	}}
+	messages := []Message{
+		{Role: RoleUser, Content: "Weather in Rome, then Paris?"},
+		{Role: RoleAssistant, Content: `{"id": "call_1", "tool": "get_weather", "args": {"location": "Rome"}}`},
+		{Role: RoleTool, Name: "get_weather", ToolCallID: "call_1", Content: "sunny"},
+	}
+
+	response, err := newTestCohereModel(server).GenerateWithTools(context.Background(), messages, tools)
+	if err != nil {
+		t.Fatalf("GenerateWithTools() error = %v", err)
+	}
+
+	var call struct {
+		ID   string         `json:"id"`
+		Tool string         `json:"tool"`
+		Args map[string]any `json:"args"`
+	}
+	if err := json.Unmarshal([]byte(response), &call); err != nil {
+		t.Fatalf("Expected a JSON tool call, got %q", response)
+	}
+	if call.ID != "call_2" || call.Tool != "get_weather" || call.Args["location"] != "Paris" {
+		t.Errorf("Unexpected tool call: %+v", call)
+	}
+
+	sentTools, _ := body["tools"].([]any)
+	if len(sentTools) != 1 {
+		t.Fatalf("Expected 1 tool, got %v", body["tools"])
+	}
+	function := sentTools[0].(map[string]any)["function"].(map[string]any)
+	if function["name"] != "get_weather" || function["parameters"] == nil {
+		t.Errorf("Unexpected tool definition: %v", function)
+	}
+
+	sent, _ := body["messages"].([]any)
+	if len(sent) != 3 {
+		t.Fatalf("Expected 3 messages, got %v", body["messages"])
+	}
+	assistant := sent[1].(map[string]any)
+	toolCalls, _ := assistant["tool_calls"].([]any)
+	if len(toolCalls) != 1 || toolCalls[0].(map[string]any)["id"] != "call_1" {
+		t.Errorf("Expected the assistant tool call to be translated, got %v", assistant)
+	}
+	if tool := sent[2].(map[string]any); tool["role"] != "tool" || tool["tool_call_id"] != "call_1" {
+		t.Errorf("Expected a tool message for call_1, got %v", tool)
+	}
+}
+
+// TestCohereModelError tests that error statuses are reported
+func TestCohereModelError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "invalid api token"}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestCohereModel(server).Generate(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected an APIError with status 401, got %v", err)
+	}
+}
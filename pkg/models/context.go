@@ -0,0 +1,22 @@
+package models
+
+import "context"
+
+// RequestIDHeader is the header used to propagate request IDs to providers.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key for request IDs.
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the given request ID.
+// Models attach it to outgoing provider requests as the X-Request-ID header,
+// so an inbound request can be traced end-to-end.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok && requestID != ""
+}
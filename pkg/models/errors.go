@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrContextWindowExceeded is returned when a request does not fit in the
+// model's context window.
+var ErrContextWindowExceeded = errors.New("context window exceeded")
+
+// ErrToolsNotSupported is returned when a request offers tools to a model
+// that does not support function calling.
+var ErrToolsNotSupported = errors.New("tools not supported")
+
+// contextWindowMarkers are substrings providers use in context-length errors.
+var contextWindowMarkers = []string{
+	"context_length_exceeded",
+	"maximum context length",
+	"context window",
+	"context length",
+	"too many tokens",
+	"input is too long",
+}
+
+// toolsNotSupportedMarkers are substrings providers use in errors rejecting
+// tools for models without function calling.
+var toolsNotSupportedMarkers = []string{
+	"does not support tools",
+	"tools are not supported",
+	"tool use is not supported",
+	"does not support function calling",
+	"function calling is not supported",
+	"tool choice requires",
+}
+
+// IsContextWindowError reports whether err indicates that the request did not
+// fit in the model's context window.
+func IsContextWindowError(err error) bool {
+	return errors.Is(err, ErrContextWindowExceeded)
+}
+
+// IsToolsNotSupportedError reports whether err indicates that the model does
+// not support the tools offered in the request.
+func IsToolsNotSupportedError(err error) bool {
+	return errors.Is(err, ErrToolsNotSupported)
+}
+
+// classifyError wraps err with ErrContextWindowExceeded or
+// ErrToolsNotSupported when its message indicates such a failure, and returns
+// it unchanged otherwise.
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, ErrContextWindowExceeded) || errors.Is(err, ErrToolsNotSupported) {
+		return err
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, marker := range contextWindowMarkers {
+		if strings.Contains(msg, marker) {
+			return fmt.Errorf("%w: %w", ErrContextWindowExceeded, err)
+		}
+	}
+	for _, marker := range toolsNotSupportedMarkers {
+		if strings.Contains(msg, marker) {
+			return fmt.Errorf("%w: %w", ErrToolsNotSupported, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HfRouterChatURL is the OpenAI-compatible chat-completions endpoint of the
+// Hugging Face router.
+const HfRouterChatURL = "https://router.huggingface.co/v1/chat/completions"
+
+// WithChatURL makes HfApiModel send requests with tools to an
+// OpenAI-compatible chat-completions endpoint, such as HfRouterChatURL,
+// instead of the text-generation API, which has no native tool calling. Tool
+// calls in the response are returned in the same format as OpenAIModel's. It
+// has no effect on other models.
+func WithChatURL(url string) Option {
+	return func(model any) {
+		if m, ok := model.(*HfApiModel); ok {
+			m.ChatURL = url
+		}
+	}
+}
+
+// hfChatMessage is a message in the chat-completions format.
+type hfChatMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []hfChatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// hfChatToolCall is a tool call in the chat-completions format. Index is set
+// by servers that split a call's arguments over several entries.
+type hfChatToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name string `json:"name,omitempty"`
+		// Arguments is a JSON string, as on OpenAI, or an object, as
+		// returned by some text-generation-inference versions.
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// hfChatRequest is the request body of the chat-completions endpoint.
+type hfChatRequest struct {
+	Model       string           `json:"model"`
+	Messages    []hfChatMessage  `json:"messages"`
+	Tools       []map[string]any `json:"tools,omitempty"`
+	MaxTokens   int              `json:"max_tokens,omitempty"`
+	Temperature *float64         `json:"temperature,omitempty"`
+	TopP        *float64         `json:"top_p,omitempty"`
+	Stop        []string         `json:"stop,omitempty"`
+	Seed        *int64           `json:"seed,omitempty"`
+}
+
+// hfChatResponse is the response body of the chat-completions endpoint.
+type hfChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content   string           `json:"content"`
+			ToolCalls []hfChatToolCall `json:"tool_calls"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// generateChat sends a chat-completions request with tools to ChatURL,
+// retrying retryable failures according to the model's retry settings.
+func (m *HfApiModel) generateChat(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	params := resolveGenerationParams(ctx, GenerationParams{
+		MaxTokens:   m.MaxTokens,
+		Temperature: m.Temperature,
+		TopP:        m.TopP,
+		Stop:        m.Stop,
+	})
+
+	req := hfChatRequest{
+		Model:       m.Model,
+		Messages:    make([]hfChatMessage, 0, len(messages)),
+		Tools:       tools,
+		MaxTokens:   params.MaxTokens,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		Stop:        params.Stop,
+		Seed:        m.Seed,
+	}
+	for _, msg := range m.messages(messages) {
+		req.Messages = append(req.Messages, hfChatMessageFor(msg))
+	}
+
+	jsonPayload, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	policy := retryPolicy{maxRetries: m.MaxRetries, baseDelay: m.RetryBaseDelay}
+	return withRetry(ctx, policy, func() (string, error) {
+		return m.doChatRequest(ctx, jsonPayload)
+	})
+}
+
+// hfChatMessageFor converts a message to the chat-completions format.
+// Assistant messages holding tool calls in the agents' {"id", "tool", "args"}
+// format are sent as tool calls, so that the tool results that follow can
+// refer to them.
+func hfChatMessageFor(msg Message) hfChatMessage {
+	switch msg.Role {
+	case RoleAssistant:
+		if calls := parseAgentToolCalls(msg.Content); calls != nil {
+			toolCalls := make([]hfChatToolCall, 0, len(calls))
+			for _, call := range calls {
+				var toolCall hfChatToolCall
+				toolCall.ID = call.ID
+				toolCall.Type = "function"
+				toolCall.Function.Name = call.Tool
+				arguments, _ := json.Marshal(string(call.Args))
+				toolCall.Function.Arguments = arguments
+				toolCalls = append(toolCalls, toolCall)
+			}
+			return hfChatMessage{Role: "assistant", ToolCalls: toolCalls}
+		}
+		return hfChatMessage{Role: "assistant", Content: msg.Content}
+	case RoleTool:
+		id := msg.ToolCallID
+		if id == "" {
+			id = msg.Name
+		}
+		return hfChatMessage{Role: "tool", ToolCallID: id, Content: msg.Content}
+	default:
+		return hfChatMessage{Role: string(msg.Role), Content: msg.Content}
+	}
+}
+
+// doChatRequest performs a single chat-completions request.
+func (m *HfApiModel) doChatRequest(ctx context.Context, jsonPayload []byte) (string, error) {
+	req, err := m.newRequest(ctx, m.ChatURL, jsonPayload)
+	if err != nil {
+		return "", err
+	}
+
+	if err := waitForRateLimit(ctx, m.limiter); err != nil {
+		return "", err
+	}
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+		apiErr.RetryAfter, _ = retryAfter(resp)
+		return "", classifyError(apiErr)
+	}
+
+	var response hfChatResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to parse response body: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", ErrEmptyResponse
+	}
+
+	message := response.Choices[0].Message
+
+	// Return the tool calls in the format the agents expect
+	if len(message.ToolCalls) > 0 {
+		return formatToolCalls(mergeToolCallFragments(message.ToolCalls))
+	}
+
+	if message.Content == "" {
+		return "", ErrEmptyResponse
+	}
+	return message.Content, nil
+}
+
+// mergeToolCallFragments reassembles tool calls whose arguments are split
+// over several entries. An entry continues the previous call if it has the
+// same index, or if it has neither an id nor a name.
+func mergeToolCallFragments(toolCalls []hfChatToolCall) []nativeToolCall {
+	var calls []nativeToolCall
+	var lastIndex *int
+
+	for _, toolCall := range toolCalls {
+		arguments := toolCallArguments(toolCall.Function.Arguments)
+
+		sameIndex := toolCall.Index != nil && lastIndex != nil && *toolCall.Index == *lastIndex
+		anonymous := toolCall.ID == "" && toolCall.Function.Name == ""
+		continues := len(calls) > 0 && (sameIndex || anonymous)
+		lastIndex = toolCall.Index
+
+		if continues {
+			last := &calls[len(calls)-1]
+			if last.ID == "" {
+				last.ID = toolCall.ID
+			}
+			if last.Name == "" {
+				last.Name = toolCall.Function.Name
+			}
+			last.Arguments += arguments
+			continue
+		}
+
+		calls = append(calls, nativeToolCall{
+			ID:        toolCall.ID,
+			Name:      toolCall.Function.Name,
+			Arguments: arguments,
+		})
+	}
+
+	return calls
+}
+
+// toolCallArguments returns the arguments of a tool call as a JSON string,
+// whether they were sent as a string or as an object.
+func toolCallArguments(raw json.RawMessage) string {
+	var arguments string
+	if err := json.Unmarshal(raw, &arguments); err == nil {
+		return arguments
+	}
+	return string(bytes.TrimSpace(raw))
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHfApiModelChatToolCalls tests that tool calls from the chat-completions
+// endpoint are returned in the same format as OpenAIModel's, with arguments
+// split over several entries reassembled
+func TestHfApiModelChatToolCalls(t *testing.T) {
+	var path string
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"choices": [{
+				"index": 0,
+				"message": {
+					"role": "assistant",
+					"content": null,
+					"tool_calls": [
+						{"index": 0, "id": "call_1", "type": "function", "function": {"name": "weather", "arguments": "{\"city\":"}},
+						{"index": 0, "function": {"arguments": "\"Paris\"}"}},
+						{"index": 1, "id": "call_2", "type": "function", "function": {"name": "time", "arguments": {"city": "Paris"}}}
+					]
+				},
+				"finish_reason": "tool_calls"
+			}]
+		}`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("meta-llama/Llama-3.3-70B-Instruct", WithChatURL(server.URL+"/v1/chat/completions"))
+	tools := []map[string]any{{
+		"type":     "function",
+		"function": map[string]any{"name": "weather", "description": "Gets the weather", "parameters": map[string]any{"type": "object"}},
+	}}
+
+	response, err := model.GenerateWithTools(context.Background(), []Message{
+		{Role: RoleUser, Content: "Weather and time in Paris?"},
+		{Role: RoleAssistant, Content: `{"id": "call_0", "tool": "weather", "args": {"city": "Rome"}}`},
+		{Role: RoleTool, Name: "weather", ToolCallID: "call_0", Content: "sunny"},
+	}, tools)
+	if err != nil {
+		t.Fatalf("GenerateWithTools() error = %v", err)
+	}
+
+	// The same calls as returned by OpenAIModel
+	want, err := formatToolCalls([]nativeToolCall{
+		{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`},
+		{ID: "call_2", Name: "time", Arguments: `{"city": "Paris"}`},
+	})
+	if err != nil {
+		t.Fatalf("formatToolCalls() error = %v", err)
+	}
+	if response != want {
+		t.Errorf("Expected %s, got %s", want, response)
+	}
+
+	if path != "/v1/chat/completions" {
+		t.Errorf("Expected a request to the chat URL, got %s", path)
+	}
+	if body["model"] != "meta-llama/Llama-3.3-70B-Instruct" {
+		t.Errorf("Expected the model in the request, got %v", body["model"])
+	}
+	if sent, _ := body["tools"].([]any); len(sent) != 1 {
+		t.Errorf("Expected the tools in the request, got %v", body["tools"])
+	}
+
+	messages, _ := body["messages"].([]any)
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %v", body["messages"])
+	}
+	assistant, _ := messages[1].(map[string]any)
+	toolCalls, _ := assistant["tool_calls"].([]any)
+	if len(toolCalls) != 1 {
+		t.Fatalf("Expected the assistant tool call to be sent as tool_calls, got %v", assistant)
+	}
+	function, _ := toolCalls[0].(map[string]any)["function"].(map[string]any)
+	if function["name"] != "weather" || function["arguments"] != `{"city": "Rome"}` {
+		t.Errorf("Expected the tool call with string arguments, got %v", function)
+	}
+	if tool, _ := messages[2].(map[string]any); tool["role"] != "tool" || tool["tool_call_id"] != "call_0" {
+		t.Errorf("Expected the tool result to refer to its call, got %v", tool)
+	}
+}
+
+// TestHfApiModelChatText tests a text response from the chat-completions
+// endpoint
+func TestHfApiModelChatText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "It is sunny."}}]}`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model", WithChatURL(server.URL))
+	response, err := model.GenerateWithTools(context.Background(), []Message{{Role: RoleUser, Content: "Weather?"}}, nil)
+	if err != nil {
+		t.Fatalf("GenerateWithTools() error = %v", err)
+	}
+	if response != "It is sunny." {
+		t.Errorf("Expected 'It is sunny.', got %q", response)
+	}
+}
@@ -0,0 +1,203 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidJSON is returned by JSONEnforcingModel when the wrapped model
+// does not produce valid JSON within the allowed retries.
+var ErrInvalidJSON = errors.New("model did not return valid JSON")
+
+// JSONEnforcingModel wraps a Model without native structured output so that
+// Generate returns valid JSON. It instructs the model to respond only with
+// JSON matching a schema, validates the response, and on failure asks again
+// with the validation error, up to a number of retries.
+//
+// The schema is a JSON schema; its type, properties, required, items and
+// enum keywords are checked. GenerateWithTools is passed through unchanged.
+type JSONEnforcingModel struct {
+	model      Model
+	schema     map[string]any
+	maxRetries int
+}
+
+// NewJSONEnforcingModel creates a JSONEnforcingModel. A nil schema accepts
+// any valid JSON.
+func NewJSONEnforcingModel(model Model, schema map[string]any, maxRetries int) *JSONEnforcingModel {
+	return &JSONEnforcingModel{
+		model:      model,
+		schema:     schema,
+		maxRetries: maxRetries,
+	}
+}
+
+// Generate generates a JSON response for the given messages. The response is
+// returned without any surrounding code fence.
+func (m *JSONEnforcingModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	instruction, err := m.instruction()
+	if err != nil {
+		return "", err
+	}
+
+	messages = append(append([]Message(nil), messages...), Message{
+		Role:    RoleSystem,
+		Content: instruction,
+	})
+
+	var validationErr error
+	for attempt := 0; attempt <= m.maxRetries; attempt++ {
+		response, err := m.model.Generate(ctx, messages)
+		if err != nil {
+			return "", err
+		}
+
+		content := stripJSONFence(response)
+		if validationErr = validateJSON(content, m.schema); validationErr == nil {
+			return content, nil
+		}
+
+		messages = append(messages,
+			Message{Role: RoleAssistant, Content: response},
+			Message{
+				Role:    RoleUser,
+				Content: fmt.Sprintf("Your response was invalid: %v. Respond again with only valid JSON.", validationErr),
+			},
+		)
+	}
+
+	return "", fmt.Errorf("%w after %d attempts: %v", ErrInvalidJSON, m.maxRetries+1, validationErr)
+}
+
+// GenerateWithTools passes the request to the wrapped model unchanged, since
+// tool calls are already structured.
+func (m *JSONEnforcingModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	return m.model.GenerateWithTools(ctx, messages, tools)
+}
+
+// instruction returns the instruction appended to every request.
+func (m *JSONEnforcingModel) instruction() (string, error) {
+	if m.schema == nil {
+		return "Respond only with valid JSON, without any other text.", nil
+	}
+
+	schemaJSON, err := json.Marshal(m.schema)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return fmt.Sprintf("Respond only with valid JSON matching this JSON schema, without any other text:\n%s", schemaJSON), nil
+}
+
+// stripJSONFence removes a Markdown code fence around a response, if any.
+func stripJSONFence(response string) string {
+	content := strings.TrimSpace(response)
+	if !strings.HasPrefix(content, "```") || !strings.HasSuffix(content, "```") || len(content) < 6 {
+		return content
+	}
+
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "```"), "```")
+	content = strings.TrimPrefix(content, "json")
+	return strings.TrimSpace(content)
+}
+
+// validateJSON checks that content is valid JSON matching schema.
+func validateJSON(content string, schema map[string]any) error {
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("not valid JSON: %w", err)
+	}
+	if schema == nil {
+		return nil
+	}
+	return validateSchema(schema, value, "$")
+}
+
+// validateSchema checks a decoded JSON value against a JSON schema subset.
+func validateSchema(schema map[string]any, value any, path string) error {
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v", path, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range stringList(schema["required"]) {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s is missing required property %q", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, propertySchema := range properties {
+			property, ok := object[name]
+			propertySchema, isSchema := propertySchema.(map[string]any)
+			if !ok || !isSchema {
+				continue
+			}
+			if err := validateSchema(propertySchema, property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range array {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	}
+
+	return nil
+}
+
+// stringList converts a decoded or literal list of strings.
+func stringList(value any) []string {
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []any:
+		var strs []string
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
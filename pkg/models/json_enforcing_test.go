@@ -0,0 +1,83 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scriptedModel returns its responses in order and records each request.
+type scriptedModel struct {
+	responses []string
+	calls     [][]Message
+}
+
+func (m *scriptedModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	m.calls = append(m.calls, messages)
+	response := m.responses[0]
+	m.responses = m.responses[1:]
+	return response, nil
+}
+
+func (m *scriptedModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	return m.Generate(ctx, messages)
+}
+
+// TestJSONEnforcingModel tests that invalid responses are retried with the
+// validation error until valid JSON is returned
+func TestJSONEnforcingModel(t *testing.T) {
+	schema := map[string]any{
+		"type":     "object",
+		"required": []string{"city", "temperature"},
+		"properties": map[string]any{
+			"city":        map[string]any{"type": "string"},
+			"temperature": map[string]any{"type": "number"},
+		},
+	}
+	messages := []Message{{Role: RoleUser, Content: "Weather in Paris?"}}
+
+	t.Run("retries until valid", func(t *testing.T) {
+		inner := &scriptedModel{responses: []string{
+			"It is sunny in Paris.",
+			`{"city": "Paris"}`,
+			"```json\n{\"city\": \"Paris\", \"temperature\": 21}\n```",
+		}}
+		model := NewJSONEnforcingModel(inner, schema, 2)
+
+		response, err := model.Generate(context.Background(), messages)
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if response != `{"city": "Paris", "temperature": 21}` {
+			t.Errorf("Expected the unfenced JSON, got %q", response)
+		}
+
+		if len(inner.calls) != 3 {
+			t.Fatalf("Expected 3 calls, got %d", len(inner.calls))
+		}
+		if instruction := inner.calls[0][1].Content; !strings.Contains(instruction, `"required":["city","temperature"]`) {
+			t.Errorf("Expected the schema in the instruction, got %q", instruction)
+		}
+		last := inner.calls[2]
+		if feedback := last[len(last)-1].Content; !strings.Contains(feedback, `missing required property "temperature"`) {
+			t.Errorf("Expected the validation error to be fed back, got %q", feedback)
+		}
+		if len(messages) != 1 {
+			t.Errorf("Expected the caller's messages to be left unchanged, got %d", len(messages))
+		}
+	})
+
+	t.Run("fails after retries", func(t *testing.T) {
+		inner := &scriptedModel{responses: []string{"no", `{"city": 7, "temperature": 21}`}}
+		model := NewJSONEnforcingModel(inner, schema, 1)
+
+		_, err := model.Generate(context.Background(), messages)
+		if !errors.Is(err, ErrInvalidJSON) {
+			t.Fatalf("Expected ErrInvalidJSON, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "$.city must be a string") {
+			t.Errorf("Expected the last validation error, got %v", err)
+		}
+	})
+}
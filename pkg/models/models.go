@@ -2,6 +2,8 @@
 package models
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,6 +12,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 // MessageRole represents the role of a message.
@@ -31,6 +35,8 @@ type Message struct {
 	Role    MessageRole `json:"role"`
 	Content string      `json:"content"`
 	Name    string      `json:"name,omitempty"`
+	// ToolCallID is the id of the tool call a tool message responds to.
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
 // Model represents a language model that can generate responses.
@@ -45,11 +51,29 @@ type Model interface {
 
 // HfApiModel is a model that uses the Hugging Face Inference API.
 type HfApiModel struct {
-	Model     string
-	ApiKey    string
-	ApiURL    string
-	MaxTokens int
-	Client    *http.Client
+	Model          string
+	ApiKey         string
+	ApiURL         string
+	MaxTokens      int
+	Temperature    *float64
+	TopP           *float64
+	Stop           []string
+	Seed           *int64
+	Client         *http.Client
+	limiter        *rate.Limiter
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+
+	// NormalizeMessages enables NormalizeMessages for every request.
+	NormalizeMessages bool
+
+	// Headers are added to every request.
+	Headers map[string]string
+
+	// ChatURL is the OpenAI-compatible chat-completions endpoint that
+	// requests with tools are sent to. When empty, tools are sent to the
+	// text-generation API.
+	ChatURL string
 }
 
 // Option is a functional option for configuring a model.
@@ -63,6 +87,102 @@ func WithMaxTokens(maxTokens int) Option {
 			m.MaxTokens = maxTokens
 		case *OpenAIModel:
 			m.MaxTokens = maxTokens
+		case *CohereModel:
+			m.MaxTokens = maxTokens
+		}
+	}
+}
+
+// Supported range of sampling temperatures.
+const (
+	MinTemperature = 0.0
+	MaxTemperature = 2.0
+)
+
+// WithTemperature sets the model-level sampling temperature. Values outside
+// [MinTemperature, MaxTemperature] are clamped to the nearest bound.
+func WithTemperature(temperature float64) Option {
+	return func(model any) {
+		temperature = min(max(temperature, MinTemperature), MaxTemperature)
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.Temperature = Float(temperature)
+		case *OpenAIModel:
+			m.Temperature = Float(temperature)
+		case *CohereModel:
+			m.Temperature = Float(temperature)
+		}
+	}
+}
+
+// WithTopP sets the model-level nucleus sampling probability mass. When it
+// is not set, top_p is omitted from requests and the provider default applies.
+func WithTopP(topP float64) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.TopP = Float(topP)
+		case *OpenAIModel:
+			m.TopP = Float(topP)
+		case *CohereModel:
+			m.TopP = Float(topP)
+		}
+	}
+}
+
+// WithStopSequences sets sequences at which the model stops generating, for
+// example "```" to end a response after a code block. Calling it with no
+// sequences leaves stop unset.
+func WithStopSequences(stop ...string) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.Stop = stop
+		case *OpenAIModel:
+			m.Stop = stop
+		case *CohereModel:
+			m.Stop = stop
+		}
+	}
+}
+
+// WithSeed sets the sampling seed so that repeated requests return the same
+// output where the provider supports it. When it is not set, seed is omitted.
+func WithSeed(seed int64) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.Seed = &seed
+		case *OpenAIModel:
+			m.Seed = &seed
+		case *CohereModel:
+			m.Seed = &seed
+		}
+	}
+}
+
+// WithParallelToolCalls sets whether OpenAIModel lets the model call several
+// tools in one response, through the parallel_tool_calls parameter. When
+// false, each response holds at most one tool call, so an agent takes a
+// single action per step. It is only sent with tools, and has no effect on
+// HfApiModel and CohereModel, whose APIs have no such parameter.
+func WithParallelToolCalls(enabled bool) Option {
+	return func(model any) {
+		if m, ok := model.(*OpenAIModel); ok {
+			m.ParallelToolCalls = &enabled
+		}
+	}
+}
+
+// WithJSONMode makes OpenAIModel request a JSON object response by setting
+// response_format to json_object. OpenAI rejects such requests unless the
+// word "JSON" appears in the messages, so the system prompt must still ask
+// for JSON. It has no effect on HfApiModel, whose serverless API does not
+// support response formats.
+func WithJSONMode() Option {
+	return func(model any) {
+		if m, ok := model.(*OpenAIModel); ok {
+			m.JSONMode = true
 		}
 	}
 }
@@ -75,10 +195,36 @@ func WithApiKey(apiKey string) Option {
 			m.ApiKey = apiKey
 		case *OpenAIModel:
 			m.ApiKey = apiKey
+		case *CohereModel:
+			m.ApiKey = apiKey
+		}
+	}
+}
+
+// WithHeader adds a header to every request the model sends. Calls with
+// distinct keys accumulate; a repeated key replaces the earlier value.
+func WithHeader(key, value string) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.Headers = withHeader(m.Headers, key, value)
+		case *OpenAIModel:
+			m.Headers = withHeader(m.Headers, key, value)
+		case *CohereModel:
+			m.Headers = withHeader(m.Headers, key, value)
 		}
 	}
 }
 
+// withHeader sets key in headers, allocating the map if needed.
+func withHeader(headers map[string]string, key, value string) map[string]string {
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+	headers[key] = value
+	return headers
+}
+
 // WithHttpClient sets the HTTP client to use for API requests.
 func WithHttpClient(client *http.Client) Option {
 	return func(model any) {
@@ -87,6 +233,29 @@ func WithHttpClient(client *http.Client) Option {
 			m.Client = client
 		case *OpenAIModel:
 			m.httpClient = client
+		case *CohereModel:
+			m.Client = client
+		}
+	}
+}
+
+// WithRetry enables retrying of transient failures, waiting baseDelay before
+// the first retry and doubling the delay for each subsequent attempt, unless
+// the server asks for a different delay with Retry-After. Empty responses,
+// network errors and statuses 429, 500, 502, 503 and 504 are retried; other
+// failures, such as 400 or 401, are returned immediately.
+func WithRetry(maxRetries int, baseDelay time.Duration) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.MaxRetries = maxRetries
+			m.RetryBaseDelay = baseDelay
+		case *OpenAIModel:
+			m.MaxRetries = maxRetries
+			m.RetryBaseDelay = baseDelay
+		case *CohereModel:
+			m.MaxRetries = maxRetries
+			m.RetryBaseDelay = baseDelay
 		}
 	}
 }
@@ -111,39 +280,150 @@ func NewHfApiModel(model string, options ...Option) *HfApiModel {
 
 // Generate generates a response for the given messages.
 func (m *HfApiModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	result, err := m.GenerateDetailed(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// GenerateDetailed generates a response for the given messages. The
+// serverless API does not report token usage, so the counts are zero.
+func (m *HfApiModel) GenerateDetailed(ctx context.Context, messages []Message) (*GenerateResult, error) {
 	// Convert messages to the format expected by the API
 	payload := map[string]any{
-		"inputs": messages,
-		"parameters": map[string]any{
-			"max_new_tokens":   m.MaxTokens,
-			"return_full_text": false,
-		},
+		"inputs":     m.messages(messages),
+		"parameters": m.parameters(ctx),
 	}
 
+	content, err := m.generate(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &GenerateResult{Content: content}, nil
+}
+
+// GenerateWithTools generates a response for the given messages,
+// with the tools provided as JSON schema. With a ChatURL, the request is sent
+// to the chat-completions endpoint and tool calls are returned in the agents'
+// {"id", "tool", "args"} format.
+func (m *HfApiModel) GenerateWithTools(
+	ctx context.Context,
+	messages []Message,
+	tools []map[string]any,
+) (string, error) {
+	if m.ChatURL != "" {
+		return m.generateChat(ctx, messages, tools)
+	}
+
+	// Convert messages to the format expected by the API
+	parameters := m.parameters(ctx)
+	parameters["tools"] = tools
+	payload := map[string]any{
+		"inputs":     m.messages(messages),
+		"parameters": parameters,
+	}
+
+	return m.generate(ctx, payload)
+}
+
+// messages returns the messages to send, normalized if enabled.
+func (m *HfApiModel) messages(messages []Message) []Message {
+	if m.NormalizeMessages {
+		return NormalizeMessages(messages)
+	}
+	return messages
+}
+
+// parameters resolves the generation settings for a request into the
+// API's parameters object.
+func (m *HfApiModel) parameters(ctx context.Context) map[string]any {
+	params := resolveGenerationParams(ctx, GenerationParams{
+		MaxTokens:   m.MaxTokens,
+		Temperature: m.Temperature,
+		TopP:        m.TopP,
+		Stop:        m.Stop,
+	})
+
+	parameters := map[string]any{
+		"max_new_tokens":   params.MaxTokens,
+		"return_full_text": false,
+	}
+	if params.Temperature != nil {
+		parameters["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		parameters["top_p"] = *params.TopP
+	}
+	if len(params.Stop) > 0 {
+		parameters["stop"] = params.Stop
+	}
+	if m.Seed != nil {
+		parameters["seed"] = *m.Seed
+	}
+
+	return parameters
+}
+
+// generate sends the payload to the API, retrying retryable failures
+// according to the model's retry settings.
+func (m *HfApiModel) generate(ctx context.Context, payload map[string]any) (string, error) {
 	// Convert payload to JSON
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
+	policy := retryPolicy{maxRetries: m.MaxRetries, baseDelay: m.RetryBaseDelay}
+	return withRetry(ctx, policy, func() (string, error) {
+		return m.doRequest(ctx, jsonPayload)
+	})
+}
+
+// modelURL returns the text-generation URL of the model.
+func (m *HfApiModel) modelURL() string {
+	return fmt.Sprintf("%s/%s", m.ApiURL, m.Model)
+}
+
+// newRequest creates a request against url with the given JSON payload.
+func (m *HfApiModel) newRequest(ctx context.Context, url string, jsonPayload []byte) (*http.Request, error) {
 	// Create HTTP request
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		fmt.Sprintf("%s/%s", m.ApiURL, m.Model),
-		strings.NewReader(string(jsonPayload)),
+		url,
+		bytes.NewReader(jsonPayload),
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
+	for key, value := range m.Headers {
+		req.Header.Set(key, value)
+	}
 	req.Header.Set("Content-Type", "application/json")
 	if m.ApiKey != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.ApiKey))
 	}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+
+	return req, nil
+}
+
+// doRequest performs a single request against the API.
+func (m *HfApiModel) doRequest(ctx context.Context, jsonPayload []byte) (string, error) {
+	req, err := m.newRequest(ctx, m.modelURL(), jsonPayload)
+	if err != nil {
+		return "", err
+	}
 
 	// Send request
+	if err := waitForRateLimit(ctx, m.limiter); err != nil {
+		return "", err
+	}
 	resp, err := m.Client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to send request: %w", err)
@@ -153,7 +433,9 @@ func (m *HfApiModel) Generate(ctx context.Context, messages []Message) (string,
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("request failed with status %d: %s", resp.StatusCode, body)
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+		apiErr.RetryAfter, _ = retryAfter(resp)
+		return "", classifyError(apiErr)
 	}
 
 	// Read response body
@@ -172,83 +454,88 @@ func (m *HfApiModel) Generate(ctx context.Context, messages []Message) (string,
 	}
 
 	if len(result) == 0 {
-		return "", errors.New("empty response from model")
+		return "", ErrEmptyResponse
 	}
 
 	return result[0].GeneratedText, nil
 }
 
-// GenerateWithTools generates a response for the given messages,
-// with the tools provided as JSON schema.
-func (m *HfApiModel) GenerateWithTools(
-	ctx context.Context,
-	messages []Message,
-	tools []map[string]any,
-) (string, error) {
-	// Convert messages to the format expected by the API
+// GenerateStream generates a response for the given messages, streaming the
+// generated tokens from the text-generation server-sent event stream.
+func (m *HfApiModel) GenerateStream(ctx context.Context, messages []Message) (<-chan StreamChunk, error) {
 	payload := map[string]any{
-		"inputs": messages,
-		"parameters": map[string]any{
-			"max_new_tokens":   m.MaxTokens,
-			"return_full_text": false,
-			"tools":            tools,
-		},
+		"inputs":     m.messages(messages),
+		"parameters": m.parameters(ctx),
+		"stream":     true,
 	}
 
-	// Convert payload to JSON
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		fmt.Sprintf("%s/%s", m.ApiURL, m.Model),
-		strings.NewReader(string(jsonPayload)),
-	)
+	req, err := m.newRequest(ctx, m.modelURL(), jsonPayload)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
+	req.Header.Set("Accept", "text/event-stream")
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	if m.ApiKey != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.ApiKey))
+	if err := waitForRateLimit(ctx, m.limiter); err != nil {
+		return nil, err
 	}
-
-	// Send request
 	resp, err := m.Client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
 
-	// Check response status
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("request failed with status %d: %s", resp.StatusCode, body)
+		return nil, classifyError(fmt.Errorf("request failed with status %d: %s", resp.StatusCode, body))
 	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	// Parse response
-	var result []struct {
-		GeneratedText string `json:"generated_text"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response body: %w", err)
-	}
+	chunks := make(chan StreamChunk)
+	go func() {
+		defer close(chunks)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue // Blank separator lines and other SSE fields
+			}
+
+			var event struct {
+				Token struct {
+					Text    string `json:"text"`
+					Special bool   `json:"special"`
+				} `json:"token"`
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+				sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("failed to parse stream event: %w", err)})
+				return
+			}
+
+			if event.Error != "" {
+				sendChunk(ctx, chunks, StreamChunk{Err: classifyError(errors.New(event.Error))})
+				return
+			}
+
+			if event.Token.Special || event.Token.Text == "" {
+				continue
+			}
+
+			if !sendChunk(ctx, chunks, StreamChunk{Delta: event.Token.Text}) {
+				return
+			}
+		}
 
-	if len(result) == 0 {
-		return "", errors.New("empty response from model")
-	}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			sendChunk(ctx, chunks, StreamChunk{Err: fmt.Errorf("failed to read stream: %w", err)})
+		}
+	}()
 
-	return result[0].GeneratedText, nil
+	return chunks, nil
 }
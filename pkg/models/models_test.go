@@ -3,8 +3,11 @@ package models
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -265,4 +268,783 @@ func TestEmptyResponseHandling(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error about empty response, got nil")
 	}
+
+	if !errors.Is(err, ErrEmptyResponse) {
+		t.Errorf("Expected ErrEmptyResponse, got %v", err)
+	}
+}
+
+// TestEmptyResponseRetry tests that an empty response is retried
+func TestEmptyResponseRetry(t *testing.T) {
+	var requests int
+
+	// Create a test server that returns an empty array once, then a valid response
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests == 1 {
+			w.Write([]byte("[]"))
+			return
+		}
+		w.Write([]byte(`[{"generated_text": "warm response"}]`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model", WithRetry(2, time.Millisecond))
+	model.ApiURL = server.URL
+
+	messages := []Message{
+		{Role: RoleUser, Content: "Hello"},
+	}
+
+	response, err := model.Generate(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if response != "warm response" {
+		t.Errorf("Expected 'warm response', got '%s'", response)
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}
+
+// TestTransientErrorRetry tests which failed responses are retried
+func TestTransientErrorRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{"succeeds after two transient failures", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, false, 3},
+		{"retries server errors", []int{http.StatusInternalServerError, http.StatusGatewayTimeout}, false, 3},
+		{"gives up after max retries", []int{502, 502, 502}, true, 3},
+		{"does not retry bad requests", []int{http.StatusBadRequest}, true, 1},
+		{"does not retry unauthorized", []int{http.StatusUnauthorized}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[requests-1])
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`[{"generated_text": "recovered"}]`))
+			}))
+			defer server.Close()
+
+			model := NewHfApiModel("test-model", WithRetry(2, time.Millisecond))
+			model.ApiURL = server.URL
+
+			response, err := model.Generate(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+			if tt.wantErr {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("Expected an APIError, got %v", err)
+				}
+				if apiErr.StatusCode != tt.statuses[len(tt.statuses)-1] {
+					t.Errorf("Expected status %d, got %d", tt.statuses[len(tt.statuses)-1], apiErr.StatusCode)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if response != "recovered" {
+					t.Errorf("Expected 'recovered', got '%s'", response)
+				}
+			}
+
+			if requests != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, requests)
+			}
+		})
+	}
+}
+
+// TestRetryAfter tests that a Retry-After delay is honoured and that the
+// wait is cut short by context cancellation
+func TestRetryAfter(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model", WithRetry(2, time.Millisecond))
+	model.ApiURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := model.Generate(ctx, []Message{{Role: RoleUser, Content: "Hello"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected the wait to stop at the deadline, took %v", elapsed)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request before the Retry-After wait, got %d", requests)
+	}
+}
+
+// TestApproxTokenCounter tests the character based token estimate
+func TestApproxTokenCounter(t *testing.T) {
+	counter := ApproxTokenCounter{CharsPerToken: 4}
+
+	if got := counter.CountTokens(""); got != 0 {
+		t.Errorf("Expected 0 tokens for empty text, got %d", got)
+	}
+
+	if got := counter.CountTokens("abcd"); got != 1 {
+		t.Errorf("Expected 1 token for 4 characters, got %d", got)
+	}
+
+	if got := counter.CountTokens("abcdefghi"); got != 3 {
+		t.Errorf("Expected 3 tokens for 9 characters, got %d", got)
+	}
+
+	if got := DefaultTokenCounter.CountTokens("Hello, world!"); got <= 0 {
+		t.Errorf("Expected default counter to return a positive count, got %d", got)
+	}
+}
+
+// TestHfApiModelRequestID tests that a request ID in the context is sent as a header
+func TestHfApiModelRequestID(t *testing.T) {
+	var gotRequestID string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestID = r.Header.Get("X-Request-ID")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+
+	messages := []Message{
+		{Role: RoleUser, Content: "Hello"},
+	}
+
+	ctx := ContextWithRequestID(context.Background(), "req-123")
+	if _, err := model.Generate(ctx, messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if gotRequestID != "req-123" {
+		t.Errorf("Expected X-Request-ID header 'req-123', got '%s'", gotRequestID)
+	}
+
+	// Without a request ID in the context, no header is sent
+	if _, err := model.Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if gotRequestID != "" {
+		t.Errorf("Expected no X-Request-ID header, got '%s'", gotRequestID)
+	}
+}
+
+// TestContextWindowErrorClassification tests that context-length failures are classified
+func TestContextWindowErrorClassification(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Input validation error: inputs tokens + max_new_tokens must be <= 4096. This model's maximum context length is 4096"}`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+
+	_, err := model.Generate(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+	if !IsContextWindowError(err) {
+		t.Errorf("Expected a context window error, got %v", err)
+	}
+
+	if IsContextWindowError(errors.New("request failed with status 500: internal error")) {
+		t.Error("Expected an unrelated error not to be classified as a context window error")
+	}
+}
+
+// TestToolsNotSupportedErrorClassification tests that rejections of tools
+// are classified
+func TestToolsNotSupportedErrorClassification(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "registry.ollama.ai/library/gemma:2b does not support tools"}`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+
+	tools := []map[string]any{{"type": "function", "function": map[string]any{"name": "search"}}}
+	_, err := model.GenerateWithTools(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}}, tools)
+	if !IsToolsNotSupportedError(err) {
+		t.Errorf("Expected a tools-not-supported error, got %v", err)
+	}
+	if IsContextWindowError(err) {
+		t.Errorf("Expected the error not to be classified as a context window error, got %v", err)
+	}
+
+	if IsToolsNotSupportedError(errors.New("request failed with status 500: internal error")) {
+		t.Error("Expected an unrelated error not to be classified as a tools-not-supported error")
+	}
+}
+
+// TestGenerationParamsPrecedence tests that per-call settings override agent-level
+// settings, which override model-level settings
+func TestGenerationParamsPrecedence(t *testing.T) {
+	var parameters map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Parameters map[string]any `json:"parameters"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		parameters = payload.Parameters
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model", WithMaxTokens(100), WithTemperature(0.1))
+	model.ApiURL = server.URL
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		wantMaxTokens   float64
+		wantTemperature float64
+		wantTopP        any
+	}{
+		{
+			name:            "model-level",
+			ctx:             context.Background(),
+			wantMaxTokens:   100,
+			wantTemperature: 0.1,
+		},
+		{
+			name: "agent-level over model-level",
+			ctx: ContextWithDefaultGenerationParams(context.Background(), GenerationParams{
+				MaxTokens:   200,
+				Temperature: Float(0.2),
+			}),
+			wantMaxTokens:   200,
+			wantTemperature: 0.2,
+		},
+		{
+			name: "per-call over agent-level",
+			ctx: ContextWithDefaultGenerationParams(
+				ContextWithGenerationParams(context.Background(), GenerationParams{MaxTokens: 300}),
+				GenerationParams{MaxTokens: 200, Temperature: Float(0.2), TopP: Float(0.9)},
+			),
+			wantMaxTokens:   300,
+			wantTemperature: 0.2,
+			wantTopP:        0.9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := model.Generate(tt.ctx, messages); err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			if parameters["max_new_tokens"] != tt.wantMaxTokens {
+				t.Errorf("Expected max_new_tokens %v, got %v", tt.wantMaxTokens, parameters["max_new_tokens"])
+			}
+			if parameters["temperature"] != tt.wantTemperature {
+				t.Errorf("Expected temperature %v, got %v", tt.wantTemperature, parameters["temperature"])
+			}
+			if parameters["top_p"] != tt.wantTopP {
+				t.Errorf("Expected top_p %v, got %v", tt.wantTopP, parameters["top_p"])
+			}
+		})
+	}
+}
+
+// TestHfApiModelGenerateStream tests streaming tokens from the SSE stream
+func TestHfApiModelGenerateStream(t *testing.T) {
+	var stream bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		stream = payload.Stream
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range []string{`{"text": "Hello", "special": false}`, `{"text": ", world", "special": false}`, `{"text": "</s>", "special": true}`} {
+			fmt.Fprintf(w, "data: {\"token\": %s}\n\n", token)
+		}
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+
+	chunks, err := model.GenerateStream(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("GenerateStream() error = %v", err)
+	}
+
+	var deltas []string
+	response, err := CollectStream(context.Background(), chunks, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("CollectStream() error = %v", err)
+	}
+
+	if !stream {
+		t.Error("Expected the request to ask for a stream")
+	}
+	if response != "Hello, world" {
+		t.Errorf("Expected 'Hello, world', got '%s'", response)
+	}
+	if len(deltas) != 2 {
+		t.Errorf("Expected 2 deltas without the special token, got %v", deltas)
+	}
+}
+
+// TestHfApiModelGenerateStreamCancel tests that the stream closes when the context is cancelled
+func TestHfApiModelGenerateStreamCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"token\": {\"text\": \"Hello\"}}\n\n")
+		w.(http.Flusher).Flush()
+
+		// Stall until the test ends
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks, err := model.GenerateStream(ctx, []Message{{Role: RoleUser, Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("GenerateStream() error = %v", err)
+	}
+
+	if chunk := <-chunks; chunk.Delta != "Hello" {
+		t.Fatalf("Expected the first delta 'Hello', got %+v", chunk)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-chunks:
+		for ok {
+			_, ok = <-chunks
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the stream to close after cancellation")
+	}
+}
+
+// TestWithTemperature tests that temperatures are set on both models and clamped to the supported range
+func TestWithTemperature(t *testing.T) {
+	tests := []struct {
+		name        string
+		temperature float64
+		want        float64
+	}{
+		{name: "in range", temperature: 0.7, want: 0.7},
+		{name: "below range", temperature: -1, want: MinTemperature},
+		{name: "above range", temperature: 3.5, want: MaxTemperature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hf := NewHfApiModel("test-model", WithTemperature(tt.temperature))
+			if hf.Temperature == nil || *hf.Temperature != tt.want {
+				t.Errorf("Expected HfApiModel temperature %v, got %v", tt.want, hf.Temperature)
+			}
+
+			openai := NewOpenAIModel("gpt-4", WithApiKey("test-key"), WithTemperature(tt.temperature))
+			if openai.Temperature == nil || *openai.Temperature != tt.want {
+				t.Errorf("Expected OpenAIModel temperature %v, got %v", tt.want, openai.Temperature)
+			}
+		})
+	}
+
+	if model := NewHfApiModel("test-model"); model.Temperature != nil {
+		t.Errorf("Expected no temperature by default, got %v", *model.Temperature)
+	}
+}
+
+// TestWithTopP tests that top_p is sent only when configured
+func TestWithTopP(t *testing.T) {
+	var parameters map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Parameters map[string]any `json:"parameters"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		parameters = payload.Parameters
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+	if _, err := model.Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if _, ok := parameters["top_p"]; ok {
+		t.Errorf("Expected top_p to be absent when unset, got %v", parameters["top_p"])
+	}
+
+	model = NewHfApiModel("test-model", WithTopP(0.9))
+	model.ApiURL = server.URL
+	if _, err := model.Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if parameters["top_p"] != 0.9 {
+		t.Errorf("Expected top_p 0.9, got %v", parameters["top_p"])
+	}
+
+	if openai := NewOpenAIModel("gpt-4", WithApiKey("test-key"), WithTopP(0.9)); openai.TopP == nil || *openai.TopP != 0.9 {
+		t.Errorf("Expected OpenAIModel top_p 0.9, got %v", openai.TopP)
+	}
+}
+
+// TestWithStopSequences tests that stop is sent only when non-empty
+func TestWithStopSequences(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []Option
+		wantStop any
+	}{
+		{"unset", nil, nil},
+		{"empty", []Option{WithStopSequences()}, nil},
+		{"set", []Option{WithStopSequences("```", "Observation:")}, []any{"```", "Observation:"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parameters map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var payload struct {
+					Parameters map[string]any `json:"parameters"`
+				}
+				json.NewDecoder(r.Body).Decode(&payload)
+				parameters = payload.Parameters
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`[{"generated_text": "ok"}]`))
+			}))
+			defer server.Close()
+
+			model := NewHfApiModel("test-model", tt.options...)
+			model.ApiURL = server.URL
+			if _, err := model.Generate(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}}); err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			stop, ok := parameters["stop"]
+			if tt.wantStop == nil {
+				if ok {
+					t.Errorf("Expected stop to be absent, got %v", stop)
+				}
+				return
+			}
+			if !reflect.DeepEqual(stop, tt.wantStop) {
+				t.Errorf("Expected stop %v, got %v", tt.wantStop, stop)
+			}
+		})
+	}
+}
+
+// TestWithSeed tests that the seed is sent only when configured
+func TestWithSeed(t *testing.T) {
+	var parameters map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Parameters map[string]any `json:"parameters"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		parameters = payload.Parameters
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+	if _, err := model.Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if _, ok := parameters["seed"]; ok {
+		t.Errorf("Expected seed to be absent when unset, got %v", parameters["seed"])
+	}
+
+	model = NewHfApiModel("test-model", WithSeed(42))
+	model.ApiURL = server.URL
+	if _, err := model.Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if parameters["seed"] != float64(42) {
+		t.Errorf("Expected seed 42, got %v", parameters["seed"])
+	}
+}
+
+// TestHfApiModelGenerateDetailed tests that the HF model reports its content
+// without token counts
+func TestHfApiModelGenerateDetailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "Hello there"}]`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model")
+	model.ApiURL = server.URL
+
+	var _ DetailedModel = model
+	result, err := model.GenerateDetailed(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("GenerateDetailed() error = %v", err)
+	}
+	if *result != (GenerateResult{Content: "Hello there"}) {
+		t.Errorf("Expected content only, got %+v", *result)
+	}
+}
+
+// TestWithRateLimit tests that requests are spaced out by the rate limit and
+// that waiting stops when the context is cancelled
+func TestWithRateLimit(t *testing.T) {
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model", WithRateLimit(10))
+	model.ApiURL = server.URL
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+
+	for i := 0; i < 2; i++ {
+		if _, err := model.Generate(context.Background(), messages); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+	}
+	if gap := times[1].Sub(times[0]); gap < 80*time.Millisecond {
+		t.Errorf("Expected requests at least ~100ms apart, got %v", gap)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := model.Generate(ctx, messages); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled while waiting, got %v", err)
+	}
+	if len(times) != 2 {
+		t.Errorf("Expected the cancelled call not to reach the server, got %d requests", len(times))
+	}
+}
+
+// TestNormalizeMessages tests that invalid orderings are rewritten
+func TestNormalizeMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []Message
+		want     []Message
+	}{
+		{
+			name: "merges system messages and same-role turns",
+			messages: []Message{
+				{Role: RoleSystem, Content: "prompt"},
+				{Role: RoleSystem, Content: "tools"},
+				{Role: RoleUser, Content: "task"},
+				{Role: RoleUser, Content: "more"},
+				{Role: RoleAssistant, Content: "a"},
+				{Role: RoleAssistant, Content: "b"},
+				{Role: RoleTool, Name: "t", Content: "1"},
+				{Role: RoleTool, Name: "t", Content: "2"},
+			},
+			want: []Message{
+				{Role: RoleSystem, Content: "prompt\n\ntools"},
+				{Role: RoleUser, Content: "task\n\nmore"},
+				{Role: RoleAssistant, Content: "a\n\nb"},
+				{Role: RoleTool, Name: "t", Content: "1"},
+				{Role: RoleTool, Name: "t", Content: "2"},
+			},
+		},
+		{
+			name: "moves late system messages and starts with a user turn",
+			messages: []Message{
+				{Role: RoleAssistant, Content: "hello"},
+				{Role: RoleSystem, Content: "digest"},
+				{Role: RoleUser, Content: "task"},
+			},
+			want: []Message{
+				{Role: RoleSystem, Content: "digest"},
+				{Role: RoleUser, Content: "Continue."},
+				{Role: RoleAssistant, Content: "hello"},
+				{Role: RoleUser, Content: "task"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeMessages(tt.messages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NormalizeMessages() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWithMessageNormalization tests that normalization applies to requests
+// only when enabled
+func TestWithMessageNormalization(t *testing.T) {
+	var inputs []Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Inputs []Message `json:"inputs"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		inputs = payload.Inputs
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	messages := []Message{
+		{Role: RoleUser, Content: "a"},
+		{Role: RoleUser, Content: "b"},
+	}
+
+	for _, enabled := range []bool{false, true} {
+		model := NewHfApiModel("test-model", WithMessageNormalization(enabled))
+		model.ApiURL = server.URL
+		if _, err := model.Generate(context.Background(), messages); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		want := 2
+		if enabled {
+			want = 1
+		}
+		if len(inputs) != want {
+			t.Errorf("enabled=%v: expected %d messages sent, got %d", enabled, want, len(inputs))
+		}
+	}
+}
+
+// TestWithHeader tests that custom headers accumulate and reach the server
+func TestWithHeader(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "ok"}]`))
+	}))
+	defer server.Close()
+
+	model := NewHfApiModel("test-model",
+		WithHeader("X-Title", "my-app"),
+		WithHeader("X-Trace-Id", "old"),
+		WithHeader("X-Trace-Id", "trace-1"),
+	)
+	model.ApiURL = server.URL
+
+	if _, err := model.Generate(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}}); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if got := header.Get("X-Title"); got != "my-app" {
+		t.Errorf("Expected X-Title 'my-app', got %q", got)
+	}
+	if got := header.Get("X-Trace-Id"); got != "trace-1" {
+		t.Errorf("Expected X-Trace-Id 'trace-1', got %q", got)
+	}
+}
+
+// TestContextCancellation tests that a done context aborts in-flight and
+// pending model calls with the context's error
+func TestContextCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+	tools := []map[string]any{{"type": "function", "function": map[string]any{"name": "search"}}}
+
+	hf := NewHfApiModel("test-model")
+	hf.ApiURL = server.URL
+	chat := NewHfApiModel("test-model", WithChatURL(server.URL))
+	replay := &ReplayModel{responses: map[string][]string{}}
+
+	calls := map[string]func(ctx context.Context) error{
+		"hf generate": func(ctx context.Context) error {
+			_, err := hf.Generate(ctx, messages)
+			return err
+		},
+		"hf chat tools": func(ctx context.Context) error {
+			_, err := chat.GenerateWithTools(ctx, messages, tools)
+			return err
+		},
+		"replay": func(ctx context.Context) error {
+			_, err := replay.Generate(ctx, messages)
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		t.Run(name+" deadline", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			if name == "replay" {
+				<-ctx.Done()
+			}
+			start := time.Now()
+			if err := call(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("Expected the call to stop at the deadline, took %v", elapsed)
+			}
+		})
+
+		t.Run(name+" cancelled", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := call(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got %v", err)
+			}
+		})
+	}
 }
@@ -0,0 +1,64 @@
+package models
+
+import "strings"
+
+// WithMessageNormalization makes the model pass every request's messages
+// through NormalizeMessages, for providers that reject other orderings.
+func WithMessageNormalization(enabled bool) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.NormalizeMessages = enabled
+		case *OpenAIModel:
+			m.NormalizeMessages = enabled
+		case *CohereModel:
+			m.NormalizeMessages = enabled
+		}
+	}
+}
+
+// normalizationPlaceholder starts a conversation that would otherwise not
+// begin with a user message.
+const normalizationPlaceholder = "Continue."
+
+// NormalizeMessages rewrites messages into the ordering that strict
+// providers accept:
+//
+//   - all system messages are merged into one leading system message
+//   - the first message after it is a user message, inserting a placeholder
+//     if needed
+//   - consecutive user messages, and consecutive assistant messages, are
+//     merged into one
+//
+// Tool messages are kept as they are, since each answers a specific call.
+// The input slice is not modified.
+func NormalizeMessages(messages []Message) []Message {
+	var system []string
+	var rest []Message
+	for _, msg := range messages {
+		if msg.Role == RoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		rest = append(rest, msg)
+	}
+
+	normalized := make([]Message, 0, len(messages)+1)
+	if len(system) > 0 {
+		normalized = append(normalized, Message{Role: RoleSystem, Content: strings.Join(system, "\n\n")})
+	}
+	if len(rest) > 0 && rest[0].Role != RoleUser {
+		normalized = append(normalized, Message{Role: RoleUser, Content: normalizationPlaceholder})
+	}
+
+	for _, msg := range rest {
+		last := len(normalized) - 1
+		if last >= 0 && normalized[last].Role == msg.Role && (msg.Role == RoleUser || msg.Role == RoleAssistant) {
+			normalized[last].Content += "\n\n" + msg.Content
+			continue
+		}
+		normalized = append(normalized, msg)
+	}
+
+	return normalized
+}
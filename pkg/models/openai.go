@@ -6,23 +6,44 @@ import (
 	"errors"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"golang.org/x/time/rate"
 )
 
 const defaultTimeout = 60 * time.Second
 
 // OpenAIModel is a model that uses the OpenAI API.
 type OpenAIModel struct {
-	Model        string
-	ApiKey       string
-	MaxTokens    int
-	Organization string
-	Project      string
-	client       *openai.Client
-	httpClient   *http.Client // Store the HTTP client for use with the SDK
+	Model          string
+	ApiKey         string
+	MaxTokens      int
+	Temperature    *float64
+	TopP           *float64
+	Stop           []string
+	Seed           *int64
+	JSONMode       bool
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	Organization   string
+	Project        string
+	BaseURL        string
+	client         *openai.Client
+	limiter        *rate.Limiter
+	httpClient     *http.Client // Store the HTTP client for use with the SDK
+
+	// NormalizeMessages enables NormalizeMessages for every request.
+	NormalizeMessages bool
+
+	// ParallelToolCalls sets parallel_tool_calls on requests with tools. When
+	// nil, it is omitted and OpenAI's default applies.
+	ParallelToolCalls *bool
+
+	// Headers are added to every request.
+	Headers map[string]string
 }
 
 // NewOpenAIModel creates a new OpenAIModel.
@@ -59,11 +80,26 @@ func NewOpenAIModel(model string, options ...Option) *OpenAIModel {
 		clientOptions = append(clientOptions, option.WithHeader("OpenAI-Organization", m.Organization))
 	}
 
+	// Set custom headers if provided
+	for key, value := range m.Headers {
+		clientOptions = append(clientOptions, option.WithHeader(key, value))
+	}
+
 	// Set project if provided
 	if m.Project != "" {
 		clientOptions = append(clientOptions, option.WithHeader("OpenAI-Project", m.Project))
 	}
 
+	// Set base URL if provided, for OpenAI-compatible providers
+	if m.BaseURL != "" {
+		clientOptions = append(clientOptions, option.WithBaseURL(m.BaseURL))
+	}
+
+	// Retry in generateInternal instead of in the SDK, if retries are configured
+	if m.MaxRetries > 0 {
+		clientOptions = append(clientOptions, option.WithMaxRetries(0))
+	}
+
 	// Set HTTP client if provided
 	if m.httpClient != nil {
 		clientOptions = append(clientOptions, option.WithHTTPClient(m.httpClient))
@@ -76,18 +112,203 @@ func NewOpenAIModel(model string, options ...Option) *OpenAIModel {
 
 // Generate generates a response for the given messages.
 func (m *OpenAIModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	result, err := m.GenerateDetailed(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// GenerateDetailed generates a response for the given messages and returns
+// it with the token usage reported by the API.
+func (m *OpenAIModel) GenerateDetailed(ctx context.Context, messages []Message) (*GenerateResult, error) {
 	return m.generateInternal(ctx, messages, nil)
 }
 
 // GenerateWithTools generates a response for the given messages with tools.
 func (m *OpenAIModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
-	return m.generateInternal(ctx, messages, tools)
+	result, err := m.generateInternal(ctx, messages, tools)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
 }
 
-// generateInternal is the internal implementation of Generate and GenerateWithTools.
-func (m *OpenAIModel) generateInternal(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+// generateInternal is the internal implementation of GenerateDetailed and GenerateWithTools.
+func (m *OpenAIModel) generateInternal(ctx context.Context, messages []Message, tools []map[string]any) (*GenerateResult, error) {
 	if m.client == nil {
-		return "", errors.New("OpenAI client not initialized")
+		return nil, errors.New("OpenAI client not initialized")
+	}
+
+	params, requestOptions := m.buildRequest(ctx, messages, tools)
+
+	policy := retryPolicy{maxRetries: m.MaxRetries, baseDelay: m.RetryBaseDelay}
+	completion, err := withRetry(ctx, policy, func() (*openai.ChatCompletion, error) {
+		if err := waitForRateLimit(ctx, m.limiter); err != nil {
+			return nil, err
+		}
+		completion, err := m.client.Chat.Completions.New(ctx, params, requestOptions...)
+		return completion, apiError(err)
+	})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	// Handle the response
+	if len(completion.Choices) == 0 {
+		return nil, errors.New("no choices in response")
+	}
+
+	result := &GenerateResult{
+		PromptTokens:     int(completion.Usage.PromptTokens),
+		CompletionTokens: int(completion.Usage.CompletionTokens),
+		TotalTokens:      int(completion.Usage.TotalTokens),
+	}
+
+	choice := completion.Choices[0]
+
+	// Return the tool calls in the format the agents expect
+	if len(choice.Message.ToolCalls) > 0 {
+		calls := make([]nativeToolCall, 0, len(choice.Message.ToolCalls))
+		for _, toolCall := range choice.Message.ToolCalls {
+			calls = append(calls, nativeToolCall{
+				ID:        toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+
+		content, err := formatToolCalls(calls)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Content = content
+		return result, nil
+	}
+
+	result.Content = messageContent(choice.Message)
+	return result, nil
+}
+
+// GenerateStream generates a response for the given messages, streaming the
+// content deltas as they arrive.
+func (m *OpenAIModel) GenerateStream(ctx context.Context, messages []Message) (<-chan StreamChunk, error) {
+	return m.generateStream(ctx, messages, nil)
+}
+
+// GenerateStreamWithTools generates a response for the given messages with
+// tools, streaming the content deltas as they arrive. Tool calls are
+// accumulated and sent in the final chunk.
+func (m *OpenAIModel) GenerateStreamWithTools(ctx context.Context, messages []Message, tools []map[string]any) (<-chan StreamChunk, error) {
+	return m.generateStream(ctx, messages, tools)
+}
+
+// generateStream is the internal implementation of GenerateStream and
+// GenerateStreamWithTools.
+func (m *OpenAIModel) generateStream(ctx context.Context, messages []Message, tools []map[string]any) (<-chan StreamChunk, error) {
+	if m.client == nil {
+		return nil, errors.New("OpenAI client not initialized")
+	}
+
+	if err := waitForRateLimit(ctx, m.limiter); err != nil {
+		return nil, err
+	}
+
+	params, requestOptions := m.buildRequest(ctx, messages, tools)
+	stream := m.client.Chat.Completions.NewStreaming(ctx, params, requestOptions...)
+
+	chunks := make(chan StreamChunk)
+	go func() {
+		defer close(chunks)
+		defer stream.Close()
+
+		// Tool calls arrive in pieces, identified by their index
+		var calls []nativeToolCall
+		for stream.Next() {
+			chunk := stream.Current()
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+			delta := chunk.Choices[0].Delta
+			for _, toolCall := range delta.ToolCalls {
+				for int(toolCall.Index) >= len(calls) {
+					calls = append(calls, nativeToolCall{})
+				}
+				call := &calls[toolCall.Index]
+				if toolCall.ID != "" {
+					call.ID = toolCall.ID
+				}
+				call.Name += toolCall.Function.Name
+				call.Arguments += toolCall.Function.Arguments
+			}
+			if delta.Content == "" {
+				continue
+			}
+			if !sendChunk(ctx, chunks, StreamChunk{Delta: delta.Content}) {
+				return
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			if ctx.Err() == nil {
+				sendChunk(ctx, chunks, StreamChunk{Err: classifyError(err)})
+			}
+			return
+		}
+
+		if len(calls) > 0 {
+			content, err := formatToolCalls(calls)
+			if err != nil {
+				sendChunk(ctx, chunks, StreamChunk{Err: err})
+				return
+			}
+			sendChunk(ctx, chunks, StreamChunk{ToolCalls: content})
+		}
+	}()
+
+	return chunks, nil
+}
+
+// openAIAssistantMessage converts an assistant message to OpenAI's format.
+// Tool calls in the format the agents expect are sent as native tool calls,
+// so the tool messages answering them refer to calls the API has seen.
+func openAIAssistantMessage(content string) openai.ChatCompletionMessageParamUnion {
+	calls := parseAgentToolCalls(content)
+	if calls == nil {
+		return openai.AssistantMessage(content)
+	}
+
+	toolCalls := make([]openai.ChatCompletionMessageToolCallParam, 0, len(calls))
+	for _, call := range calls {
+		arguments := string(call.Args)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		toolCalls = append(toolCalls, openai.ChatCompletionMessageToolCallParam{
+			ID:   openai.F(call.ID),
+			Type: openai.F(openai.ChatCompletionMessageToolCallTypeFunction),
+			Function: openai.F(openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      openai.F(call.Tool),
+				Arguments: openai.F(arguments),
+			}),
+		})
+	}
+
+	return openai.ChatCompletionAssistantMessageParam{
+		Role:      openai.F(openai.ChatCompletionAssistantMessageParamRoleAssistant),
+		ToolCalls: openai.F(toolCalls),
+	}
+}
+
+// buildRequest builds the completion parameters and per-request options.
+func (m *OpenAIModel) buildRequest(
+	ctx context.Context,
+	messages []Message,
+	tools []map[string]any,
+) (openai.ChatCompletionNewParams, []option.RequestOption) {
+	if m.NormalizeMessages {
+		messages = NormalizeMessages(messages)
 	}
 
 	// Convert our Message type to OpenAI's ChatCompletionMessageParamUnion
@@ -99,17 +320,41 @@ func (m *OpenAIModel) generateInternal(ctx context.Context, messages []Message,
 		case RoleUser:
 			chatMessages = append(chatMessages, openai.UserMessage(msg.Content))
 		case RoleAssistant:
-			chatMessages = append(chatMessages, openai.AssistantMessage(msg.Content))
+			chatMessages = append(chatMessages, openAIAssistantMessage(msg.Content))
 		case RoleTool:
-			chatMessages = append(chatMessages, openai.ToolMessage(msg.Name, msg.Content))
+			toolCallID := msg.ToolCallID
+			if toolCallID == "" {
+				toolCallID = msg.Name
+			}
+			chatMessages = append(chatMessages, openai.ToolMessage(toolCallID, msg.Content))
 		}
 	}
 
+	// Resolve the generation settings for this request
+	genParams := resolveGenerationParams(ctx, GenerationParams{
+		MaxTokens:   m.MaxTokens,
+		Temperature: m.Temperature,
+		TopP:        m.TopP,
+		Stop:        m.Stop,
+	})
+
 	// Prepare the completion parameters
 	params := openai.ChatCompletionNewParams{
 		Messages:  openai.F(chatMessages),
 		Model:     openai.F(m.Model),
-		MaxTokens: openai.F(int64(m.MaxTokens)),
+		MaxTokens: openai.F(int64(genParams.MaxTokens)),
+	}
+	if genParams.Temperature != nil {
+		params.Temperature = openai.F(*genParams.Temperature)
+	}
+	if genParams.TopP != nil {
+		params.TopP = openai.F(*genParams.TopP)
+	}
+	if m.Seed != nil {
+		params.Seed = openai.F(*m.Seed)
+	}
+	if len(genParams.Stop) > 0 {
+		params.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(genParams.Stop))
 	}
 
 	// Add tools if provided
@@ -148,53 +393,60 @@ func (m *OpenAIModel) generateInternal(ctx context.Context, messages []Message,
 			})
 		}
 		params.Tools = openai.F(toolsParam)
+		if m.ParallelToolCalls != nil {
+			params.ParallelToolCalls = openai.F(*m.ParallelToolCalls)
+		}
 	}
 
-	// Make the API call with appropriate options
-	var completion *openai.ChatCompletion
-	var err error
+	// Collect per-request options
+	var requestOptions []option.RequestOption
 
 	if len(tools) > 0 {
 		// Only set tool_choice when tools are provided
-		completion, err = m.client.Chat.Completions.New(
-			ctx,
-			params,
-			option.WithJSONSet("tool_choice", "auto"),
-		)
-	} else {
-		completion, err = m.client.Chat.Completions.New(ctx, params)
+		requestOptions = append(requestOptions, option.WithJSONSet("tool_choice", "auto"))
 	}
 
-	if err != nil {
-		return "", err
+	if m.JSONMode {
+		requestOptions = append(requestOptions, option.WithJSONSet("response_format", map[string]string{"type": "json_object"}))
 	}
 
-	// Handle the response
-	if len(completion.Choices) == 0 {
-		return "", errors.New("no choices in response")
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		requestOptions = append(requestOptions, option.WithHeader(RequestIDHeader, requestID))
 	}
 
-	choice := completion.Choices[0]
+	return params, requestOptions
+}
 
-	// Check if there's a tool call
-	if len(choice.Message.ToolCalls) > 0 {
-		toolCall := choice.Message.ToolCalls[0]
+// contentPart is an element of an array-valued message content.
+type contentPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
 
-		// Create a properly formatted tool call response
-		toolResponse := map[string]any{
-			"tool": toolCall.Function.Name,
-			"args": json.RawMessage(toolCall.Function.Arguments),
-		}
+// messageContent returns the text of a response message. Some
+// OpenAI-compatible providers return the content as an array of parts rather
+// than a string; their text parts are concatenated in order. The SDK then
+// fills Content with the raw JSON of the array, so the raw content is checked
+// first.
+func messageContent(msg openai.ChatCompletionMessage) string {
+	raw := strings.TrimSpace(msg.JSON.Content.Raw())
+	if !strings.HasPrefix(raw, "[") {
+		return msg.Content
+	}
 
-		toolResponseJSON, err := json.Marshal(toolResponse)
-		if err != nil {
-			return "", err
-		}
+	var parts []contentPart
+	if err := json.Unmarshal([]byte(raw), &parts); err != nil {
+		return msg.Content
+	}
 
-		return string(toolResponseJSON), nil
+	var builder strings.Builder
+	for _, part := range parts {
+		if part.Type == "" || part.Type == "text" || part.Type == "output_text" {
+			builder.WriteString(part.Text)
+		}
 	}
 
-	return choice.Message.Content, nil
+	return builder.String()
 }
 
 // WithOrganization sets the organization for OpenAI API requests.
@@ -207,6 +459,20 @@ func WithOrganization(org string) Option {
 	}
 }
 
+// WithBaseURL sets the base URL for OpenAI API requests, to use an
+// OpenAI-compatible provider.
+func WithBaseURL(baseURL string) Option {
+	return func(model any) {
+		switch m := model.(type) {
+		case *OpenAIModel:
+			if !strings.HasSuffix(baseURL, "/") {
+				baseURL += "/"
+			}
+			m.BaseURL = baseURL
+		}
+	}
+}
+
 // WithProject sets the project for OpenAI API requests.
 func WithProject(project string) Option {
 	return func(model any) {
@@ -216,3 +482,18 @@ func WithProject(project string) Option {
 		}
 	}
 }
+
+// apiError converts an SDK error response into an APIError so that it can be
+// classified for retries. Other errors are returned unchanged.
+func apiError(err error) error {
+	var sdkErr *openai.Error
+	if !errors.As(err, &sdkErr) {
+		return err
+	}
+
+	apiErr := &APIError{StatusCode: sdkErr.StatusCode, Err: err}
+	if sdkErr.Response != nil {
+		apiErr.RetryAfter, _ = retryAfter(sdkErr.Response)
+	}
+	return apiErr
+}
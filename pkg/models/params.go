@@ -0,0 +1,78 @@
+package models
+
+import "context"
+
+// GenerationParams holds generation settings for a model request. Unset
+// fields (nil pointers, zero MaxTokens, empty Stop) leave the setting to the
+// next level of precedence.
+//
+// Settings are resolved with the following precedence, highest first:
+//
+//  1. per-call, set with ContextWithGenerationParams
+//  2. agent-level, set with the agent's WithGenerationParams option
+//  3. model-level, set with model options such as WithMaxTokens
+//  4. the provider's default, when the setting is left unset everywhere
+//
+// Every model resolves its settings in a single place, right before it
+// builds a request.
+type GenerationParams struct {
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+	Stop        []string
+}
+
+// Merge returns p with every setting that is set in override replaced by the
+// override's value.
+func (p GenerationParams) Merge(override GenerationParams) GenerationParams {
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens != 0 {
+		p.MaxTokens = override.MaxTokens
+	}
+	if len(override.Stop) > 0 {
+		p.Stop = override.Stop
+	}
+	return p
+}
+
+// Float returns a pointer to v, for setting optional float parameters.
+func Float(v float64) *float64 {
+	return &v
+}
+
+// generationParamsKey is the context key for generation parameters.
+type generationParamsKey struct{}
+
+// ContextWithGenerationParams returns a copy of ctx carrying per-call
+// generation parameters. They take precedence over parameters already
+// carried by ctx and over agent- and model-level settings.
+func ContextWithGenerationParams(ctx context.Context, params GenerationParams) context.Context {
+	merged := GenerationParamsFromContext(ctx).Merge(params)
+	return context.WithValue(ctx, generationParamsKey{}, merged)
+}
+
+// ContextWithDefaultGenerationParams returns a copy of ctx carrying params
+// beneath any parameters already carried by ctx. Agents use it to apply their
+// settings without overriding per-call ones.
+func ContextWithDefaultGenerationParams(ctx context.Context, params GenerationParams) context.Context {
+	merged := params.Merge(GenerationParamsFromContext(ctx))
+	return context.WithValue(ctx, generationParamsKey{}, merged)
+}
+
+// GenerationParamsFromContext returns the generation parameters carried by
+// ctx, if any.
+func GenerationParamsFromContext(ctx context.Context) GenerationParams {
+	params, _ := ctx.Value(generationParamsKey{}).(GenerationParams)
+	return params
+}
+
+// resolveGenerationParams is the single merge point of generation settings:
+// the model-level settings overridden by those carried by ctx.
+func resolveGenerationParams(ctx context.Context, modelLevel GenerationParams) GenerationParams {
+	return modelLevel.Merge(GenerationParamsFromContext(ctx))
+}
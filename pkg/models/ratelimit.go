@@ -0,0 +1,34 @@
+package models
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit limits the model to requestsPerSecond requests, using a token
+// bucket that allows no bursts. Requests wait for their turn, or fail with the
+// context's error if it is cancelled while waiting. Share one model between
+// agents to apply a common limit.
+func WithRateLimit(requestsPerSecond float64) Option {
+	return func(model any) {
+		limiter := rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+		switch m := model.(type) {
+		case *HfApiModel:
+			m.limiter = limiter
+		case *OpenAIModel:
+			m.limiter = limiter
+		case *CohereModel:
+			m.limiter = limiter
+		}
+	}
+}
+
+// waitForRateLimit blocks until limiter permits a request. A nil limiter
+// permits every request immediately.
+func waitForRateLimit(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrNoRecordedResponse is returned by ReplayModel when the cassette holds no
+// (remaining) response for a request.
+var ErrNoRecordedResponse = errors.New("no recorded response for request")
+
+// Exchange is a single recorded model request and its response.
+type Exchange struct {
+	Key      string           `json:"key"`
+	Messages []Message        `json:"messages"`
+	Tools    []map[string]any `json:"tools,omitempty"`
+	Response string           `json:"response"`
+}
+
+// Cassette is the on-disk format of recorded exchanges.
+type Cassette struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// requestKey returns a stable hash identifying a request.
+func requestKey(messages []Message, tools []map[string]any) (string, error) {
+	data, err := json.Marshal(struct {
+		Messages []Message        `json:"messages"`
+		Tools    []map[string]any `json:"tools,omitempty"`
+	}{messages, tools})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RecordingModel wraps a Model and records every successful exchange to a
+// cassette file, which can later be replayed with ReplayModel. The file is
+// rewritten after each call so it is complete even if the process stops.
+type RecordingModel struct {
+	model    Model
+	path     string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingModel creates a RecordingModel that writes to the file at path.
+func NewRecordingModel(model Model, path string) *RecordingModel {
+	return &RecordingModel{
+		model: model,
+		path:  path,
+	}
+}
+
+// Generate generates a response with the wrapped model and records it.
+func (m *RecordingModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	response, err := m.model.Generate(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+
+	return response, m.record(messages, nil, response)
+}
+
+// GenerateWithTools generates a response with the wrapped model and records it.
+func (m *RecordingModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	response, err := m.model.GenerateWithTools(ctx, messages, tools)
+	if err != nil {
+		return "", err
+	}
+
+	return response, m.record(messages, tools, response)
+}
+
+// record appends an exchange to the cassette and saves it.
+func (m *RecordingModel) record(messages []Message, tools []map[string]any, response string) error {
+	key, err := requestKey(messages, tools)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cassette.Exchanges = append(m.cassette.Exchanges, Exchange{
+		Key:      key,
+		Messages: messages,
+		Tools:    tools,
+		Response: response,
+	})
+
+	data, err := json.MarshalIndent(m.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.WriteFile(m.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return nil
+}
+
+// ReplayModel replays responses recorded by RecordingModel. Requests are
+// matched by a hash of their messages and tools; identical requests are
+// answered in the order they were recorded.
+type ReplayModel struct {
+	mu        sync.Mutex
+	responses map[string][]string
+}
+
+// NewReplayModel creates a ReplayModel from the cassette file at path.
+func NewReplayModel(path string) (*ReplayModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette: %w", err)
+	}
+
+	m := &ReplayModel{
+		responses: make(map[string][]string),
+	}
+	for _, exchange := range cassette.Exchanges {
+		m.responses[exchange.Key] = append(m.responses[exchange.Key], exchange.Response)
+	}
+
+	return m, nil
+}
+
+// Generate returns the recorded response for the messages.
+func (m *ReplayModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return m.replay(messages, nil)
+}
+
+// GenerateWithTools returns the recorded response for the messages and tools.
+func (m *ReplayModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return m.replay(messages, tools)
+}
+
+// replay pops the next recorded response for the request.
+func (m *ReplayModel) replay(messages []Message, tools []map[string]any) (string, error) {
+	key, err := requestKey(messages, tools)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	responses := m.responses[key]
+	if len(responses) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNoRecordedResponse, key)
+	}
+
+	m.responses[key] = responses[1:]
+	return responses[0], nil
+}
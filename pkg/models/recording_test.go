@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// cannedModel returns the same response to every request
+type cannedModel struct {
+	response string
+}
+
+func (m *cannedModel) Generate(ctx context.Context, messages []Message) (string, error) {
+	return m.response, nil
+}
+
+func (m *cannedModel) GenerateWithTools(ctx context.Context, messages []Message, tools []map[string]any) (string, error) {
+	return m.response, nil
+}
+
+// TestRecordAndReplay tests recording exchanges and replaying them from a cassette
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+	tools := []map[string]any{{"type": "function"}}
+
+	recorder := NewRecordingModel(&cannedModel{response: "recorded"}, path)
+	if _, err := recorder.Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := recorder.GenerateWithTools(context.Background(), messages, tools); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	replay, err := NewReplayModel(path)
+	if err != nil {
+		t.Fatalf("Failed to load cassette: %v", err)
+	}
+
+	response, err := replay.GenerateWithTools(context.Background(), messages, tools)
+	if err != nil || response != "recorded" {
+		t.Errorf("Expected 'recorded', got '%s' (err %v)", response, err)
+	}
+
+	response, err = replay.Generate(context.Background(), messages)
+	if err != nil || response != "recorded" {
+		t.Errorf("Expected 'recorded', got '%s' (err %v)", response, err)
+	}
+
+	// The recorded response has been consumed
+	if _, err := replay.Generate(context.Background(), messages); !errors.Is(err, ErrNoRecordedResponse) {
+		t.Errorf("Expected ErrNoRecordedResponse, got %v", err)
+	}
+
+	// Unknown requests are not answered
+	other := []Message{{Role: RoleUser, Content: "Goodbye"}}
+	if _, err := replay.Generate(context.Background(), other); !errors.Is(err, ErrNoRecordedResponse) {
+		t.Errorf("Expected ErrNoRecordedResponse, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrEmptyResponse is returned when the API responds without any generations.
+// Serverless endpoints return this while a cold model is loading, so it is
+// classified as retryable.
+var ErrEmptyResponse = errors.New("empty response from model")
+
+// APIError is returned when the API responds with an unsuccessful status.
+type APIError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay requested by the response's Retry-After
+	// header, or zero if it had none.
+	RetryAfter time.Duration
+	// Err is the provider SDK's error, if the request went through one.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Unwrap returns the provider SDK's error.
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// retryableStatusCodes are the statuses of transient API failures.
+var retryableStatusCodes = map[int]bool{
+	429: true,
+	500: true,
+	502: true,
+	503: true,
+	504: true,
+}
+
+// retryPolicy controls how a failed request is retried.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+}
+
+// backoff returns the delay before the given retry attempt (zero-based).
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	return p.baseDelay << attempt
+}
+
+// isRetryable reports whether err is a transient failure worth retrying: an
+// empty response, a retryable status code or a network error.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrEmptyResponse) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatusCodes[apiErr.StatusCode]
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// withRetry calls fn until it succeeds, fails with a non-retryable error, or
+// the policy's retries are exhausted. A Retry-After delay sent by the server
+// takes precedence over the policy's backoff. It stops early if ctx is
+// cancelled while waiting between attempts.
+func withRetry[T any](ctx context.Context, policy retryPolicy, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := fn()
+		if err == nil || attempt >= policy.maxRetries || !isRetryable(err) {
+			return result, err
+		}
+
+		delay := policy.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			var zero T
+			return zero, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"strings"
+)
+
+// StreamChunk is a piece of a streamed response. The final chunk of a failed
+// stream carries the error in Err. When the model calls tools, the final
+// chunk carries the calls in ToolCalls, in the format GenerateWithTools
+// returns them, and they replace any content as the response.
+type StreamChunk struct {
+	Delta     string
+	ToolCalls string
+	Err       error
+}
+
+// StreamingModel is a Model that can deliver a response incrementally.
+// It is kept separate from Model so existing implementations need not
+// support streaming.
+type StreamingModel interface {
+	Model
+
+	// GenerateStream generates a response for the given messages and
+	// returns a channel of content deltas. The channel is closed when the
+	// response is complete, after a chunk carrying an error, or when ctx
+	// is cancelled.
+	GenerateStream(ctx context.Context, messages []Message) (<-chan StreamChunk, error)
+}
+
+// ToolStreamingModel is a StreamingModel that can also stream responses to
+// requests offering tools.
+type ToolStreamingModel interface {
+	StreamingModel
+
+	// GenerateStreamWithTools is GenerateStream with tools offered to the
+	// model, as in GenerateWithTools.
+	GenerateStreamWithTools(ctx context.Context, messages []Message, tools []map[string]any) (<-chan StreamChunk, error)
+}
+
+// CollectStream reads chunks until the channel is closed, calling onDelta
+// with each delta if it is non-nil, and returns the full response, or the
+// tool calls if the stream ends with them. If the stream fails or ctx is
+// cancelled, the content received so far is returned along with the error.
+func CollectStream(ctx context.Context, chunks <-chan StreamChunk, onDelta func(delta string)) (string, error) {
+	var builder strings.Builder
+	var toolCalls string
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			return builder.String(), chunk.Err
+		}
+		if chunk.ToolCalls != "" {
+			toolCalls = chunk.ToolCalls
+			continue
+		}
+		builder.WriteString(chunk.Delta)
+		if onDelta != nil {
+			onDelta(chunk.Delta)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return builder.String(), err
+	}
+
+	if toolCalls != "" {
+		return toolCalls, nil
+	}
+	return builder.String(), nil
+}
+
+// sendChunk sends chunk on chunks unless ctx is cancelled first. It reports
+// whether the chunk was sent.
+func sendChunk(ctx context.Context, chunks chan<- StreamChunk, chunk StreamChunk) bool {
+	select {
+	case chunks <- chunk:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -3,9 +3,11 @@ package tests
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/epuerta9/smolagents-go/pkg/models"
 )
@@ -25,6 +27,41 @@ func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	return http.DefaultTransport.RoundTrip(req)
 }
 
+// writeChatCompletion writes a minimal chat completion response with the given content.
+func writeChatCompletion(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      "chatcmpl-123",
+		"object":  "chat.completion",
+		"created": 1677858242,
+		"model":   "gpt-4",
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": content,
+				},
+				"finish_reason": "stop",
+			},
+		},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     12,
+			"completion_tokens": 5,
+			"total_tokens":      17,
+		},
+	})
+}
+
+// newTestOpenAIModel creates an OpenAIModel whose requests are redirected to server.
+func newTestOpenAIModel(server *httptest.Server, options ...models.Option) *models.OpenAIModel {
+	options = append([]models.Option{
+		models.WithApiKey("test-key"),
+		models.WithHttpClient(&http.Client{Transport: &testTransport{server: server}}),
+	}, options...)
+	return models.NewOpenAIModel("gpt-4", options...)
+}
+
 func TestOpenAIModelOptions(t *testing.T) {
 	model := models.NewOpenAIModel(
 		"gpt-4",
@@ -254,3 +291,532 @@ func TestOpenAIModelGenerateWithTools(t *testing.T) {
 		t.Errorf("Expected arg1 to be 'value1', got '%v'", args["arg1"])
 	}
 }
+
+// TestOpenAIModelMultipleToolCalls tests that every tool call of a response
+// is returned, as a JSON array
+func TestOpenAIModelMultipleToolCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-123",
+			"object":  "chat.completion",
+			"created": 1677858242,
+			"model":   "gpt-4",
+			"choices": []map[string]interface{}{
+				{
+					"index": 0,
+					"message": map[string]interface{}{
+						"role":    "assistant",
+						"content": "",
+						"tool_calls": []map[string]interface{}{
+							{
+								"id":       "call_1",
+								"type":     "function",
+								"function": map[string]interface{}{"name": "weather", "arguments": `{"city":"Paris"}`},
+							},
+							{
+								"id":       "call_2",
+								"type":     "function",
+								"function": map[string]interface{}{"name": "time", "arguments": `{"city":"Paris"}`},
+							},
+						},
+					},
+					"finish_reason": "tool_calls",
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	model := newTestOpenAIModel(server)
+	response, err := model.GenerateWithTools(context.Background(), []models.Message{
+		{Role: models.RoleUser, Content: "Weather and time in Paris?"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("GenerateWithTools() error = %v", err)
+	}
+
+	var calls []struct {
+		ID   string         `json:"id"`
+		Tool string         `json:"tool"`
+		Args map[string]any `json:"args"`
+	}
+	if err := json.Unmarshal([]byte(response), &calls); err != nil {
+		t.Fatalf("Expected a JSON array of tool calls, got %q", response)
+	}
+	if len(calls) != 2 || calls[0].ID != "call_1" || calls[0].Tool != "weather" || calls[1].ID != "call_2" || calls[1].Tool != "time" {
+		t.Errorf("Unexpected tool calls: %+v", calls)
+	}
+}
+
+func TestOpenAIModelToolCallHistory(t *testing.T) {
+	var body struct {
+		Messages []struct {
+			Role       string `json:"role"`
+			ToolCallID string `json:"tool_call_id"`
+			ToolCalls  []struct {
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"messages"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		writeChatCompletion(w, "It is sunny in Paris.")
+	}))
+	defer server.Close()
+
+	// A history continuing after the model called a tool, as the agents record it
+	messages := []models.Message{
+		{Role: models.RoleUser, Content: "Weather in Paris?"},
+		{Role: models.RoleAssistant, Content: `{"id":"call_1","tool":"weather","args":{"city":"Paris"}}`},
+		{Role: models.RoleTool, Name: "weather", ToolCallID: "call_1", Content: "sunny"},
+	}
+	if _, err := newTestOpenAIModel(server).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if len(body.Messages) != 3 {
+		t.Fatalf("Expected 3 messages in the request, got %d", len(body.Messages))
+	}
+	assistant := body.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.ToolCalls) != 1 {
+		t.Fatalf("Expected an assistant message with one tool call, got %+v", assistant)
+	}
+	call := assistant.ToolCalls[0]
+	if call.ID != "call_1" || call.Type != "function" || call.Function.Name != "weather" || call.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("Unexpected tool call in request: %+v", call)
+	}
+	if tool := body.Messages[2]; tool.Role != "tool" || tool.ToolCallID != call.ID {
+		t.Errorf("Expected the tool message to answer call %s, got %+v", call.ID, tool)
+	}
+}
+
+func TestOpenAIModelRequestID(t *testing.T) {
+	var gotRequestID string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestID = r.Header.Get("X-Request-ID")
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	model := newTestOpenAIModel(server)
+
+	messages := []models.Message{
+		{Role: models.RoleUser, Content: "Hello"},
+	}
+
+	ctx := models.ContextWithRequestID(context.Background(), "req-123")
+	if _, err := model.Generate(ctx, messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if gotRequestID != "req-123" {
+		t.Errorf("Expected X-Request-ID header 'req-123', got '%s'", gotRequestID)
+	}
+}
+
+func TestOpenAIModelContentParts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "chatcmpl-123",
+			"object":  "chat.completion",
+			"created": 1677858242,
+			"model":   "compatible-model",
+			"choices": []map[string]interface{}{
+				{
+					"index": 0,
+					"message": map[string]interface{}{
+						"role": "assistant",
+						"content": []map[string]interface{}{
+							{"type": "text", "text": "Hello, "},
+							{"type": "image_url", "image_url": map[string]string{"url": "https://example.com/a.png"}},
+							{"type": "text", "text": "world!"},
+						},
+					},
+					"finish_reason": "stop",
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	model := models.NewOpenAIModel("compatible-model",
+		models.WithApiKey("test-key"),
+		models.WithBaseURL(server.URL),
+	)
+
+	messages := []models.Message{
+		{Role: models.RoleUser, Content: "Hello"},
+	}
+
+	response, err := model.Generate(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if response != "Hello, world!" {
+		t.Errorf("Expected concatenated text parts 'Hello, world!', got '%s'", response)
+	}
+}
+
+func TestOpenAIModelGenerateStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"Hello", ", ", "world"} {
+			chunk, _ := json.Marshal(map[string]interface{}{
+				"id":      "chatcmpl-123",
+				"object":  "chat.completion.chunk",
+				"created": 1677858242,
+				"model":   "gpt-4",
+				"choices": []map[string]interface{}{
+					{"index": 0, "delta": map[string]interface{}{"content": delta}},
+				},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	model := newTestOpenAIModel(server)
+
+	chunks, err := model.GenerateStream(context.Background(), []models.Message{
+		{Role: models.RoleUser, Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	response, err := models.CollectStream(context.Background(), chunks, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if response != "Hello, world" {
+		t.Errorf("Expected 'Hello, world', got '%s'", response)
+	}
+}
+
+func TestOpenAIModelGenerateStreamWithTools(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		deltas := []map[string]interface{}{
+			{"content": "Let me check."},
+			{"tool_calls": []map[string]interface{}{{
+				"index": 0, "id": "call_1", "type": "function",
+				"function": map[string]interface{}{"name": "get_weather", "arguments": ""},
+			}}},
+			{"tool_calls": []map[string]interface{}{{"index": 0, "function": map[string]interface{}{"arguments": `{"city":`}}}},
+			{"tool_calls": []map[string]interface{}{{"index": 0, "function": map[string]interface{}{"arguments": `"Paris"}`}}}},
+		}
+		for _, delta := range deltas {
+			chunk, _ := json.Marshal(map[string]interface{}{
+				"id":      "chatcmpl-123",
+				"object":  "chat.completion.chunk",
+				"created": 1677858242,
+				"model":   "gpt-4",
+				"choices": []map[string]interface{}{{"index": 0, "delta": delta}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	model := newTestOpenAIModel(server)
+	tools := []map[string]any{{
+		"type": "function",
+		"function": map[string]any{
+			"name":        "get_weather",
+			"description": "Gets the weather in a city",
+			"parameters":  map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+		},
+	}}
+
+	chunks, err := model.GenerateStreamWithTools(context.Background(), []models.Message{
+		{Role: models.RoleUser, Content: "Weather in Paris?"},
+	}, tools)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var streamed string
+	response, err := models.CollectStream(context.Background(), chunks, func(delta string) { streamed += delta })
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if sent, ok := body["tools"].([]interface{}); !ok || len(sent) != 1 {
+		t.Errorf("Expected the tool in the request, got %v", body["tools"])
+	}
+	if streamed != "Let me check." {
+		t.Errorf("Expected the content to be streamed, got '%s'", streamed)
+	}
+	if response != `{"id":"call_1","tool":"get_weather","args":{"city":"Paris"}}` {
+		t.Errorf("Expected the assembled tool call as the response, got '%s'", response)
+	}
+}
+
+func TestOpenAIModelTopPOmittedWhenUnset(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "Hello"}}
+
+	if _, err := newTestOpenAIModel(server).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["top_p"]; ok {
+		t.Errorf("Expected top_p to be absent when unset, got %v", body["top_p"])
+	}
+
+	if _, err := newTestOpenAIModel(server, models.WithTopP(0.9)).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if body["top_p"] != 0.9 {
+		t.Errorf("Expected top_p 0.9, got %v", body["top_p"])
+	}
+}
+
+func TestOpenAIModelStopSequences(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "Hello"}}
+
+	if _, err := newTestOpenAIModel(server, models.WithStopSequences()).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["stop"]; ok {
+		t.Errorf("Expected stop to be absent for an empty slice, got %v", body["stop"])
+	}
+
+	if _, err := newTestOpenAIModel(server, models.WithStopSequences("```")).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stop, _ := body["stop"].([]interface{})
+	if len(stop) != 1 || stop[0] != "```" {
+		t.Errorf("Expected stop [```], got %v", body["stop"])
+	}
+}
+
+func TestOpenAIModelSeed(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "Hello"}}
+
+	if _, err := newTestOpenAIModel(server).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["seed"]; ok {
+		t.Errorf("Expected seed to be absent when unset, got %v", body["seed"])
+	}
+
+	if _, err := newTestOpenAIModel(server, models.WithSeed(42)).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if body["seed"] != float64(42) {
+		t.Errorf("Expected seed 42, got %v", body["seed"])
+	}
+}
+
+func TestOpenAIModelParallelToolCalls(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "Hello"}}
+	tools := []map[string]any{
+		{
+			"name":        "test_tool",
+			"description": "A test tool",
+			"parameters":  map[string]any{"type": "object", "properties": map[string]any{}},
+		},
+	}
+
+	if _, err := newTestOpenAIModel(server).GenerateWithTools(context.Background(), messages, tools); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["parallel_tool_calls"]; ok {
+		t.Errorf("Expected parallel_tool_calls to be absent when unset, got %v", body["parallel_tool_calls"])
+	}
+
+	for _, enabled := range []bool{false, true} {
+		model := newTestOpenAIModel(server, models.WithParallelToolCalls(enabled))
+		if _, err := model.GenerateWithTools(context.Background(), messages, tools); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if body["parallel_tool_calls"] != enabled {
+			t.Errorf("Expected parallel_tool_calls %v, got %v", enabled, body["parallel_tool_calls"])
+		}
+
+		// Without tools the parameter is rejected by OpenAI, so it is omitted
+		if _, err := model.Generate(context.Background(), messages); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, ok := body["parallel_tool_calls"]; ok {
+			t.Errorf("Expected parallel_tool_calls to be absent without tools, got %v", body["parallel_tool_calls"])
+		}
+	}
+}
+
+func TestOpenAIModelJSONMode(t *testing.T) {
+	var body map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		writeChatCompletion(w, `{"answer": 42}`)
+	}))
+	defer server.Close()
+
+	messages := []models.Message{
+		{Role: models.RoleSystem, Content: "Reply in JSON."},
+		{Role: models.RoleUser, Content: "Hello"},
+	}
+
+	if _, err := newTestOpenAIModel(server).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := body["response_format"]; ok {
+		t.Errorf("Expected response_format to be absent by default, got %v", body["response_format"])
+	}
+
+	if _, err := newTestOpenAIModel(server, models.WithJSONMode()).Generate(context.Background(), messages); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	format, _ := body["response_format"].(map[string]interface{})
+	if format["type"] != "json_object" {
+		t.Errorf("Expected response_format json_object, got %v", body["response_format"])
+	}
+}
+
+func TestOpenAIModelGenerateDetailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeChatCompletion(w, "Hello there")
+	}))
+	defer server.Close()
+
+	messages := []models.Message{{Role: models.RoleUser, Content: "Hello"}}
+
+	result, err := newTestOpenAIModel(server).GenerateDetailed(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	want := models.GenerateResult{Content: "Hello there", PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17}
+	if *result != want {
+		t.Errorf("Expected %+v, got %+v", want, *result)
+	}
+}
+
+func TestOpenAIModelRetry(t *testing.T) {
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": {"message": "overloaded"}}`))
+			return
+		}
+		writeChatCompletion(w, "recovered")
+	}))
+	defer server.Close()
+
+	model := newTestOpenAIModel(server, models.WithRetry(2, time.Millisecond))
+
+	response, err := model.Generate(context.Background(), []models.Message{{Role: models.RoleUser, Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if response != "recovered" {
+		t.Errorf("Expected 'recovered', got '%s'", response)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+}
+
+func TestOpenAIModelWithBaseURL(t *testing.T) {
+	var path string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	// The base URL may be given with or without a trailing slash
+	for _, baseURL := range []string{server.URL + "/api/v1", server.URL + "/api/v1/"} {
+		model := models.NewOpenAIModel("compatible-model",
+			models.WithApiKey("test-key"),
+			models.WithBaseURL(baseURL),
+		)
+
+		if _, err := model.Generate(context.Background(), []models.Message{{Role: models.RoleUser, Content: "Hello"}}); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", baseURL, err)
+		}
+		if path != "/api/v1/chat/completions" {
+			t.Errorf("Expected request to /api/v1/chat/completions for %s, got %s", baseURL, path)
+		}
+	}
+}
+
+func TestOpenAIModelWithHeader(t *testing.T) {
+	var header http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		writeChatCompletion(w, "ok")
+	}))
+	defer server.Close()
+
+	model := newTestOpenAIModel(server,
+		models.WithHeader("X-Title", "my-app"),
+		models.WithHeader("HTTP-Referer", "https://example.com"),
+	)
+
+	if _, err := model.Generate(context.Background(), []models.Message{{Role: models.RoleUser, Content: "Hello"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := header.Get("X-Title"); got != "my-app" {
+		t.Errorf("Expected X-Title 'my-app', got %q", got)
+	}
+	if got := header.Get("HTTP-Referer"); got != "https://example.com" {
+		t.Errorf("Expected HTTP-Referer header, got %q", got)
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import "math"
+
+// TokenCounter estimates the number of tokens in a piece of text.
+type TokenCounter interface {
+	// CountTokens returns the estimated number of tokens in text.
+	CountTokens(text string) int
+}
+
+// ApproxTokenCounter estimates tokens from the character count. It does not
+// depend on any provider tokenizer, so it works with every backend.
+type ApproxTokenCounter struct {
+	// CharsPerToken is the average number of characters per token.
+	CharsPerToken float64
+}
+
+// DefaultTokenCounter is the token counter used when none is configured.
+// Four characters per token is a reasonable average for English text.
+var DefaultTokenCounter TokenCounter = ApproxTokenCounter{CharsPerToken: 4}
+
+// CountTokens returns the estimated number of tokens in text.
+func (c ApproxTokenCounter) CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	charsPerToken := c.CharsPerToken
+	if charsPerToken <= 0 {
+		charsPerToken = 4
+	}
+
+	return int(math.Ceil(float64(len([]rune(text))) / charsPerToken))
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// nativeToolCall is a tool call returned natively by a provider.
+type nativeToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// agentToolCall is a tool call in the format the agents expect.
+type agentToolCall struct {
+	ID   string          `json:"id"`
+	Tool string          `json:"tool"`
+	Args json.RawMessage `json:"args"`
+}
+
+// formatToolCalls returns native tool calls in the format the agents expect:
+// a single {"id", "tool", "args"} object for one call, or a JSON array of such
+// objects when the model requested several calls at once.
+func formatToolCalls(calls []nativeToolCall) (string, error) {
+	formatted := make([]agentToolCall, 0, len(calls))
+	for _, call := range calls {
+		args := json.RawMessage(call.Arguments)
+		if !json.Valid(args) {
+			args = json.RawMessage("{}")
+		}
+		formatted = append(formatted, agentToolCall{ID: call.ID, Tool: call.Name, Args: args})
+	}
+
+	var data []byte
+	var err error
+	if len(formatted) == 1 {
+		data, err = json.Marshal(formatted[0])
+	} else {
+		data, err = json.Marshal(formatted)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// parseAgentToolCalls parses the tool calls of an assistant message in the
+// format produced by formatToolCalls. It returns nil if the message is not a
+// tool call.
+func parseAgentToolCalls(content string) []agentToolCall {
+	content = strings.TrimSpace(content)
+
+	var calls []agentToolCall
+	if strings.HasPrefix(content, "[") {
+		if err := json.Unmarshal([]byte(content), &calls); err != nil {
+			return nil
+		}
+	} else {
+		var call agentToolCall
+		if err := json.Unmarshal([]byte(content), &call); err != nil {
+			return nil
+		}
+		calls = []agentToolCall{call}
+	}
+
+	for _, call := range calls {
+		if call.ID == "" || call.Tool == "" {
+			return nil
+		}
+	}
+	return calls
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestFormatToolCalls tests that one call is formatted as an object, several
+// as an array, and that both parse back
+func TestFormatToolCalls(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []nativeToolCall
+		want  string
+	}{
+		{
+			name:  "single call",
+			calls: []nativeToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`}},
+			want:  `{"id":"call_1","tool":"weather","args":{"city":"Paris"}}`,
+		},
+		{
+			name: "several calls",
+			calls: []nativeToolCall{
+				{ID: "call_1", Name: "weather", Arguments: `{"city":"Paris"}`},
+				{ID: "call_2", Name: "time", Arguments: ""},
+			},
+			want: `[{"id":"call_1","tool":"weather","args":{"city":"Paris"}},{"id":"call_2","tool":"time","args":{}}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatToolCalls(tt.calls)
+			if err != nil {
+				t.Fatalf("formatToolCalls() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+
+			parsed := parseAgentToolCalls(got)
+			if len(parsed) != len(tt.calls) {
+				t.Fatalf("Expected %d parsed calls, got %d", len(tt.calls), len(parsed))
+			}
+			for i, call := range parsed {
+				if call.ID != tt.calls[i].ID || call.Tool != tt.calls[i].Name || !json.Valid(call.Args) {
+					t.Errorf("Unexpected parsed call %d: %+v", i, call)
+				}
+			}
+		})
+	}
+
+	if calls := parseAgentToolCalls("The weather is sunny."); calls != nil {
+		t.Errorf("Expected no calls for plain text, got %+v", calls)
+	}
+}
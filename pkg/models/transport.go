@@ -0,0 +1,112 @@
+package models
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryConfig configures a transport created by NewRetryTransport.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// BaseDelay is the wait before the first retry. It doubles for each
+	// subsequent attempt unless the server sends a Retry-After header.
+	BaseDelay time.Duration
+	// StatusCodes lists the transient status codes that trigger a retry.
+	// When empty, DefaultRetryStatusCodes is used.
+	StatusCodes []int
+}
+
+// DefaultRetryStatusCodes are the status codes retried when a RetryConfig
+// does not specify its own.
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryTransport is an http.RoundTripper that retries transient failures.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy retryPolicy
+	codes  map[int]bool
+}
+
+// NewRetryTransport wraps base so that requests failing with a transient
+// status code are retried with exponential backoff. If base is nil,
+// http.DefaultTransport is used.
+//
+// Only requests whose body can be replayed are retried: requests without a
+// body, or those built with a body type http.NewRequest knows how to rewind.
+// The returned transport can be installed with WithHttpClient for any
+// backend, and composes with other round trippers such as proxies or logging.
+func NewRetryTransport(base http.RoundTripper, cfg RetryConfig) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	statusCodes := cfg.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = DefaultRetryStatusCodes
+	}
+	codes := make(map[int]bool, len(statusCodes))
+	for _, code := range statusCodes {
+		codes[code] = true
+	}
+
+	return &retryTransport{
+		base:   base,
+		policy: retryPolicy{maxRetries: cfg.MaxRetries, baseDelay: cfg.BaseDelay},
+		codes:  codes,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !t.codes[resp.StatusCode] || !replayable || attempt >= t.policy.maxRetries {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+		if after, ok := retryAfter(resp); ok {
+			delay = after
+		}
+
+		// Drain and close the body so the connection can be reused.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
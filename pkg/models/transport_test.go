@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetryTransport tests that transient failures are retried with the
+// original request body
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		status       int
+		maxRetries   int
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"succeeds after transient failures", 2, http.StatusServiceUnavailable, 3, http.StatusOK, 3},
+		{"gives up after max retries", 5, http.StatusTooManyRequests, 2, http.StatusTooManyRequests, 3},
+		{"does not retry other errors", 1, http.StatusBadRequest, 3, http.StatusBadRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != `{"inputs":"hi"}` {
+					t.Errorf("Attempt %d: unexpected body %q", attempts, body)
+				}
+				if attempts <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: NewRetryTransport(nil, RetryConfig{
+				MaxRetries: tt.maxRetries,
+				BaseDelay:  time.Millisecond,
+			})}
+
+			resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"inputs":"hi"}`))
+			if err != nil {
+				t.Fatalf("Post() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, attempts)
+			}
+		})
+	}
+}
+
+// TestRetryTransportWithModel tests the transport installed on an HfApiModel
+func TestRetryTransportWithModel(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"generated_text": "recovered"}]`))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, RetryConfig{MaxRetries: 1, BaseDelay: time.Millisecond})}
+	model := NewHfApiModel("test-model", WithHttpClient(client))
+	model.ApiURL = server.URL
+
+	got, err := model.Generate(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if got != "recovered" {
+		t.Errorf("Expected %q, got %q", "recovered", got)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
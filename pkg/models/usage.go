@@ -0,0 +1,23 @@
+package models
+
+import "context"
+
+// GenerateResult is a model response together with its token usage. Token
+// counts are zero when the provider does not report them.
+type GenerateResult struct {
+	Content          string
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// DetailedModel is a Model that can report token usage for its responses.
+// It is kept separate from Model so existing implementations need not
+// support it.
+type DetailedModel interface {
+	Model
+
+	// GenerateDetailed generates a response for the given messages and
+	// returns it with its token usage.
+	GenerateDetailed(ctx context.Context, messages []Message) (*GenerateResult, error)
+}
@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+)
+
+// Runner is implemented by agents, which run on a task and return an answer.
+type Runner interface {
+	Run(ctx context.Context, task string) (any, error)
+}
+
+// agentTool exposes an agent as a tool that runs the agent on a task.
+type agentTool struct {
+	agent       Runner
+	name        string
+	description string
+}
+
+// AgentTool wraps agent as a tool, so a manager agent can delegate to it like
+// to any other tool. The tool takes a "task" argument, runs the agent on it and
+// returns the agent's answer as a string.
+func AgentTool(agent Runner, name, description string) Tool {
+	return &agentTool{agent: agent, name: name, description: description}
+}
+
+// Name returns the name of the tool.
+func (t *agentTool) Name() string {
+	return t.name
+}
+
+// Description returns a description of what the tool does.
+func (t *agentTool) Description() string {
+	return t.description
+}
+
+// Schema returns the JSON schema of the tool.
+func (t *agentTool) Schema() *ToolSchema {
+	return &ToolSchema{
+		Type: "object",
+		Properties: map[string]PropertyDef{
+			"task": {
+				Type:        "string",
+				Description: "The task for the agent, with all the context it needs",
+			},
+		},
+		Required: []string{"task"},
+	}
+}
+
+// Execute runs the agent on the task.
+func (t *agentTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	task, ok := args["task"].(string)
+	if !ok || task == "" {
+		return nil, fmt.Errorf("agent %s requires a task", t.name)
+	}
+
+	answer, err := t.agent.Run(ctx, task)
+	if err != nil {
+		return nil, fmt.Errorf("agent %s failed: %w", t.name, err)
+	}
+
+	return fmt.Sprintf("%v", answer), nil
+}
@@ -0,0 +1,110 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+// WithMaxArgBytes limits the size of string and array arguments to maxBytes.
+// Strings are measured in bytes and arrays by the size of their JSON
+// encoding. By default an oversized argument fails the call with an error the
+// model can correct; see WithArgTruncation.
+func WithMaxArgBytes(maxBytes int) ToolOption {
+	return func(c *toolConfig) {
+		c.maxArgBytes = maxBytes
+	}
+}
+
+// WithArgTruncation makes oversized arguments be truncated to the limit set
+// by WithMaxArgBytes instead of rejected. Strings are cut at a rune boundary
+// and arrays lose their trailing elements.
+func WithArgTruncation(truncate bool) ToolOption {
+	return func(c *toolConfig) {
+		c.truncateArgs = truncate
+	}
+}
+
+// limitArgs enforces the argument size limit, returning the arguments to
+// call the tool with.
+func (c toolConfig) limitArgs(args map[string]any) (map[string]any, error) {
+	if c.maxArgBytes <= 0 {
+		return args, nil
+	}
+
+	var limited map[string]any
+	for name, arg := range args {
+		size, ok := argSize(arg)
+		if !ok || size <= c.maxArgBytes {
+			continue
+		}
+
+		if !c.truncateArgs {
+			return nil, fmt.Errorf("argument %s is %d bytes, which exceeds the limit of %d bytes; retry with a shorter value",
+				name, size, c.maxArgBytes)
+		}
+
+		if limited == nil {
+			limited = make(map[string]any, len(args))
+			for k, v := range args {
+				limited[k] = v
+			}
+		}
+		limited[name] = truncateArg(arg, c.maxArgBytes)
+	}
+
+	if limited == nil {
+		return args, nil
+	}
+	return limited, nil
+}
+
+// argSize returns the size of a string or array argument. ok is false for
+// arguments of other kinds, which are not limited.
+func argSize(arg any) (size int, ok bool) {
+	if s, isString := arg.(string); isString {
+		return len(s), true
+	}
+
+	if arg == nil {
+		return 0, false
+	}
+	kind := reflect.TypeOf(arg).Kind()
+	if kind != reflect.Slice && kind != reflect.Array {
+		return 0, false
+	}
+
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return 0, false
+	}
+	return len(data), true
+}
+
+// truncateArg shortens a string or array argument to fit in maxBytes.
+func truncateArg(arg any, maxBytes int) any {
+	if s, ok := arg.(string); ok {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		return s[:cut]
+	}
+
+	value := reflect.ValueOf(arg)
+	if value.Kind() == reflect.Array {
+		slice := reflect.MakeSlice(reflect.SliceOf(value.Type().Elem()), value.Len(), value.Len())
+		reflect.Copy(slice, value)
+		value = slice
+	}
+
+	// The encoded size grows with the prefix, so search for the first
+	// prefix that is too long
+	n := sort.Search(value.Len()+1, func(n int) bool {
+		size, _ := argSize(value.Slice(0, n).Interface())
+		return size > maxBytes
+	})
+	return value.Slice(0, max(n-1, 0)).Interface()
+}
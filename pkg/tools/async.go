@@ -0,0 +1,175 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Pending is returned by an asynchronous tool in place of its result. The
+// model retrieves the result later with the check tool created by
+// NewCheckTool.
+type Pending struct {
+	ID string `json:"id"`
+}
+
+// String returns the observation shown to the model for a pending job.
+func (p Pending) String() string {
+	return fmt.Sprintf("Job %s started and is still running. Call %s with id %q to get its result.", p.ID, checkToolName, p.ID)
+}
+
+// ErrNoJobRegistry is returned when an asynchronous job is started or
+// checked without a job registry in the context.
+var ErrNoJobRegistry = errors.New("no job registry in context")
+
+// job is an asynchronous job tracked by a JobRegistry.
+type job struct {
+	done   chan struct{}
+	result any
+	err    error
+}
+
+// JobRegistry tracks the asynchronous jobs started by tools during a run.
+type JobRegistry struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	nextID int
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewJobRegistry creates an empty JobRegistry.
+func NewJobRegistry() *JobRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobRegistry{
+		jobs:   make(map[string]*job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start runs fn in the background and returns a handle to its result. The
+// context passed to fn is cancelled when the registry is closed.
+func (r *JobRegistry) Start(fn func(ctx context.Context) (any, error)) Pending {
+	r.mu.Lock()
+	r.nextID++
+	id := fmt.Sprintf("job-%d", r.nextID)
+	j := &job{done: make(chan struct{})}
+	r.jobs[id] = j
+	r.mu.Unlock()
+
+	go func() {
+		defer close(j.done)
+		j.result, j.err = fn(r.ctx)
+	}()
+
+	return Pending{ID: id}
+}
+
+// Check returns the result of the job with the given id. done is false
+// while the job is still running.
+func (r *JobRegistry) Check(id string) (result any, done bool, err error) {
+	r.mu.Lock()
+	j, ok := r.jobs[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil, false, fmt.Errorf("unknown job: %s", id)
+	}
+
+	select {
+	case <-j.done:
+		return j.result, true, j.err
+	default:
+		return nil, false, nil
+	}
+}
+
+// Close cancels the context of every job that is still running.
+func (r *JobRegistry) Close() {
+	r.cancel()
+}
+
+// jobRegistryKey is the context key for the job registry.
+type jobRegistryKey struct{}
+
+// ContextWithJobRegistry returns a copy of ctx carrying registry. Agents
+// attach a fresh registry to the context of each run.
+func ContextWithJobRegistry(ctx context.Context, registry *JobRegistry) context.Context {
+	return context.WithValue(ctx, jobRegistryKey{}, registry)
+}
+
+// JobRegistryFromContext returns the job registry carried by ctx, if any.
+func JobRegistryFromContext(ctx context.Context) (*JobRegistry, bool) {
+	registry, ok := ctx.Value(jobRegistryKey{}).(*JobRegistry)
+	return registry, ok && registry != nil
+}
+
+// StartJob runs fn in the background using the job registry carried by ctx
+// and returns the handle an asynchronous tool should return from Execute.
+func StartJob(ctx context.Context, fn func(ctx context.Context) (any, error)) (Pending, error) {
+	registry, ok := JobRegistryFromContext(ctx)
+	if !ok {
+		return Pending{}, ErrNoJobRegistry
+	}
+	return registry.Start(fn), nil
+}
+
+// checkToolName is the name of the tool created by NewCheckTool.
+const checkToolName = "check_job"
+
+// checkTool lets the model poll asynchronous jobs by id.
+type checkTool struct{}
+
+// NewCheckTool creates the tool the model uses to retrieve the results of
+// asynchronous jobs. Add it alongside any tool that returns Pending.
+func NewCheckTool() Tool {
+	return checkTool{}
+}
+
+// Name returns the name of the tool.
+func (checkTool) Name() string {
+	return checkToolName
+}
+
+// Description returns a description of what the tool does.
+func (checkTool) Description() string {
+	return "Checks a background job started by another tool and returns its result once it has finished."
+}
+
+// Schema returns the JSON schema of the tool.
+func (checkTool) Schema() *ToolSchema {
+	return &ToolSchema{
+		Type: "object",
+		Properties: map[string]PropertyDef{
+			"id": {
+				Type:        "string",
+				Description: "The id of the job to check",
+			},
+		},
+		Required: []string{"id"},
+	}
+}
+
+// Execute returns the job's result, or a note that it is still running.
+func (checkTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	id, ok := args["id"].(string)
+	if !ok || id == "" {
+		return nil, errors.New("missing required argument: id")
+	}
+
+	registry, ok := JobRegistryFromContext(ctx)
+	if !ok {
+		return nil, ErrNoJobRegistry
+	}
+
+	result, done, err := registry.Check(id)
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		return fmt.Sprintf("Job %s is still running. Check again later.", id), nil
+	}
+
+	return result, nil
+}
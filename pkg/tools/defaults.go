@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// WithParamDefault makes the named parameter optional, passing value when the
+// argument is missing. The default is also advertised in the schema. The name
+// is the one given by WithParamNames, or "arg" followed by the position.
+func WithParamDefault(name string, value any) ToolOption {
+	return func(c *toolConfig) {
+		if c.paramDefaults == nil {
+			c.paramDefaults = make(map[string]any)
+		}
+		c.paramDefaults[name] = value
+	}
+}
+
+// setParamDefaults sets the defaults of the schema's parameters and removes
+// them from the required parameters. Each default must name a parameter and
+// convert to its type.
+func setParamDefaults(schema *ToolSchema, fnType reflect.Type, names []string, defaults map[string]any) error {
+	offset := contextParams(fnType)
+	for i := offset; i < fnType.NumIn(); i++ {
+		name := parameterName(names, i-offset)
+		value, ok := defaults[name]
+		if !ok {
+			continue
+		}
+
+		if _, err := convertArgument(value, fnType.In(i)); err != nil {
+			return fmt.Errorf("invalid default for parameter %s: %w", name, err)
+		}
+
+		property := schema.Properties[name]
+		property.Default = value
+		schema.Properties[name] = property
+		schema.Required = slices.DeleteFunc(schema.Required, func(required string) bool {
+			return required == name
+		})
+	}
+
+	for name := range defaults {
+		if _, ok := schema.Properties[name]; !ok {
+			return fmt.Errorf("default for unknown parameter: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// applyDefaults returns args with the default of each missing property
+// filled in, including those of nested objects. args is not modified.
+func applyDefaults(properties map[string]PropertyDef, args map[string]any) map[string]any {
+	filled, _ := fillDefaults(properties, args)
+	return filled
+}
+
+// fillDefaults implements applyDefaults, reporting whether any default was
+// filled in. args is copied before the first change.
+func fillDefaults(properties map[string]PropertyDef, args map[string]any) (map[string]any, bool) {
+	filled := args
+	changed := false
+	set := func(name string, value any) {
+		if !changed {
+			filled = make(map[string]any, len(args)+1)
+			for key, value := range args {
+				filled[key] = value
+			}
+			changed = true
+		}
+		filled[name] = value
+	}
+
+	for name, property := range properties {
+		arg, ok := args[name]
+		if !ok {
+			if property.Default != nil {
+				set(name, property.Default)
+			}
+			continue
+		}
+
+		if nested, isObject := arg.(map[string]any); isObject && property.Type == "object" {
+			if nested, nestedChanged := fillDefaults(property.Properties, nested); nestedChanged {
+				set(name, nested)
+			}
+		}
+	}
+
+	return filled, changed
+}
@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WithParamEnum restricts the named parameter to the given values. The values
+// are advertised in the schema, and Execute rejects any other argument. The
+// name is the one given by WithParamNames, or "arg" followed by the position.
+func WithParamEnum(name string, values ...string) ToolOption {
+	return func(c *toolConfig) {
+		if c.paramEnums == nil {
+			c.paramEnums = make(map[string][]string)
+		}
+		c.paramEnums[name] = values
+	}
+}
+
+// setParamEnums sets the allowed values of the schema's parameters. Each enum
+// must name a parameter, and include the parameter's default if it has one.
+func setParamEnums(schema *ToolSchema, enums map[string][]string) error {
+	for name, values := range enums {
+		property, ok := schema.Properties[name]
+		if !ok {
+			return fmt.Errorf("enum for unknown parameter: %s", name)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("enum for parameter %s cannot be empty", name)
+		}
+		if property.Default != nil && !slices.Contains(values, fmt.Sprint(property.Default)) {
+			return fmt.Errorf("default for parameter %s is not one of %v", name, values)
+		}
+
+		property.Enum = values
+		schema.Properties[name] = property
+	}
+	return nil
+}
+
+// checkEnums reports the first argument that is not one of the allowed values
+// of its property. Missing and null arguments are not checked.
+func checkEnums(properties map[string]PropertyDef, args map[string]any) error {
+	for name, property := range properties {
+		arg := args[name]
+		if arg == nil || len(property.Enum) == 0 {
+			continue
+		}
+
+		if value := fmt.Sprint(arg); !slices.Contains(property.Enum, value) {
+			return fmt.Errorf("invalid value %q for argument %s: must be one of %s", value, name, strings.Join(property.Enum, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package tools
+
+import (
+	"context"
+	"errors"
+)
+
+// FinalAnswerToolName is the name of the tool created by FinalAnswerTool.
+const FinalAnswerToolName = "final_answer"
+
+// finalAnswerTool lets the model end the run with an explicit answer.
+type finalAnswerTool struct{}
+
+// FinalAnswerTool creates the tool the model calls to give its final answer.
+// Agents register it automatically and end the run with the "answer"
+// argument when it is called, instead of executing it.
+func FinalAnswerTool() Tool {
+	return finalAnswerTool{}
+}
+
+// Name returns the name of the tool.
+func (finalAnswerTool) Name() string {
+	return FinalAnswerToolName
+}
+
+// Description returns a description of what the tool does.
+func (finalAnswerTool) Description() string {
+	return "Provides the final answer to the task and ends the run. Call it once you have the answer."
+}
+
+// Schema returns the JSON schema of the tool.
+func (finalAnswerTool) Schema() *ToolSchema {
+	return &ToolSchema{
+		Type: "object",
+		Properties: map[string]PropertyDef{
+			"answer": {
+				Type:        "string",
+				Description: "The final answer to the task",
+			},
+		},
+		Required: []string{"answer"},
+	}
+}
+
+// Execute returns the answer.
+func (finalAnswerTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	answer, ok := args["answer"]
+	if !ok {
+		return nil, errors.New("missing required argument: answer")
+	}
+	return answer, nil
+}
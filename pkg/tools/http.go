@@ -0,0 +1,245 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultHTTPTimeout is the default maximum duration of a request of
+// HTTPTool.
+const DefaultHTTPTimeout = 30 * time.Second
+
+// DefaultHTTPMaxBodyBytes is the default number of bytes of a response body
+// HTTPTool returns; longer bodies are truncated.
+const DefaultHTTPMaxBodyBytes = 32 << 10
+
+// httpMethods are the methods HTTPRequestTool accepts.
+var httpMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// HTTPTool is a tool that sends HTTP requests and returns the status code
+// and the beginning of the response body, so large pages do not flood the
+// model's context. Each request runs under a timeout and is cancelled with
+// the run's context.
+type HTTPTool struct {
+	client       *http.Client
+	timeout      time.Duration
+	maxBodyBytes int
+	anyMethod    bool
+}
+
+// HTTPOption is a functional option for configuring an HTTPTool.
+type HTTPOption func(t *HTTPTool)
+
+// WithHTTPTimeout sets the maximum duration of a request, including reading
+// the response body.
+func WithHTTPTimeout(timeout time.Duration) HTTPOption {
+	return func(t *HTTPTool) {
+		t.timeout = timeout
+	}
+}
+
+// WithHTTPMaxBodyBytes sets the number of bytes of a response body returned
+// to the model.
+func WithHTTPMaxBodyBytes(n int) HTTPOption {
+	return func(t *HTTPTool) {
+		t.maxBodyBytes = n
+	}
+}
+
+// WithHTTPClient sets the client sending the requests, for example to add
+// authentication or a proxy.
+func WithHTTPClient(client *http.Client) HTTPOption {
+	return func(t *HTTPTool) {
+		t.client = client
+	}
+}
+
+// HTTPGetTool creates a tool named "http_get" that fetches a URL with a GET
+// request. Options with a zero or negative value keep the default.
+func HTTPGetTool(opts ...HTTPOption) Tool {
+	return newHTTPTool(false, opts)
+}
+
+// HTTPRequestTool creates a tool named "http_request" that sends a request
+// with any common method, an optional body and optional headers. Since such
+// requests can have side effects, the tool is declared non-idempotent.
+// Options with a zero or negative value keep the default.
+func HTTPRequestTool(opts ...HTTPOption) Tool {
+	return newHTTPTool(true, opts)
+}
+
+// newHTTPTool creates an HTTPTool, applying the options over the defaults.
+func newHTTPTool(anyMethod bool, opts []HTTPOption) *HTTPTool {
+	t := &HTTPTool{anyMethod: anyMethod}
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	if t.client == nil {
+		t.client = http.DefaultClient
+	}
+	if t.timeout <= 0 {
+		t.timeout = DefaultHTTPTimeout
+	}
+	if t.maxBodyBytes <= 0 {
+		t.maxBodyBytes = DefaultHTTPMaxBodyBytes
+	}
+
+	return t
+}
+
+// Name returns the name of the tool.
+func (t *HTTPTool) Name() string {
+	if t.anyMethod {
+		return "http_request"
+	}
+	return "http_get"
+}
+
+// Description returns a description of what the tool does.
+func (t *HTTPTool) Description() string {
+	desc := "Fetches a URL with an HTTP GET request"
+	if t.anyMethod {
+		desc = "Sends an HTTP request to a URL"
+	}
+	return fmt.Sprintf("%s and returns the status code and the first %d bytes of the response body.", desc, t.maxBodyBytes)
+}
+
+// Schema returns the JSON schema of the tool.
+func (t *HTTPTool) Schema() *ToolSchema {
+	schema := &ToolSchema{
+		Type: "object",
+		Properties: map[string]PropertyDef{
+			"url": {
+				Type:        "string",
+				Description: "The http or https URL to request",
+			},
+		},
+		Required: []string{"url"},
+	}
+
+	if t.anyMethod {
+		schema.Properties["method"] = PropertyDef{
+			Type:        "string",
+			Description: "The HTTP method, GET by default",
+			Enum:        httpMethods,
+			Default:     http.MethodGet,
+		}
+		schema.Properties["body"] = PropertyDef{
+			Type:        "string",
+			Description: "The request body, if any",
+		}
+		schema.Properties["headers"] = PropertyDef{
+			Type:                 "object",
+			Description:          "Request headers, by name",
+			AdditionalProperties: &PropertyDef{Type: "string"},
+		}
+	}
+
+	return schema
+}
+
+// Idempotent reports whether the tool may be safely re-invoked: GET requests
+// may be, but requests with any method may not.
+func (t *HTTPTool) Idempotent() bool {
+	return !t.anyMethod
+}
+
+// Execute sends the request described by args and returns the status code
+// and the response body, truncated to the maximum body size.
+func (t *HTTPTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	req, err := t.buildRequest(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(t.maxBodyBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	truncated := len(body) > t.maxBodyBytes
+	if truncated {
+		body = body[:t.maxBodyBytes]
+		// Do not end on a partial UTF-8 sequence
+		for i := 0; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+			body = body[:len(body)-1]
+		}
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Status: %s\n", resp.Status)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		fmt.Fprintf(&builder, "Content-Type: %s\n", contentType)
+	}
+	builder.WriteString("\n")
+	builder.Write(body)
+	if truncated {
+		fmt.Fprintf(&builder, "\n... (body truncated at %d bytes)", t.maxBodyBytes)
+	}
+
+	return builder.String(), nil
+}
+
+// buildRequest validates the arguments and builds the request.
+func (t *HTTPTool) buildRequest(ctx context.Context, args map[string]any) (*http.Request, error) {
+	rawURL, ok := args["url"].(string)
+	if !ok || strings.TrimSpace(rawURL) == "" {
+		return nil, errors.New("missing required argument: url")
+	}
+
+	target, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q: only http and https are allowed", target.Scheme)
+	}
+
+	method := http.MethodGet
+	var body io.Reader
+	headers := map[string]any{}
+	if t.anyMethod {
+		if m, ok := args["method"].(string); ok && m != "" {
+			method = strings.ToUpper(m)
+		}
+		if !slices.Contains(httpMethods, method) {
+			return nil, fmt.Errorf("unsupported method: %s", method)
+		}
+		if b, ok := args["body"].(string); ok && b != "" {
+			body = strings.NewReader(b)
+		}
+		if h, ok := args["headers"].(map[string]any); ok {
+			headers = h
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, fmt.Sprintf("%v", value))
+	}
+
+	return req, nil
+}
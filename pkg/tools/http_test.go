@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHTTPGetTool tests fetching a URL and truncating long bodies
+func TestHTTPGetTool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Expected GET request, got %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/hello":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "hello world")
+		case "/long":
+			io.WriteString(w, strings.Repeat("a", 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tool := HTTPGetTool(WithHTTPMaxBodyBytes(10))
+	if tool.Name() != "http_get" {
+		t.Errorf("Expected name 'http_get', got '%s'", tool.Name())
+	}
+	if !IsIdempotent(tool) {
+		t.Error("Expected http_get to be idempotent")
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"url": server.URL + "/hello"})
+	if err != nil {
+		t.Fatalf("Failed to execute tool: %v", err)
+	}
+	output := result.(string)
+	if !strings.Contains(output, "Status: 200 OK") || !strings.Contains(output, "Content-Type: text/plain") {
+		t.Errorf("Expected status and content type, got %q", output)
+	}
+	if !strings.Contains(output, "hello worl") || strings.Contains(output, "hello world") {
+		t.Errorf("Expected body truncated to 10 bytes, got %q", output)
+	}
+	if !strings.Contains(output, "truncated at 10 bytes") {
+		t.Errorf("Expected truncation note, got %q", output)
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]any{"url": server.URL + "/missing"})
+	if err != nil {
+		t.Fatalf("Expected error statuses to be returned, got error: %v", err)
+	}
+	if !strings.Contains(result.(string), "Status: 404 Not Found") {
+		t.Errorf("Expected 404 status, got %q", result)
+	}
+
+	for _, args := range []map[string]any{{}, {"url": "file:///etc/passwd"}} {
+		if _, err := tool.Execute(context.Background(), args); err == nil {
+			t.Errorf("Expected error for arguments %v", args)
+		}
+	}
+}
+
+// TestHTTPToolTimeout tests that slow servers and cancelled contexts abort requests
+func TestHTTPToolTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tool := HTTPGetTool(WithHTTPTimeout(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := tool.Execute(context.Background(), map[string]any{"url": server.URL}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected request to time out quickly, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := HTTPGetTool().Execute(ctx, map[string]any{"url": server.URL}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context canceled, got %v", err)
+	}
+}
+
+// TestHTTPRequestTool tests sending requests with a method, body and headers
+func TestHTTPRequestTool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, r.Method+" "+r.Header.Get("X-Token")+" "+string(body))
+	}))
+	defer server.Close()
+
+	tool := HTTPRequestTool()
+	if tool.Name() != "http_request" {
+		t.Errorf("Expected name 'http_request', got '%s'", tool.Name())
+	}
+	if IsIdempotent(tool) {
+		t.Error("Expected http_request not to be idempotent")
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{
+		"url":     server.URL,
+		"method":  "post",
+		"body":    `{"name":"Ada"}`,
+		"headers": map[string]any{"X-Token": "secret"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute tool: %v", err)
+	}
+	output := result.(string)
+	if !strings.Contains(output, "Status: 201 Created") || !strings.Contains(output, `POST secret {"name":"Ada"}`) {
+		t.Errorf("Unexpected output %q", output)
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]any{"url": server.URL, "method": "TRACE"}); err == nil {
+		t.Error("Expected error for unsupported method")
+	}
+}
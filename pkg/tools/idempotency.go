@@ -0,0 +1,57 @@
+package tools
+
+// IdempotencyDeclarer is implemented by tools that declare whether invoking
+// them more than once with the same arguments is safe. Tools that do not
+// implement it are assumed to be idempotent.
+type IdempotencyDeclarer interface {
+	// Idempotent reports whether the tool may be safely re-invoked.
+	Idempotent() bool
+}
+
+// IsIdempotent reports whether tool may be safely re-invoked.
+func IsIdempotent(tool Tool) bool {
+	if declarer, ok := tool.(IdempotencyDeclarer); ok {
+		return declarer.Idempotent()
+	}
+	return true
+}
+
+// WithIdempotent declares whether the tool may be safely re-invoked. Tools
+// with irreversible side effects, such as sending an email, should be
+// declared non-idempotent so recovery logic never runs them twice.
+func WithIdempotent(idempotent bool) ToolOption {
+	return func(c *toolConfig) {
+		c.nonIdempotent = !idempotent
+	}
+}
+
+// nonIdempotentTool wraps a tool to declare it non-idempotent. It forwards
+// the optional interfaces of the wrapped tool, which embedding alone would
+// hide.
+type nonIdempotentTool struct {
+	Tool
+}
+
+// Idempotent reports that the tool must not be re-invoked.
+func (t nonIdempotentTool) Idempotent() bool {
+	return false
+}
+
+// OutputSchema returns the output schema of the wrapped tool, if it declares
+// one.
+func (t nonIdempotentTool) OutputSchema() *PropertyDef {
+	if provider, ok := t.Tool.(OutputSchemaProvider); ok {
+		return provider.OutputSchema()
+	}
+	return nil
+}
+
+// RenderOutput renders output as the wrapped tool does.
+func (t nonIdempotentTool) RenderOutput(output any) string {
+	return RenderOutput(t.Tool, output)
+}
+
+// NonIdempotent wraps tool to declare it non-idempotent.
+func NonIdempotent(tool Tool) Tool {
+	return nonIdempotentTool{Tool: tool}
+}
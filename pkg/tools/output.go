@@ -0,0 +1,71 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// OutputSchemaProvider is implemented by tools that declare the schema of
+// the value they return.
+type OutputSchemaProvider interface {
+	// OutputSchema returns the schema of the tool's output, or nil if the
+	// output is not described.
+	OutputSchema() *PropertyDef
+}
+
+// ValidateOutput checks that value conforms to schema. The value is compared
+// through its JSON representation, so a Go struct satisfies an "object"
+// schema and any numeric type satisfies a "number" schema.
+func ValidateOutput(schema *PropertyDef, value any) error {
+	if schema == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("failed to unmarshal output: %w", err)
+	}
+
+	var ok bool
+	switch schema.Type {
+	case "string":
+		_, ok = decoded.(string)
+	case "boolean":
+		_, ok = decoded.(bool)
+	case "number":
+		_, ok = decoded.(float64)
+	case "integer":
+		f, isNumber := decoded.(float64)
+		ok = isNumber && f == math.Trunc(f)
+	case "array":
+		_, ok = decoded.([]any)
+	case "object":
+		_, ok = decoded.(map[string]any)
+	case "":
+		ok = true
+	default:
+		return fmt.Errorf("unsupported output schema type: %s", schema.Type)
+	}
+
+	if !ok {
+		return fmt.Errorf("expected output of type %s, got %s", schema.Type, string(data))
+	}
+
+	if len(schema.Enum) > 0 {
+		s, _ := decoded.(string)
+		for _, allowed := range schema.Enum {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("output %s is not one of %v", string(data), schema.Enum)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package tools
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// WithParamNames names the function's parameters, in order, so the schema and
+// the arguments use names such as "location" instead of "arg0". One name is
+// required per parameter, not counting a leading context.Context.
+func WithParamNames(names ...string) ToolOption {
+	return func(c *toolConfig) {
+		c.paramNames = names
+	}
+}
+
+// validateParamNames checks that names, if given, name every parameter of
+// fnType once, except a leading context.
+func validateParamNames(fnType reflect.Type, names []string) error {
+	if names == nil {
+		return nil
+	}
+	if params := fnType.NumIn() - contextParams(fnType); len(names) != params {
+		return fmt.Errorf("got %d parameter names for %d parameters", len(names), params)
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("parameter names cannot be empty")
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate parameter name: %s", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// parameterName returns the name of parameter i: its configured name, or "arg"
+// followed by its position.
+func parameterName(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return fmt.Sprintf("arg%d", i)
+}
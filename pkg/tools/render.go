@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"fmt"
+)
+
+// OutputRenderer is implemented by tools that render their own output as the
+// observation text shown to the model.
+type OutputRenderer interface {
+	// RenderOutput returns the text of output for the model.
+	RenderOutput(output any) string
+}
+
+// RenderOutput returns the observation text of a tool's output: the tool's
+// own rendering if it implements OutputRenderer, and the output formatted
+// with %v otherwise.
+func RenderOutput(tool Tool, output any) string {
+	if renderer, ok := tool.(OutputRenderer); ok {
+		return renderer.RenderOutput(output)
+	}
+	return fmt.Sprintf("%v", output)
+}
+
+// WithOutputRenderer sets the function rendering the tool's output as the
+// observation text, for example to present a slice as a bullet list or a
+// table as CSV, instead of the default %v formatting.
+func WithOutputRenderer(render func(output any) string) ToolOption {
+	return func(c *toolConfig) {
+		c.outputRenderer = render
+	}
+}
+
+// renderOutput renders output with the configured renderer, if any.
+func (c *toolConfig) renderOutput(output any) string {
+	if c.outputRenderer != nil {
+		return c.outputRenderer(output)
+	}
+	return fmt.Sprintf("%v", output)
+}
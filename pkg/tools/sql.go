@@ -0,0 +1,393 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SQLOutputFormat selects how SQLTool formats query results.
+type SQLOutputFormat string
+
+const (
+	// SQLOutputTable formats results as a pipe-separated table.
+	SQLOutputTable SQLOutputFormat = "table"
+	// SQLOutputJSON formats results as a JSON array of row objects.
+	SQLOutputJSON SQLOutputFormat = "json"
+)
+
+// ErrSQLNotAllowed is returned for queries rejected by SQLTool's guardrails.
+var ErrSQLNotAllowed = errors.New("query not allowed")
+
+// SQLTool is a tool that runs SQL queries against a database with
+// guardrails: by default only single read-only statements are accepted, the
+// number of returned rows is capped, and each query runs under a timeout.
+//
+// The read-only check and the table allowlist inspect the query text and are
+// a best-effort guard; connect with a read-only database user as well when
+// the database holds data the agent must not modify.
+type SQLTool struct {
+	db            *sql.DB
+	allowWrites   bool
+	allowedTables map[string]bool
+	maxRows       int
+	timeout       time.Duration
+	format        SQLOutputFormat
+}
+
+// SQLOption is a functional option for configuring an SQLTool.
+type SQLOption func(t *SQLTool)
+
+// WithSQLAllowWrites allows statements other than SELECT queries.
+func WithSQLAllowWrites(allow bool) SQLOption {
+	return func(t *SQLTool) {
+		t.allowWrites = allow
+	}
+}
+
+// WithSQLAllowedTables restricts queries to the given tables.
+func WithSQLAllowedTables(tables ...string) SQLOption {
+	return func(t *SQLTool) {
+		t.allowedTables = make(map[string]bool, len(tables))
+		for _, table := range tables {
+			t.allowedTables[strings.ToLower(table)] = true
+		}
+	}
+}
+
+// WithSQLMaxRows sets the maximum number of rows returned by a query.
+func WithSQLMaxRows(maxRows int) SQLOption {
+	return func(t *SQLTool) {
+		t.maxRows = maxRows
+	}
+}
+
+// WithSQLTimeout sets the maximum duration of a query.
+func WithSQLTimeout(timeout time.Duration) SQLOption {
+	return func(t *SQLTool) {
+		t.timeout = timeout
+	}
+}
+
+// WithSQLOutputFormat sets the format of query results.
+func WithSQLOutputFormat(format SQLOutputFormat) SQLOption {
+	return func(t *SQLTool) {
+		t.format = format
+	}
+}
+
+// NewSQLTool creates a new SQLTool querying db.
+func NewSQLTool(db *sql.DB, opts ...SQLOption) (*SQLTool, error) {
+	if db == nil {
+		return nil, errors.New("database is required")
+	}
+
+	t := &SQLTool{
+		db:      db,
+		maxRows: 100,
+		timeout: 10 * time.Second,
+		format:  SQLOutputTable,
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	if t.maxRows <= 0 {
+		return nil, errors.New("max rows must be greater than 0")
+	}
+	if t.format != SQLOutputTable && t.format != SQLOutputJSON {
+		return nil, fmt.Errorf("unsupported output format: %s", t.format)
+	}
+
+	return t, nil
+}
+
+// Name returns the name of the tool.
+func (t *SQLTool) Name() string {
+	return "sql_query"
+}
+
+// Description returns a description of what the tool does.
+func (t *SQLTool) Description() string {
+	desc := fmt.Sprintf("Runs a SQL query against the database and returns at most %d rows.", t.maxRows)
+	if !t.allowWrites {
+		desc += " Only read-only SELECT queries are allowed."
+	}
+	if len(t.allowedTables) > 0 {
+		desc += " Allowed tables: " + strings.Join(t.tableList(), ", ") + "."
+	}
+	return desc
+}
+
+// Schema returns the JSON schema of the tool.
+func (t *SQLTool) Schema() *ToolSchema {
+	return &ToolSchema{
+		Type: "object",
+		Properties: map[string]PropertyDef{
+			"query": {
+				Type:        "string",
+				Description: "The SQL query to run",
+			},
+		},
+		Required: []string{"query"},
+	}
+}
+
+// Execute runs the query in args["query"] and returns the formatted results.
+func (t *SQLTool) Execute(ctx context.Context, args map[string]any) (any, error) {
+	query, ok := args["query"].(string)
+	if !ok || strings.TrimSpace(query) == "" {
+		return nil, errors.New("missing required argument: query")
+	}
+
+	if err := t.checkQuery(query); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	rows, err := t.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	var results [][]any
+	truncated := false
+	for rows.Next() {
+		if len(results) == t.maxRows {
+			truncated = true
+			break
+		}
+
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		results = append(results, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	if t.format == SQLOutputJSON {
+		return formatSQLJSON(columns, results)
+	}
+	return formatSQLTable(columns, results, truncated), nil
+}
+
+// sqlWriteKeywords are the keywords of statements that modify data.
+var sqlWriteKeywords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "replace": true, "merge": true, "upsert": true,
+	"drop": true, "alter": true, "create": true, "truncate": true, "attach": true, "detach": true,
+	"pragma": true, "vacuum": true, "grant": true, "revoke": true,
+}
+
+// sqlClauseEnds are the keywords ending the table list of a FROM clause.
+var sqlClauseEnds = map[string]bool{
+	"where": true, "group": true, "having": true, "order": true, "limit": true, "offset": true,
+	"union": true, "intersect": true, "except": true, "window": true, "returning": true,
+}
+
+// checkQuery enforces the tool's guardrails on query. The query is tokenized
+// first, so string literals, quoted identifiers and comments cannot hide
+// statements or table references from the checks.
+func (t *SQLTool) checkQuery(query string) error {
+	tokens, err := tokenizeSQL(query)
+	if err != nil {
+		return err
+	}
+
+	// Trailing semicolons end the statement; any other one starts a new one
+	end := len(tokens)
+	for end > 0 && tokens[end-1].isSymbol(";") {
+		end--
+	}
+	tokens = tokens[:end]
+	for _, tok := range tokens {
+		if tok.isSymbol(";") {
+			return fmt.Errorf("%w: only a single statement is allowed", ErrSQLNotAllowed)
+		}
+	}
+
+	if !t.allowWrites {
+		if len(tokens) == 0 || !(tokens[0].isWord("select") || tokens[0].isWord("with")) {
+			return fmt.Errorf("%w: only SELECT queries are allowed", ErrSQLNotAllowed)
+		}
+		for _, tok := range tokens {
+			if tok.kind == sqlWord && sqlWriteKeywords[strings.ToLower(tok.text)] {
+				return fmt.Errorf("%w: %s statements are not allowed", ErrSQLNotAllowed, strings.ToUpper(tok.text))
+			}
+		}
+	}
+
+	if len(t.allowedTables) > 0 {
+		for i, tok := range tokens {
+			if !tok.isWord("from") {
+				continue
+			}
+			if err := t.checkFromClause(tokens, i+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkFromClause checks the table references of the FROM clause starting at
+// tokens[i] against the allowlist: the first one, and those following a
+// comma or a JOIN. Subqueries are skipped here, as their own FROM clauses
+// are checked separately.
+func (t *SQLTool) checkFromClause(tokens []sqlToken, i int) error {
+	for i >= 0 {
+		next, err := t.checkTableRef(tokens, i)
+		if err != nil {
+			return err
+		}
+		i = nextTableRef(tokens, next)
+	}
+	return nil
+}
+
+// checkTableRef checks the table reference at tokens[i] and returns the
+// position following it. References that are neither a subquery nor a
+// possibly qualified name are rejected.
+func (t *SQLTool) checkTableRef(tokens []sqlToken, i int) (int, error) {
+	if i >= len(tokens) {
+		return 0, fmt.Errorf("%w: missing table reference", ErrSQLNotAllowed)
+	}
+
+	if tokens[i].isSymbol("(") {
+		depth := 0
+		for j := i; j < len(tokens); j++ {
+			if tokens[j].isSymbol("(") {
+				depth++
+			} else if tokens[j].isSymbol(")") {
+				depth--
+				if depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("%w: unbalanced parentheses", ErrSQLNotAllowed)
+	}
+
+	if !tokens[i].isName() {
+		return 0, fmt.Errorf("%w: cannot parse table reference %q", ErrSQLNotAllowed, tokens[i].text)
+	}
+
+	// Check the table name of a schema-qualified reference
+	table := tokens[i].text
+	j := i + 1
+	for j+1 < len(tokens) && tokens[j].isSymbol(".") && tokens[j+1].isName() {
+		table = tokens[j+1].text
+		j += 2
+	}
+
+	table = strings.ToLower(table)
+	if !t.allowedTables[table] {
+		return 0, fmt.Errorf("%w: table %s is not allowed", ErrSQLNotAllowed, table)
+	}
+	return j, nil
+}
+
+// nextTableRef returns the position of the next table reference of a FROM
+// clause, following a comma or a JOIN outside parentheses, or -1 at the end
+// of the clause.
+func nextTableRef(tokens []sqlToken, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok.isSymbol("("):
+			depth++
+		case tok.isSymbol(")"):
+			if depth == 0 {
+				return -1
+			}
+			depth--
+		case depth > 0:
+		case tok.isSymbol(","), tok.isWord("join"):
+			return i + 1
+		case tok.kind == sqlWord && sqlClauseEnds[strings.ToLower(tok.text)]:
+			return -1
+		}
+	}
+	return -1
+}
+
+// tableList returns the allowed tables in a stable order.
+func (t *SQLTool) tableList() []string {
+	tables := make([]string, 0, len(t.allowedTables))
+	for table := range t.allowedTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// formatSQLTable formats rows as a pipe-separated table.
+func formatSQLTable(columns []string, rows [][]any, truncated bool) string {
+	var builder strings.Builder
+
+	builder.WriteString(strings.Join(columns, " | "))
+	builder.WriteString("\n")
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			if v == nil {
+				cells[i] = "NULL"
+			} else {
+				cells[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		builder.WriteString(strings.Join(cells, " | "))
+		builder.WriteString("\n")
+	}
+
+	if truncated {
+		builder.WriteString(fmt.Sprintf("(results truncated to %d rows)\n", len(rows)))
+	}
+
+	return builder.String()
+}
+
+// formatSQLJSON formats rows as a JSON array of row objects.
+func formatSQLJSON(columns []string, rows [][]any) (string, error) {
+	objects := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		object := make(map[string]any, len(columns))
+		for i, column := range columns {
+			object[column] = row[i]
+		}
+		objects = append(objects, object)
+	}
+
+	data, err := json.Marshal(objects)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal results: %w", err)
+	}
+	return string(data), nil
+}
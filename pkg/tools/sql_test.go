@@ -0,0 +1,152 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB creates an in-memory SQLite database with sample data
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	statements := []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE secrets (id INTEGER PRIMARY KEY, value TEXT)",
+		"INSERT INTO users (name) VALUES ('Ada'), ('Grace'), ('Linus')",
+		"INSERT INTO secrets (value) VALUES ('hunter2')",
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to set up database: %v", err)
+		}
+	}
+
+	return db
+}
+
+// TestSQLToolSelect tests running read-only queries
+func TestSQLToolSelect(t *testing.T) {
+	db := newTestDB(t)
+
+	tool, err := NewSQLTool(db)
+	if err != nil {
+		t.Fatalf("NewSQLTool() error = %v", err)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{
+		"query": "SELECT id, name FROM users ORDER BY id",
+	})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	want := "id | name\n1 | Ada\n2 | Grace\n3 | Linus\n"
+	if result != want {
+		t.Errorf("Expected table %q, got %q", want, result)
+	}
+
+	jsonTool, err := NewSQLTool(db, WithSQLOutputFormat(SQLOutputJSON), WithSQLMaxRows(1))
+	if err != nil {
+		t.Fatalf("NewSQLTool() error = %v", err)
+	}
+
+	result, err = jsonTool.Execute(context.Background(), map[string]any{
+		"query": "SELECT name FROM users ORDER BY id",
+	})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != `[{"name":"Ada"}]` {
+		t.Errorf("Expected one JSON row, got %v", result)
+	}
+}
+
+// TestSQLToolRowLimit tests that results are truncated to the row limit
+func TestSQLToolRowLimit(t *testing.T) {
+	tool, err := NewSQLTool(newTestDB(t), WithSQLMaxRows(2))
+	if err != nil {
+		t.Fatalf("NewSQLTool() error = %v", err)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"query": "SELECT name FROM users ORDER BY id"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	output := result.(string)
+	if strings.Contains(output, "Linus") || !strings.Contains(output, "truncated to 2 rows") {
+		t.Errorf("Expected results truncated to 2 rows, got %q", output)
+	}
+}
+
+// TestSQLToolGuardrails tests that disallowed queries are rejected
+func TestSQLToolGuardrails(t *testing.T) {
+	db := newTestDB(t)
+
+	tests := []struct {
+		name  string
+		opts  []SQLOption
+		query string
+	}{
+		{name: "delete", query: "DELETE FROM users"},
+		{name: "write in CTE", query: "WITH doomed AS (SELECT id FROM users) DELETE FROM users"},
+		{name: "stacked statements", query: "SELECT 1; DROP TABLE users"},
+		{name: "comment hiding a write", query: "/* SELECT */ DROP TABLE users"},
+		{name: "comment marker in string", query: "SELECT '--'; DELETE FROM users"},
+		{name: "comment marker in identifier", query: `SELECT 1 AS "/*"; DELETE FROM users; SELECT "*/"`},
+		{name: "backslash before quote", query: `SELECT 'a\''; DELETE FROM users; -- '`},
+		{name: "unterminated string", query: "SELECT 'a"},
+		{name: "table not allowed", opts: []SQLOption{WithSQLAllowedTables("users")}, query: "SELECT value FROM secrets"},
+		{name: "joined table not allowed", opts: []SQLOption{WithSQLAllowedTables("users")}, query: "SELECT u.name FROM users u JOIN secrets s ON s.id = u.id"},
+		{name: "comma-joined table not allowed", opts: []SQLOption{WithSQLAllowedTables("users")}, query: "SELECT value FROM users, secrets"},
+		{name: "table after subquery not allowed", opts: []SQLOption{WithSQLAllowedTables("users")}, query: "SELECT value FROM (SELECT id FROM users) u, secrets"},
+		{name: "unparsable table reference", opts: []SQLOption{WithSQLAllowedTables("users")}, query: "SELECT value FROM 'secrets'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, err := NewSQLTool(db, tt.opts...)
+			if err != nil {
+				t.Fatalf("NewSQLTool() error = %v", err)
+			}
+
+			_, err = tool.Execute(context.Background(), map[string]any{"query": tt.query})
+			if !errors.Is(err, ErrSQLNotAllowed) {
+				t.Errorf("Expected ErrSQLNotAllowed, got %v", err)
+			}
+		})
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 3 {
+		t.Errorf("Expected the users table to be untouched, got count %d (err %v)", count, err)
+	}
+
+	// Semicolons and keywords in comments and literals, and queries on
+	// allowed tables, are accepted
+	tool, err := NewSQLTool(db, WithSQLAllowedTables("users"))
+	if err != nil {
+		t.Fatalf("NewSQLTool() error = %v", err)
+	}
+	for _, query := range []string{
+		"SELECT name FROM users",
+		"SELECT 1 /* ; */",
+		"SELECT name FROM users WHERE name <> 'x; DELETE FROM secrets';",
+		"SELECT u.name FROM users u, users v WHERE u.id = v.id ORDER BY u.name, v.name",
+	} {
+		if _, err := tool.Execute(context.Background(), map[string]any{"query": query}); err != nil {
+			t.Errorf("Expected query %q to succeed, got %v", query, err)
+		}
+	}
+}
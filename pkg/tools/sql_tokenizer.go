@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sqlTokenKind is the kind of a token of a SQL query.
+type sqlTokenKind int
+
+const (
+	// sqlWord is a keyword, an unquoted identifier or a number.
+	sqlWord sqlTokenKind = iota
+	// sqlQuotedName is an identifier quoted with "", `` or [].
+	sqlQuotedName
+	// sqlString is a string literal.
+	sqlString
+	// sqlSymbol is any other single character, such as ; ( ) , or .
+	sqlSymbol
+)
+
+// sqlToken is a token of a SQL query. The text of quoted tokens is unquoted.
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+// isWord reports whether the token is the given keyword.
+func (tok sqlToken) isWord(word string) bool {
+	return tok.kind == sqlWord && strings.EqualFold(tok.text, word)
+}
+
+// isSymbol reports whether the token is the given symbol.
+func (tok sqlToken) isSymbol(symbol string) bool {
+	return tok.kind == sqlSymbol && tok.text == symbol
+}
+
+// isName reports whether the token can name a table.
+func (tok sqlToken) isName() bool {
+	return tok.kind == sqlWord || tok.kind == sqlQuotedName
+}
+
+// tokenizeSQL splits query into tokens, dropping whitespace and comments.
+//
+// Dialects disagree on some lexical details, so constructs a database could
+// read differently than this tokenizer are rejected rather than guessed at:
+// backslashes before a closing quote (an escape in MySQL) and MySQL's
+// executable /*! */ comments. A "--" not followed by whitespace is not taken
+// as a comment, as in MySQL, which only exposes more of the query to checks.
+func tokenizeSQL(query string) ([]sqlToken, error) {
+	var tokens []sqlToken
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSQLSpace(c):
+			i++
+
+		case strings.HasPrefix(query[i:], "--") && (i+2 == len(query) || isSQLSpace(query[i+2])):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens, nil
+			}
+			i += end + 1
+
+		case strings.HasPrefix(query[i:], "/*"):
+			if strings.HasPrefix(query[i:], "/*!") {
+				return nil, fmt.Errorf("%w: executable comments are not allowed", ErrSQLNotAllowed)
+			}
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrSQLNotAllowed)
+			}
+			i += 2 + end + 2
+
+		case c == '\'' || c == '"' || c == '`':
+			text, next, err := readSQLQuoted(query, i)
+			if err != nil {
+				return nil, err
+			}
+			kind := sqlQuotedName
+			if c == '\'' {
+				kind = sqlString
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: text})
+			i = next
+
+		case c == '[':
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated identifier", ErrSQLNotAllowed)
+			}
+			tokens = append(tokens, sqlToken{kind: sqlQuotedName, text: query[i+1 : i+end]})
+			i += end + 1
+
+		case isSQLWordByte(c):
+			start := i
+			for i < len(query) && isSQLWordByte(query[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlWord, text: query[start:i]})
+
+		default:
+			tokens = append(tokens, sqlToken{kind: sqlSymbol, text: string(c)})
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+// readSQLQuoted reads the quoted string or identifier starting at query[i],
+// where a doubled quote stands for the quote itself, and returns its
+// unquoted text and the position following it.
+func readSQLQuoted(query string, i int) (string, int, error) {
+	quote := query[i]
+	var builder strings.Builder
+
+	for j := i + 1; j < len(query); j++ {
+		c := query[j]
+		if c == '\\' && j+1 < len(query) && query[j+1] == quote {
+			return "", 0, fmt.Errorf("%w: backslash before a quote is ambiguous", ErrSQLNotAllowed)
+		}
+		if c == quote {
+			if j+1 < len(query) && query[j+1] == quote {
+				builder.WriteByte(quote)
+				j++
+				continue
+			}
+			return builder.String(), j + 1, nil
+		}
+		builder.WriteByte(c)
+	}
+
+	return "", 0, fmt.Errorf("%w: unterminated quoted string", ErrSQLNotAllowed)
+}
+
+// isSQLSpace reports whether c is whitespace.
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// isSQLWordByte reports whether c can be part of a word. Bytes of non-ASCII
+// characters are, as databases accept them in identifiers.
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
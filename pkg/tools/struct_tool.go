@@ -0,0 +1,317 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// StructTool is a tool whose arguments are decoded into a struct.
+type StructTool[I, O any] struct {
+	name        string
+	description string
+	fn          func(ctx context.Context, input I) (O, error)
+	schema      *ToolSchema
+	config      toolConfig
+}
+
+// NewStructTool creates a tool from a function taking its arguments as a
+// struct. Each exported field of I becomes a property of the schema, named by
+// its json tag and described by its desc tag:
+//
+//	type WeatherInput struct {
+//		Location string `json:"location" desc:"City name"`
+//		Celsius  *bool  `json:"celsius" desc:"Use Celsius instead of Fahrenheit"`
+//	}
+//
+// Pointer fields, fields tagged omitempty and fields with a default tag are
+// optional, and all other fields are required. A missing field with a default
+// tag, such as `default:"10"`, takes that value, decoded as JSON unless the
+// field is a string. Nested structs, and slices of them, are described as
+// nested objects.
+//
+// The options of NewFunctionTool apply, with WithParamDefault and
+// WithParamEnum naming fields by their JSON name, except WithParamNames,
+// which is an error.
+func NewStructTool[I, O any](name, description string, fn func(ctx context.Context, input I) (O, error), opts ...ToolOption) (*StructTool[I, O], error) {
+	if name == "" {
+		return nil, fmt.Errorf("tool name cannot be empty")
+	}
+
+	if description == "" {
+		return nil, fmt.Errorf("tool description cannot be empty")
+	}
+
+	if fn == nil {
+		return nil, fmt.Errorf("fn cannot be nil")
+	}
+
+	inputType := reflect.TypeOf((*I)(nil)).Elem()
+	if inputType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("input must be a struct, got %s", inputType.Kind())
+	}
+
+	tool := &StructTool[I, O]{
+		name:        name,
+		description: description,
+		fn:          fn,
+	}
+
+	for _, opt := range opts {
+		opt(&tool.config)
+	}
+
+	if tool.config.paramNames != nil {
+		return nil, fmt.Errorf("parameter names do not apply to struct tools: name fields with json tags")
+	}
+
+	input, err := structProperty(inputType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	tool.schema = &ToolSchema{
+		Type:       "object",
+		Properties: input.Properties,
+		Required:   input.Required,
+	}
+	if err := setFieldDefaults[I](tool.schema, tool.config.paramDefaults); err != nil {
+		return nil, err
+	}
+	if err := setParamEnums(tool.schema, tool.config.paramEnums); err != nil {
+		return nil, err
+	}
+
+	return tool, nil
+}
+
+// Name returns the name of the tool.
+func (t *StructTool[I, O]) Name() string {
+	return t.name
+}
+
+// Description returns a description of what the tool does.
+func (t *StructTool[I, O]) Description() string {
+	return t.description
+}
+
+// Schema returns the JSON schema of the tool.
+func (t *StructTool[I, O]) Schema() *ToolSchema {
+	return t.schema
+}
+
+// OutputSchema returns the declared schema of the tool's output, if any.
+func (t *StructTool[I, O]) OutputSchema() *PropertyDef {
+	return t.config.outputSchema
+}
+
+// Idempotent reports whether the tool may be safely re-invoked.
+func (t *StructTool[I, O]) Idempotent() bool {
+	return !t.config.nonIdempotent
+}
+
+// RenderOutput returns the observation text of the tool's output.
+func (t *StructTool[I, O]) RenderOutput(output any) string {
+	return t.config.renderOutput(output)
+}
+
+// Execute decodes the arguments into the input struct and calls the function.
+func (t *StructTool[I, O]) Execute(ctx context.Context, args map[string]any) (any, error) {
+	// Guard against oversized arguments
+	args, err := t.config.limitArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	args = applyDefaults(t.schema.Properties, args)
+	if err := checkEnums(t.schema.Properties, args); err != nil {
+		return nil, err
+	}
+	if err := checkRequired(t.schema.Properties, t.schema.Required, args, ""); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare arguments: %w", err)
+	}
+
+	var input I
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("failed to prepare arguments: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.call(ctx, input)
+}
+
+// call calls the function, converting a panic into an error wrapping
+// ErrToolPanic.
+func (t *StructTool[I, O]) call(ctx context.Context, input I) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, PanicError(t.name, r)
+		}
+	}()
+
+	output, err := t.fn(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// structProperty describes a struct type as an "object" property.
+func structProperty(structType reflect.Type, seen map[reflect.Type]bool) (PropertyDef, error) {
+	property := PropertyDef{
+		Type:       "object",
+		Properties: make(map[string]PropertyDef),
+	}
+
+	if seen == nil {
+		seen = make(map[reflect.Type]bool)
+	}
+	seen[structType] = true
+	defer delete(seen, structType)
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+
+		// Embedded structs without a name are flattened, as encoding/json does
+		if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+			embedded, err := structProperty(field.Type, seen)
+			if err != nil {
+				return PropertyDef{}, err
+			}
+			for name, def := range embedded.Properties {
+				property.Properties[name] = def
+			}
+			property.Required = append(property.Required, embedded.Required...)
+			continue
+		}
+
+		fieldType := field.Type
+		required := !jsonOmitEmpty(field)
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+			required = false
+		}
+
+		def, err := typeProperty(fieldType, seen)
+		if err != nil {
+			return PropertyDef{}, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		def.Description = field.Tag.Get("desc")
+
+		if tag, ok := field.Tag.Lookup("default"); ok {
+			if def.Default, err = parseDefault(tag, fieldType); err != nil {
+				return PropertyDef{}, fmt.Errorf("field %s: invalid default: %w", field.Name, err)
+			}
+			required = false
+		}
+
+		property.Properties[name] = def
+		if required {
+			property.Required = append(property.Required, name)
+		}
+	}
+
+	return property, nil
+}
+
+// jsonFieldName returns the name of a field in JSON, and false if the field
+// is skipped.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+// jsonOmitEmpty reports whether the field's json tag has the omitempty option.
+func jsonOmitEmpty(field reflect.StructField) bool {
+	_, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return slices.Contains(strings.Split(options, ","), "omitempty")
+}
+
+// parseDefault parses the default tag of a field of type t: strings are taken
+// as is, and other types are decoded as JSON.
+func parseDefault(tag string, t reflect.Type) (any, error) {
+	if t.Kind() == reflect.String {
+		return tag, nil
+	}
+
+	value := reflect.New(t)
+	if err := json.Unmarshal([]byte(tag), value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface(), nil
+}
+
+// setFieldDefaults sets the defaults of the schema's top-level fields, given
+// by WithParamDefault, and removes them from the required fields. Each
+// default must name a field and decode into the field's type.
+func setFieldDefaults[I any](schema *ToolSchema, defaults map[string]any) error {
+	for name, value := range defaults {
+		property, ok := schema.Properties[name]
+		if !ok {
+			return fmt.Errorf("default for unknown parameter: %s", name)
+		}
+
+		data, err := json.Marshal(map[string]any{name: value})
+		if err == nil {
+			var input I
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid default for parameter %s: %w", name, err)
+		}
+
+		property.Default = value
+		schema.Properties[name] = property
+		schema.Required = slices.DeleteFunc(schema.Required, func(required string) bool {
+			return required == name
+		})
+	}
+	return nil
+}
+
+// checkRequired reports the first required property missing from args,
+// including those of nested objects.
+func checkRequired(properties map[string]PropertyDef, required []string, args map[string]any, path string) error {
+	for _, name := range required {
+		if _, ok := args[name]; !ok {
+			return fmt.Errorf("missing required argument: %s%s", path, name)
+		}
+	}
+
+	for name, def := range properties {
+		nested, ok := args[name].(map[string]any)
+		if !ok || def.Type != "object" {
+			continue
+		}
+		if err := checkRequired(def.Properties, def.Required, nested, path+name+"."); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Toolbox is a named collection of tools with unique names, for sharing a
+// canonical set of tools across agents. Tools keeps the order in which tools
+// were added. A Toolbox is safe for concurrent use.
+type Toolbox struct {
+	name  string
+	mu    sync.RWMutex
+	tools []Tool
+}
+
+// NewToolbox creates a toolbox holding the given tools. It fails if two of
+// the tools share a name.
+func NewToolbox(name string, tools ...Tool) (*Toolbox, error) {
+	box := &Toolbox{name: name}
+	for _, tool := range tools {
+		if err := box.Add(tool); err != nil {
+			return nil, err
+		}
+	}
+	return box, nil
+}
+
+// Name returns the name of the toolbox.
+func (b *Toolbox) Name() string {
+	return b.name
+}
+
+// Add adds tool to the toolbox. It fails if the toolbox already holds a tool
+// of the same name.
+func (b *Toolbox) Add(tool Tool) error {
+	if tool == nil {
+		return errors.New("tool cannot be nil")
+	}
+	if tool.Name() == "" {
+		return errors.New("tool name cannot be empty")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.index(tool.Name()) >= 0 {
+		return fmt.Errorf("duplicate tool name: %s", tool.Name())
+	}
+	b.tools = append(b.tools, tool)
+	return nil
+}
+
+// Get returns the tool with the given name, and false if there is none.
+func (b *Toolbox) Get(name string) (Tool, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if i := b.index(name); i >= 0 {
+		return b.tools[i], true
+	}
+	return nil, false
+}
+
+// Remove removes the tool with the given name and reports whether the
+// toolbox held such a tool.
+func (b *Toolbox) Remove(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := b.index(name)
+	if i < 0 {
+		return false
+	}
+	b.tools = append(b.tools[:i:i], b.tools[i+1:]...)
+	return true
+}
+
+// List returns the names of the tools, in the order they were added.
+func (b *Toolbox) List() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names := make([]string, 0, len(b.tools))
+	for _, tool := range b.tools {
+		names = append(names, tool.Name())
+	}
+	return names
+}
+
+// Merge adds the tools of other to the toolbox. If any of them has the name
+// of a tool already in the toolbox, Merge fails without adding any tool.
+func (b *Toolbox) Merge(other *Toolbox) error {
+	incoming := other.Tools()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, tool := range incoming {
+		if b.index(tool.Name()) >= 0 {
+			return fmt.Errorf("duplicate tool name: %s (from toolbox %s)", tool.Name(), other.Name())
+		}
+	}
+	b.tools = append(b.tools, incoming...)
+	return nil
+}
+
+// Tools returns the tools, in the order they were added, for passing to an
+// agent. The returned slice is a copy.
+func (b *Toolbox) Tools() []Tool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return append([]Tool(nil), b.tools...)
+}
+
+// index returns the position of the tool with the given name, or -1.
+func (b *Toolbox) index(name string) int {
+	for i, tool := range b.tools {
+		if tool.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -4,11 +4,25 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime/debug"
 	"strings"
+	"time"
 )
 
+// ErrToolPanic is returned when a tool panics. The error message includes
+// the panic value and the stack of the panicking goroutine.
+var ErrToolPanic = errors.New("tool panicked")
+
+// PanicError converts a recovered panic value into an error wrapping
+// ErrToolPanic. It must be called from the deferred function that recovered,
+// so that the stack is that of the panic.
+func PanicError(name string, recovered any) error {
+	return fmt.Errorf("%w: %s: %v\n%s", ErrToolPanic, name, recovered, debug.Stack())
+}
+
 // Tool represents a function that can be called by an agent.
 type Tool interface {
 	// Name returns the name of the tool.
@@ -33,10 +47,21 @@ type ToolSchema struct {
 
 // PropertyDef defines a property in a tool schema.
 type PropertyDef struct {
-	Type        string   `json:"type"`
+	Type        string   `json:"type,omitempty"`
 	Description string   `json:"description"`
 	Enum        []string `json:"enum,omitempty"`
 	Default     any      `json:"default,omitempty"`
+
+	// Properties and Required describe the fields of an "object" property.
+	Properties map[string]PropertyDef `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+
+	// Items describes the elements of an "array" property.
+	Items *PropertyDef `json:"items,omitempty"`
+
+	// AdditionalProperties describes the values of an "object" property
+	// with arbitrary keys, such as a map.
+	AdditionalProperties *PropertyDef `json:"additionalProperties,omitempty"`
 }
 
 // FunctionTool is a tool that wraps a Go function.
@@ -45,10 +70,38 @@ type FunctionTool[F any] struct {
 	description string
 	fn          F
 	schema      *ToolSchema
+	config      toolConfig
 }
 
-// NewFunctionTool creates a new tool from a function.
-func NewFunctionTool[F any](name, description string, fn F) (*FunctionTool[F], error) {
+// toolConfig holds the optional settings of a FunctionTool.
+type toolConfig struct {
+	outputSchema  *PropertyDef
+	nonIdempotent bool
+	maxArgBytes   int
+	truncateArgs  bool
+	paramNames    []string
+	paramDefaults map[string]any
+	paramEnums    map[string][]string
+
+	outputRenderer func(output any) string
+}
+
+// ToolOption is a functional option for configuring a FunctionTool.
+type ToolOption func(c *toolConfig)
+
+// WithOutputSchema declares the schema of the value the tool returns, so
+// agents can validate the tool's output against its contract.
+func WithOutputSchema(schema PropertyDef) ToolOption {
+	return func(c *toolConfig) {
+		c.outputSchema = &schema
+	}
+}
+
+// NewFunctionTool creates a new tool from a function. A leading
+// context.Context parameter is left out of the schema and receives the
+// execution context. Pointer parameters, and parameters with a default set by
+// WithParamDefault, are optional; all others are required.
+func NewFunctionTool[F any](name, description string, fn F, opts ...ToolOption) (*FunctionTool[F], error) {
 	if name == "" {
 		return nil, fmt.Errorf("tool name cannot be empty")
 	}
@@ -63,18 +116,34 @@ func NewFunctionTool[F any](name, description string, fn F) (*FunctionTool[F], e
 		return nil, fmt.Errorf("fn must be a function, got %s", fnType.Kind())
 	}
 
+	tool := &FunctionTool[F]{
+		name:        name,
+		description: description,
+		fn:          fn,
+	}
+
+	for _, opt := range opts {
+		opt(&tool.config)
+	}
+
+	if err := validateParamNames(fnType, tool.config.paramNames); err != nil {
+		return nil, err
+	}
+
 	// Create tool schema from function signature
-	schema, err := createSchemaFromFunction(fnType)
+	schema, err := createSchemaFromFunction(fnType, tool.config.paramNames)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create schema: %w", err)
 	}
+	if err := setParamDefaults(schema, fnType, tool.config.paramNames, tool.config.paramDefaults); err != nil {
+		return nil, err
+	}
+	if err := setParamEnums(schema, tool.config.paramEnums); err != nil {
+		return nil, err
+	}
+	tool.schema = schema
 
-	return &FunctionTool[F]{
-		name:        name,
-		description: description,
-		fn:          fn,
-		schema:      schema,
-	}, nil
+	return tool, nil
 }
 
 // Name returns the name of the tool.
@@ -92,19 +161,71 @@ func (t *FunctionTool[F]) Schema() *ToolSchema {
 	return t.schema
 }
 
+// OutputSchema returns the declared schema of the tool's output, if any.
+func (t *FunctionTool[F]) OutputSchema() *PropertyDef {
+	return t.config.outputSchema
+}
+
+// Idempotent reports whether the tool may be safely re-invoked.
+func (t *FunctionTool[F]) Idempotent() bool {
+	return !t.config.nonIdempotent
+}
+
+// RenderOutput returns the observation text of the tool's output.
+func (t *FunctionTool[F]) RenderOutput(output any) string {
+	return t.config.renderOutput(output)
+}
+
 // Execute executes the tool with the given arguments.
+//
+// A leading context.Context parameter receives ctx, so the function observes
+// the run's cancellation and deadline. A missing argument takes the default of
+// its property, if any; otherwise a missing pointer parameter receives nil,
+// and a missing required parameter is an error. An argument outside the enum
+// of its property is an error.
+//
+// Parameters of type *Struct receive a pointer to a freshly allocated value
+// decoded from the argument, or the argument itself when it is already a
+// pointer of that type, in which case the function's mutations are visible to
+// the caller. If the function returns nothing but an optional error and takes
+// exactly one struct pointer, Execute returns that pointer so the mutated
+// state becomes the tool's result.
 func (t *FunctionTool[F]) Execute(ctx context.Context, args map[string]any) (any, error) {
 	fnType := reflect.TypeOf(t.fn)
 	fnValue := reflect.ValueOf(t.fn)
 
-	// Prepare arguments
-	callArgs, err := prepareArguments(fnType, args)
+	// Guard against oversized arguments
+	args, err := t.config.limitArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare arguments, filling in defaults of missing ones
+	args = applyDefaults(t.schema.Properties, args)
+	if err := checkEnums(t.schema.Properties, args); err != nil {
+		return nil, err
+	}
+	callArgs, err := prepareArguments(ctx, fnType, t.config.paramNames, args)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare arguments: %w", err)
 	}
 
-	// Call function
-	results := fnValue.Call(callArgs)
+	// Call function, unless the context is already done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := t.call(fnValue, callArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reflect mutations of a state pointer back as the result
+	if state, ok := mutatedState(fnType, callArgs); ok {
+		if len(results) == 1 && !results[0].IsNil() {
+			return nil, results[0].Interface().(error)
+		}
+		return state, nil
+	}
 
 	// Handle results
 	if len(results) == 0 {
@@ -113,7 +234,7 @@ func (t *FunctionTool[F]) Execute(ctx context.Context, args map[string]any) (any
 
 	// Check for error return
 	lastResultIdx := len(results) - 1
-	if fnType.NumOut() > 1 && fnType.Out(lastResultIdx).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+	if fnType.NumOut() > 1 && fnType.Out(lastResultIdx).Implements(errorType) {
 		if !results[lastResultIdx].IsNil() {
 			return nil, results[lastResultIdx].Interface().(error)
 		}
@@ -126,29 +247,100 @@ func (t *FunctionTool[F]) Execute(ctx context.Context, args map[string]any) (any
 	return results[0].Interface(), nil
 }
 
+// call calls the function, converting a panic into an error wrapping
+// ErrToolPanic.
+func (t *FunctionTool[F]) call(fnValue reflect.Value, callArgs []reflect.Value) (results []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicError(t.name, r)
+		}
+	}()
+
+	return fnValue.Call(callArgs), nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// contextParams returns 1 if the function's first parameter is a
+// context.Context, which is passed the execution context instead of an
+// argument, and 0 otherwise.
+func contextParams(fnType reflect.Type) int {
+	if fnType.NumIn() > 0 && fnType.In(0) == contextType {
+		return 1
+	}
+	return 0
+}
+
+// mutatedState returns the struct pointer passed to a function that returns
+// nothing but an optional error and takes exactly one struct pointer, or nil
+// if the pointer was nil.
+func mutatedState(fnType reflect.Type, callArgs []reflect.Value) (any, bool) {
+	switch {
+	case fnType.NumOut() == 0:
+	case fnType.NumOut() == 1 && fnType.Out(0) == errorType:
+	default:
+		return nil, false
+	}
+
+	var state any
+	found := false
+	for i := 0; i < fnType.NumIn(); i++ {
+		if !isStructPointer(fnType.In(i)) {
+			continue
+		}
+		if found {
+			return nil, false // Ambiguous: more than one state pointer
+		}
+		if !callArgs[i].IsNil() {
+			state = callArgs[i].Interface()
+		}
+		found = true
+	}
+
+	return state, found
+}
+
+// isStructPointer reports whether t is a pointer to a struct.
+func isStructPointer(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct
+}
+
 // Helper functions to work with the tool function
 
-func createSchemaFromFunction(fnType reflect.Type) (*ToolSchema, error) {
+func createSchemaFromFunction(fnType reflect.Type, names []string) (*ToolSchema, error) {
 	properties := make(map[string]PropertyDef)
 	required := []string{}
 
-	// Process input parameters
-	for i := 0; i < fnType.NumIn(); i++ {
+	// Process input parameters, except a leading context
+	offset := contextParams(fnType)
+	for i := offset; i < fnType.NumIn(); i++ {
 		paramType := fnType.In(i)
-		paramName := fmt.Sprintf("arg%d", i)
+		paramName := parameterName(names, i-offset)
+
+		// Pointer parameters are optional
+		optional := paramType.Kind() == reflect.Ptr
+		if optional {
+			paramType = paramType.Elem()
+		}
 
 		// Map Go types to JSON schema types
-		jsonType, err := goTypeToJSONType(paramType)
+		if _, err := goTypeToJSONType(paramType); err != nil {
+			return nil, fmt.Errorf("unsupported type: %s", fnType.In(i).String())
+		}
+
+		property, err := typeProperty(paramType, nil)
 		if err != nil {
 			return nil, err
 		}
+		property.Description = fmt.Sprintf("Parameter %d of type %s", i-offset, fnType.In(i).String())
 
-		properties[paramName] = PropertyDef{
-			Type:        jsonType,
-			Description: fmt.Sprintf("Parameter %d of type %s", i, paramType.String()),
-		}
+		properties[paramName] = property
 
-		required = append(required, paramName)
+		if !optional {
+			required = append(required, paramName)
+		}
 	}
 
 	return &ToolSchema{
@@ -173,23 +365,99 @@ func goTypeToJSONType(t reflect.Type) (string, error) {
 		return "array", nil
 	case reflect.Map, reflect.Struct:
 		return "object", nil
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Struct {
+			return "object", nil
+		}
+		return "", fmt.Errorf("unsupported type: %s", t.String())
 	default:
 		return "", fmt.Errorf("unsupported type: %s", t.String())
 	}
 }
 
-func prepareArguments(fnType reflect.Type, args map[string]any) ([]reflect.Value, error) {
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeProperty describes a Go type as a property, including the fields of
+// structs, the elements of slices and the values of maps. Interface types,
+// which may hold any value, are left undescribed. seen holds the structs being
+// described, so that a recursive type is described as a plain object where it
+// refers to itself.
+func typeProperty(t reflect.Type, seen map[reflect.Type]bool) (PropertyDef, error) {
+	switch {
+	case t == timeType:
+		return PropertyDef{Type: "string"}, nil
+	case t.Kind() == reflect.Interface:
+		return PropertyDef{}, nil
+	case t.Kind() == reflect.Struct:
+		if seen[t] {
+			return PropertyDef{Type: "object"}, nil
+		}
+		return structProperty(t, seen)
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		property := PropertyDef{Type: "array"}
+		items, err := elemProperty(t.Elem(), seen)
+		if err != nil {
+			return PropertyDef{}, err
+		}
+		property.Items = items
+		return property, nil
+	case t.Kind() == reflect.Map:
+		property := PropertyDef{Type: "object"}
+		values, err := elemProperty(t.Elem(), seen)
+		if err != nil {
+			return PropertyDef{}, err
+		}
+		property.AdditionalProperties = values
+		return property, nil
+	}
+
+	jsonType, err := goTypeToJSONType(t)
+	if err != nil {
+		return PropertyDef{}, err
+	}
+	return PropertyDef{Type: jsonType}, nil
+}
+
+// elemProperty describes the element type of a slice or map, or returns nil
+// if the elements may be of any type.
+func elemProperty(t reflect.Type, seen map[reflect.Type]bool) (*PropertyDef, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return nil, nil
+	}
+
+	property, err := typeProperty(t, seen)
+	if err != nil {
+		return nil, err
+	}
+	return &property, nil
+}
+
+func prepareArguments(ctx context.Context, fnType reflect.Type, names []string, args map[string]any) ([]reflect.Value, error) {
 	callArgs := make([]reflect.Value, fnType.NumIn())
 
-	// For each parameter of the function
-	for i := 0; i < fnType.NumIn(); i++ {
+	// Pass the context to a leading context parameter
+	offset := contextParams(fnType)
+	if offset == 1 {
+		callArgs[0] = reflect.ValueOf(&ctx).Elem()
+	}
+
+	// For each other parameter of the function
+	for i := offset; i < fnType.NumIn(); i++ {
 		paramType := fnType.In(i)
-		paramName := fmt.Sprintf("arg%d", i)
+		paramName := parameterName(names, i-offset)
 
-		// Find the corresponding argument
+		// Find the corresponding argument; optional pointer parameters
+		// receive nil when it is absent
 		arg, ok := args[paramName]
 		if !ok {
-			return nil, fmt.Errorf("missing required argument: %s", paramName)
+			if paramType.Kind() != reflect.Ptr {
+				return nil, fmt.Errorf("missing required argument: %s", paramName)
+			}
+			callArgs[i] = reflect.Zero(paramType)
+			continue
 		}
 
 		// Convert argument to the correct type
@@ -205,8 +473,11 @@ func prepareArguments(fnType reflect.Type, args map[string]any) ([]reflect.Value
 }
 
 func convertArgument(arg any, targetType reflect.Type) (reflect.Value, error) {
-	// Handle nil
+	// Handle nil, allocating a zero value for struct pointers
 	if arg == nil {
+		if isStructPointer(targetType) {
+			return reflect.New(targetType.Elem()), nil
+		}
 		return reflect.Zero(targetType), nil
 	}
 
@@ -222,7 +493,8 @@ func convertArgument(arg any, targetType reflect.Type) (reflect.Value, error) {
 		return reflect.Value{}, fmt.Errorf("failed to marshal argument: %w", err)
 	}
 
-	// Create a new instance of the target type
+	// Create a new instance of the target type; for struct pointers the
+	// pointee is allocated and decoded into
 	newValue := reflect.New(targetType).Interface()
 
 	if err := json.Unmarshal(jsonData, newValue); err != nil {
@@ -233,8 +505,8 @@ func convertArgument(arg any, targetType reflect.Type) (reflect.Value, error) {
 }
 
 // DecorateFunction adds metadata to a function and returns a FunctionTool.
-func DecorateFunction[F any](fn F, name, description string) (*FunctionTool[F], error) {
-	return NewFunctionTool(name, description, fn)
+func DecorateFunction[F any](fn F, name, description string, opts ...ToolOption) (*FunctionTool[F], error) {
+	return NewFunctionTool(name, description, fn, opts...)
 }
 
 // CreateTool is a decorator-style function that creates a new FunctionTool.
@@ -243,9 +515,9 @@ func DecorateFunction[F any](fn F, name, description string) (*FunctionTool[F],
 //	var GetWeather = tools.CreateTool("get_weather", "Get the current weather at the given location.")(func(location string) string {
 //		// implementation
 //	})
-func CreateTool[F any](name, description string) func(F) *FunctionTool[F] {
+func CreateTool[F any](name, description string, opts ...ToolOption) func(F) *FunctionTool[F] {
 	return func(fn F) *FunctionTool[F] {
-		tool, err := NewFunctionTool(name, description, fn)
+		tool, err := NewFunctionTool(name, description, fn, opts...)
 		if err != nil {
 			panic(fmt.Sprintf("failed to create tool: %v", err))
 		}
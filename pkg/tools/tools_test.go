@@ -2,10 +2,13 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestCreateTool tests the CreateTool function with generic type parameters
@@ -190,6 +193,327 @@ func TestTypeInference(t *testing.T) {
 	}
 }
 
+// TestNestedSchema tests that struct, slice and map parameters are described
+// recursively
+func TestNestedSchema(t *testing.T) {
+	type Person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	tagPeople := func(tags []string, people []Person, scores map[string]int) string {
+		return ""
+	}
+
+	tool, err := NewFunctionTool("tag_people", "Tags people", tagPeople, WithParamNames("tags", "people", "scores"))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	properties := tool.Schema().Properties
+
+	tags := properties["tags"]
+	if tags.Type != "array" || tags.Items == nil || tags.Items.Type != "string" {
+		t.Errorf("Expected tags to be an array of strings, got %+v", tags)
+	}
+
+	people := properties["people"]
+	if people.Type != "array" || people.Items == nil || people.Items.Type != "object" {
+		t.Fatalf("Expected people to be an array of objects, got %+v", people)
+	}
+	if people.Items.Properties["name"].Type != "string" || people.Items.Properties["age"].Type != "integer" {
+		t.Errorf("Expected person properties name and age, got %+v", people.Items.Properties)
+	}
+	if !reflect.DeepEqual(people.Items.Required, []string{"name", "age"}) {
+		t.Errorf("Expected person fields to be required, got %v", people.Items.Required)
+	}
+
+	scores := properties["scores"]
+	if scores.Type != "object" || scores.AdditionalProperties == nil || scores.AdditionalProperties.Type != "integer" {
+		t.Errorf("Expected scores to be an object of integers, got %+v", scores)
+	}
+
+	data, err := json.Marshal(scores)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if !strings.Contains(string(data), `"additionalProperties":{"type":"integer"`) {
+		t.Errorf("Expected additionalProperties in JSON, got %s", data)
+	}
+
+	// Elements of any type are left undescribed
+	anyTool, err := NewFunctionTool("any", "Takes anything", func(values []any) int { return len(values) })
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	if items := anyTool.Schema().Properties["arg0"].Items; items != nil {
+		t.Errorf("Expected no items for []any, got %+v", items)
+	}
+
+	// Recursive types are described as plain objects where they recur
+	type Node struct {
+		Value    string `json:"value"`
+		Children []Node `json:"children"`
+	}
+	treeTool, err := NewFunctionTool("tree", "Walks a tree", func(root Node) string { return root.Value })
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	children := treeTool.Schema().Properties["arg0"].Properties["children"]
+	if children.Items == nil || children.Items.Type != "object" || children.Items.Properties != nil {
+		t.Errorf("Expected children to be an array of plain objects, got %+v", children)
+	}
+}
+
+// TestContextParameter tests that a leading context parameter is left out of
+// the schema and receives the execution context
+func TestContextParameter(t *testing.T) {
+	search := func(ctx context.Context, query string) (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return "results for " + query, nil
+	}
+
+	tool, err := NewFunctionTool("search", "Searches the web", search, WithParamNames("query"))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+
+	schema := tool.Schema()
+	if len(schema.Properties) != 1 || !reflect.DeepEqual(schema.Required, []string{"query"}) {
+		t.Errorf("Expected only the query parameter in the schema, got %v", schema.Properties)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"query": "go"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "results for go" {
+		t.Errorf("Expected 'results for go', got %v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tool.Execute(ctx, map[string]any{"query": "go"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// Without parameter names, the arguments are numbered after the context
+	unnamed, err := NewFunctionTool("search", "Searches the web", search)
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	if _, ok := unnamed.Schema().Properties["arg0"]; !ok {
+		t.Errorf("Expected property 'arg0', got %v", unnamed.Schema().Properties)
+	}
+}
+
+// TestOptionalParams tests that pointer parameters and parameters with
+// defaults are optional, while other parameters stay required
+func TestOptionalParams(t *testing.T) {
+	search := func(query string, limit int, lang *string) string {
+		result := fmt.Sprintf("%s:%d", query, limit)
+		if lang != nil {
+			result += ":" + *lang
+		}
+		return result
+	}
+
+	tool, err := NewFunctionTool("search", "Searches the web", search,
+		WithParamNames("query", "limit", "lang"), WithParamDefault("limit", 10))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+
+	schema := tool.Schema()
+	if !reflect.DeepEqual(schema.Required, []string{"query"}) {
+		t.Errorf("Expected only query to be required, got %v", schema.Required)
+	}
+	if schema.Properties["limit"].Default != 10 {
+		t.Errorf("Expected limit to default to 10, got %v", schema.Properties["limit"].Default)
+	}
+	if schema.Properties["lang"].Type != "string" {
+		t.Errorf("Expected lang to have type 'string', got '%s'", schema.Properties["lang"].Type)
+	}
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"missing optional args", map[string]any{"query": "go"}, "go:10"},
+		{"all args", map[string]any{"query": "go", "limit": 3, "lang": "en"}, "go:3:en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tool.Execute(context.Background(), tt.args)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if result != tt.want {
+				t.Errorf("Expected %q, got %v", tt.want, result)
+			}
+		})
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]any{"limit": 3}); err == nil || !strings.Contains(err.Error(), "missing required argument: query") {
+		t.Errorf("Expected a missing required argument error, got %v", err)
+	}
+
+	for _, opt := range []ToolOption{WithParamDefault("unknown", 1), WithParamDefault("limit", "ten")} {
+		if _, err := NewFunctionTool("search", "Searches the web", search, WithParamNames("query", "limit", "lang"), opt); err == nil {
+			t.Error("Expected an error for an invalid default")
+		}
+	}
+
+	// Struct tools honor omitempty and default tags
+	type searchInput struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit" default:"5"`
+		Safe  bool   `json:"safe,omitempty"`
+	}
+	structTool, err := NewStructTool("search", "Searches the web", func(ctx context.Context, in searchInput) (string, error) {
+		return fmt.Sprintf("%s:%d:%v", in.Query, in.Limit, in.Safe), nil
+	})
+	if err != nil {
+		t.Fatalf("NewStructTool() error = %v", err)
+	}
+	if !reflect.DeepEqual(structTool.Schema().Required, []string{"query"}) {
+		t.Errorf("Expected only query to be required, got %v", structTool.Schema().Required)
+	}
+	result, err := structTool.Execute(context.Background(), map[string]any{"query": "go"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "go:5:false" {
+		t.Errorf("Expected 'go:5:false', got %v", result)
+	}
+	if _, err := structTool.Execute(context.Background(), map[string]any{"limit": 1}); err == nil {
+		t.Error("Expected an error for a missing required field")
+	}
+
+	// WithParamDefault applies to struct fields by their JSON name
+	structTool, err = NewStructTool("search", "Searches the web", func(ctx context.Context, in searchInput) (string, error) {
+		return fmt.Sprintf("%s:%d:%v", in.Query, in.Limit, in.Safe), nil
+	}, WithParamDefault("query", "news"), WithParamDefault("limit", 20))
+	if err != nil {
+		t.Fatalf("NewStructTool() error = %v", err)
+	}
+	if len(structTool.Schema().Required) != 0 {
+		t.Errorf("Expected no required fields, got %v", structTool.Schema().Required)
+	}
+	result, err = structTool.Execute(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "news:20:false" {
+		t.Errorf("Expected 'news:20:false', got %v", result)
+	}
+
+	for _, opt := range []ToolOption{WithParamDefault("unknown", 1), WithParamDefault("limit", "ten"), WithParamNames("query")} {
+		_, err := NewStructTool("search", "Searches the web", func(ctx context.Context, in searchInput) (string, error) {
+			return in.Query, nil
+		}, opt)
+		if err == nil {
+			t.Error("Expected an error for an invalid struct tool option")
+		}
+	}
+}
+
+// TestParamEnum tests that arguments are validated against the enum of
+// their parameter
+func TestParamEnum(t *testing.T) {
+	convert := func(celsius float64, unit string) string {
+		return fmt.Sprintf("%.0f %s", celsius, unit)
+	}
+
+	tool, err := NewFunctionTool("convert", "Converts a temperature", convert,
+		WithParamNames("celsius", "unit"), WithParamEnum("unit", "kelvin", "fahrenheit"))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+
+	if enum := tool.Schema().Properties["unit"].Enum; !reflect.DeepEqual(enum, []string{"kelvin", "fahrenheit"}) {
+		t.Errorf("Expected the enum in the schema, got %v", enum)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"celsius": 20, "unit": "kelvin"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "20 kelvin" {
+		t.Errorf("Expected '20 kelvin', got %v", result)
+	}
+
+	_, err = tool.Execute(context.Background(), map[string]any{"celsius": 20, "unit": "rankine"})
+	if err == nil || !strings.Contains(err.Error(), `invalid value "rankine" for argument unit: must be one of kelvin, fahrenheit`) {
+		t.Errorf("Expected an invalid value error, got %v", err)
+	}
+
+	invalid := [][]ToolOption{
+		{WithParamEnum("unknown", "a")},
+		{WithParamEnum("unit")},
+		{WithParamDefault("unit", "celsius"), WithParamEnum("unit", "kelvin")},
+	}
+	for _, opts := range invalid {
+		opts = append([]ToolOption{WithParamNames("celsius", "unit")}, opts...)
+		if _, err := NewFunctionTool("convert", "Converts a temperature", convert, opts...); err == nil {
+			t.Error("Expected an error for an invalid enum")
+		}
+	}
+
+	// Struct tools restrict fields by their JSON name
+	type convertInput struct {
+		Celsius float64 `json:"celsius"`
+		Unit    string  `json:"unit"`
+	}
+	structTool, err := NewStructTool("convert", "Converts a temperature", func(ctx context.Context, in convertInput) (string, error) {
+		return convert(in.Celsius, in.Unit), nil
+	}, WithParamEnum("unit", "c", "f"))
+	if err != nil {
+		t.Fatalf("NewStructTool() error = %v", err)
+	}
+	if enum := structTool.Schema().Properties["unit"].Enum; !reflect.DeepEqual(enum, []string{"c", "f"}) {
+		t.Errorf("Expected the enum in the struct tool schema, got %v", enum)
+	}
+	if result, err := structTool.Execute(context.Background(), map[string]any{"celsius": 20, "unit": "f"}); err != nil || result != "20 f" {
+		t.Errorf("Expected '20 f', got %v (err %v)", result, err)
+	}
+	_, err = structTool.Execute(context.Background(), map[string]any{"celsius": 20, "unit": "kelvin"})
+	if err == nil || !strings.Contains(err.Error(), `invalid value "kelvin" for argument unit`) {
+		t.Errorf("Expected an invalid value error from the struct tool, got %v", err)
+	}
+	if _, err := NewStructTool("convert", "Converts a temperature", func(ctx context.Context, in convertInput) (string, error) {
+		return in.Unit, nil
+	}, WithParamEnum("scale", "c")); err == nil {
+		t.Error("Expected an error for an enum of an unknown field")
+	}
+}
+
+// TestFinalAnswerTool tests the builtin final_answer tool
+func TestFinalAnswerTool(t *testing.T) {
+	tool := FinalAnswerTool()
+
+	if tool.Name() != FinalAnswerToolName {
+		t.Errorf("Expected name '%s', got '%s'", FinalAnswerToolName, tool.Name())
+	}
+	if !reflect.DeepEqual(tool.Schema().Required, []string{"answer"}) {
+		t.Errorf("Expected answer to be required, got %v", tool.Schema().Required)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"answer": "42"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "42" {
+		t.Errorf("Expected '42', got %v", result)
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]any{}); err == nil {
+		t.Error("Expected an error for a missing answer")
+	}
+}
+
 // TestFormatToolDescription tests the tool description formatting
 func TestFormatToolDescription(t *testing.T) {
 	// Create a simple tool
@@ -218,3 +542,646 @@ func TestFormatToolDescription(t *testing.T) {
 		t.Error("Expected description to list parameters")
 	}
 }
+
+// TestValidateOutput tests validating tool outputs against a declared schema
+func TestValidateOutput(t *testing.T) {
+	type person struct {
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		name    string
+		schema  *PropertyDef
+		value   any
+		wantErr bool
+	}{
+		{name: "no schema", schema: nil, value: 42},
+		{name: "string", schema: &PropertyDef{Type: "string"}, value: "ok"},
+		{name: "string mismatch", schema: &PropertyDef{Type: "string"}, value: 42, wantErr: true},
+		{name: "integer", schema: &PropertyDef{Type: "integer"}, value: 42},
+		{name: "integer mismatch", schema: &PropertyDef{Type: "integer"}, value: 4.2, wantErr: true},
+		{name: "number", schema: &PropertyDef{Type: "number"}, value: float32(4.2)},
+		{name: "boolean", schema: &PropertyDef{Type: "boolean"}, value: true},
+		{name: "array", schema: &PropertyDef{Type: "array"}, value: []string{"a"}},
+		{name: "object from struct", schema: &PropertyDef{Type: "object"}, value: person{Name: "Ada"}},
+		{name: "object mismatch", schema: &PropertyDef{Type: "object"}, value: []int{1}, wantErr: true},
+		{name: "enum", schema: &PropertyDef{Type: "string", Enum: []string{"a", "b"}}, value: "b"},
+		{name: "enum mismatch", schema: &PropertyDef{Type: "string", Enum: []string{"a", "b"}}, value: "c", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOutput(tt.schema, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestWithOutputSchema tests declaring an output schema on a function tool
+func TestWithOutputSchema(t *testing.T) {
+	tool := CreateTool[func(int) int]("double", "Doubles a number",
+		WithOutputSchema(PropertyDef{Type: "integer", Description: "The doubled number"}),
+	)(func(n int) int { return n * 2 })
+
+	var provider OutputSchemaProvider = tool
+	schema := provider.OutputSchema()
+	if schema == nil || schema.Type != "integer" {
+		t.Fatalf("Expected integer output schema, got %+v", schema)
+	}
+
+	plain := CreateTool[func(int) int]("double", "Doubles a number")(func(n int) int { return n * 2 })
+	if plain.OutputSchema() != nil {
+		t.Error("Expected no output schema when none is declared")
+	}
+}
+
+// TestStructPointerArguments tests tools that mutate a state object passed by pointer
+func TestStructPointerArguments(t *testing.T) {
+	type counter struct {
+		Count int `json:"count"`
+	}
+
+	increment := CreateTool[func(*counter, int)]("increment", "Increments a counter")(
+		func(c *counter, by int) { c.Count += by },
+	)
+
+	if increment.Schema().Properties["arg0"].Type != "object" {
+		t.Errorf("Expected struct pointer parameter to have type 'object', got '%s'",
+			increment.Schema().Properties["arg0"].Type)
+	}
+
+	t.Run("pointer argument is mutated in place", func(t *testing.T) {
+		state := &counter{Count: 1}
+		result, err := increment.Execute(context.Background(), map[string]any{"arg0": state, "arg1": 2})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if state.Count != 3 {
+			t.Errorf("Expected caller's state to be mutated to 3, got %d", state.Count)
+		}
+		if result != state {
+			t.Errorf("Expected the mutated state pointer as the result, got %v", result)
+		}
+	})
+
+	t.Run("decoded argument is returned", func(t *testing.T) {
+		result, err := increment.Execute(context.Background(), map[string]any{
+			"arg0": map[string]any{"count": 5},
+			"arg1": 1,
+		})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		state, ok := result.(*counter)
+		if !ok || state.Count != 6 {
+			t.Errorf("Expected mutated state with count 6, got %v", result)
+		}
+	})
+
+	t.Run("nil argument is allocated", func(t *testing.T) {
+		result, err := increment.Execute(context.Background(), map[string]any{"arg0": nil, "arg1": 4})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if state, ok := result.(*counter); !ok || state.Count != 4 {
+			t.Errorf("Expected allocated state with count 4, got %v", result)
+		}
+	})
+
+	t.Run("absent argument is nil", func(t *testing.T) {
+		describe := CreateTool[func(string, *counter) string]("describe", "Describes a counter")(
+			func(name string, c *counter) string {
+				if c == nil {
+					return name + ": none"
+				}
+				return fmt.Sprintf("%s: %d", name, c.Count)
+			},
+		)
+
+		result, err := describe.Execute(context.Background(), map[string]any{"arg0": "clicks"})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if result != "clicks: none" {
+			t.Errorf("Expected a nil pointer for the absent argument, got %v", result)
+		}
+
+		reset := CreateTool[func(*counter)]("reset", "Resets a counter")(
+			func(c *counter) {
+				if c != nil {
+					c.Count = 0
+				}
+			},
+		)
+		result, err = reset.Execute(context.Background(), map[string]any{})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if result != nil {
+			t.Errorf("Expected no state for an absent state argument, got %v", result)
+		}
+	})
+
+	t.Run("error is returned", func(t *testing.T) {
+		failing := CreateTool[func(*counter) error]("fail", "Always fails")(
+			func(c *counter) error { return fmt.Errorf("boom") },
+		)
+		if _, err := failing.Execute(context.Background(), map[string]any{"arg0": nil}); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+// TestIdempotency tests declaring tools non-idempotent
+func TestIdempotency(t *testing.T) {
+	send := func(to string) string { return "sent to " + to }
+
+	if !IsIdempotent(CreateTool[func(string) string]("send", "Sends")(send)) {
+		t.Error("Expected tools to be idempotent by default")
+	}
+
+	declared := CreateTool[func(string) string]("send", "Sends", WithIdempotent(false))(send)
+	if IsIdempotent(declared) {
+		t.Error("Expected WithIdempotent(false) to declare the tool non-idempotent")
+	}
+
+	wrapped := NonIdempotent(CreateTool[func(string) string]("send", "Sends")(send))
+	if IsIdempotent(wrapped) {
+		t.Error("Expected NonIdempotent to declare the tool non-idempotent")
+	}
+	if wrapped.Name() != "send" {
+		t.Errorf("Expected the wrapper to keep the tool name, got '%s'", wrapped.Name())
+	}
+
+	list := func() []string { return []string{"a", "b"} }
+	schema := PropertyDef{Type: "array", Items: &PropertyDef{Type: "string"}}
+	rendered := NonIdempotent(CreateTool[func() []string]("list", "Lists",
+		WithOutputSchema(schema),
+		WithOutputRenderer(func(output any) string { return strings.Join(output.([]string), "\n") }),
+	)(list))
+	if provider, ok := rendered.(OutputSchemaProvider); !ok || !reflect.DeepEqual(provider.OutputSchema(), &schema) {
+		t.Error("Expected the wrapper to keep the output schema")
+	}
+	if got := RenderOutput(rendered, []string{"a", "b"}); got != "a\nb" {
+		t.Errorf("Expected the wrapper to keep the output renderer, got %q", got)
+	}
+}
+
+// TestAsyncJobs tests starting asynchronous jobs and checking their results
+func TestAsyncJobs(t *testing.T) {
+	registry := NewJobRegistry()
+	defer registry.Close()
+	ctx := ContextWithJobRegistry(context.Background(), registry)
+
+	release := make(chan struct{})
+	pending, err := StartJob(ctx, func(ctx context.Context) (any, error) {
+		<-release
+		return "render complete", nil
+	})
+	if err != nil {
+		t.Fatalf("StartJob() error = %v", err)
+	}
+
+	check := NewCheckTool()
+
+	result, err := check.Execute(ctx, map[string]any{"id": pending.ID})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if !strings.Contains(fmt.Sprint(result), "still running") {
+		t.Errorf("Expected the job to be running, got %v", result)
+	}
+
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		result, err = check.Execute(ctx, map[string]any{"id": pending.ID})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if result == "render complete" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the job to complete, got %v", result)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := check.Execute(ctx, map[string]any{"id": "job-42"}); err == nil {
+		t.Error("Expected an error for an unknown job")
+	}
+	if _, err := StartJob(context.Background(), nil); err != ErrNoJobRegistry {
+		t.Errorf("Expected ErrNoJobRegistry without a registry, got %v", err)
+	}
+}
+
+// TestMaxArgBytes tests rejecting and truncating oversized arguments
+func TestMaxArgBytes(t *testing.T) {
+	echo := func(s string) string { return s }
+	count := func(items []string) int { return len(items) }
+
+	t.Run("reject string", func(t *testing.T) {
+		tool := CreateTool[func(string) string]("echo", "Echoes", WithMaxArgBytes(8))(echo)
+
+		_, err := tool.Execute(context.Background(), map[string]any{"arg0": strings.Repeat("x", 1024)})
+		if err == nil || !strings.Contains(err.Error(), "exceeds the limit of 8 bytes") {
+			t.Errorf("Expected a size limit error, got %v", err)
+		}
+
+		if result, err := tool.Execute(context.Background(), map[string]any{"arg0": "short"}); err != nil || result != "short" {
+			t.Errorf("Expected arguments within the limit to pass, got %v, %v", result, err)
+		}
+	})
+
+	t.Run("truncate string", func(t *testing.T) {
+		tool := CreateTool[func(string) string]("echo", "Echoes", WithMaxArgBytes(5), WithArgTruncation(true))(echo)
+
+		result, err := tool.Execute(context.Background(), map[string]any{"arg0": "héllo world"})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if result != "héll" {
+			t.Errorf("Expected truncation at a rune boundary to 'héll', got %q", result)
+		}
+	})
+
+	t.Run("reject array", func(t *testing.T) {
+		tool := CreateTool[func([]string) int]("count", "Counts", WithMaxArgBytes(16))(count)
+
+		_, err := tool.Execute(context.Background(), map[string]any{"arg0": []any{"alpha", "beta", "gamma"}})
+		if err == nil {
+			t.Error("Expected a size limit error for an oversized array")
+		}
+	})
+
+	t.Run("truncate array", func(t *testing.T) {
+		tool := CreateTool[func([]string) int]("count", "Counts", WithMaxArgBytes(16), WithArgTruncation(true))(count)
+
+		result, err := tool.Execute(context.Background(), map[string]any{"arg0": []any{"alpha", "beta", "gamma"}})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if result != 2 {
+			t.Errorf("Expected the array truncated to 2 elements, got %v", result)
+		}
+	})
+
+	t.Run("truncate large array", func(t *testing.T) {
+		tool := CreateTool[func([]string) int]("count", "Counts", WithMaxArgBytes(1000), WithArgTruncation(true))(count)
+
+		items := make([]any, 100000)
+		for i := range items {
+			items[i] = "x"
+		}
+		result, err := tool.Execute(context.Background(), map[string]any{"arg0": items})
+		if err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		// ["x","x",...] holds 249 elements in 1000 bytes
+		if result != 249 {
+			t.Errorf("Expected the array truncated to 249 elements, got %v", result)
+		}
+	})
+}
+
+// TestToolPanic tests that a panicking function is reported as an error
+func TestToolPanic(t *testing.T) {
+	tool := CreateTool[func(string) string]("explode", "Panics")(func(s string) string {
+		var m map[string]int
+		m[s] = 1
+		return s
+	})
+
+	result, err := tool.Execute(context.Background(), map[string]any{"arg0": "boom"})
+	if !errors.Is(err, ErrToolPanic) {
+		t.Fatalf("Expected ErrToolPanic, got %v", err)
+	}
+	if result != nil {
+		t.Errorf("Expected no result, got %v", result)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "explode") || !strings.Contains(msg, "assignment to entry in nil map") ||
+		!strings.Contains(msg, "goroutine") {
+		t.Errorf("Expected the tool name, panic value and stack in the error, got %q", msg)
+	}
+}
+
+// TestParamNames tests that named parameters are used in the schema and
+// when resolving arguments
+func TestParamNames(t *testing.T) {
+	weather := func(location string, celsius bool) string {
+		if celsius {
+			return location + ": 21C"
+		}
+		return location + ": 70F"
+	}
+
+	tool, err := NewFunctionTool("weather", "Gets the weather", weather, WithParamNames("location", "celsius"))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+
+	schema := tool.Schema()
+	if _, ok := schema.Properties["location"]; !ok {
+		t.Errorf("Expected schema to have property 'location', got %v", schema.Properties)
+	}
+	if _, ok := schema.Properties["arg0"]; ok {
+		t.Error("Expected no positional property names")
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"location", "celsius"}) {
+		t.Errorf("Expected the named parameters to be required, got %v", schema.Required)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"location": "Paris", "celsius": true})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "Paris: 21C" {
+		t.Errorf("Expected 'Paris: 21C', got %v", result)
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]any{"arg0": "Paris", "arg1": true}); err == nil {
+		t.Error("Expected an error for positional argument names")
+	}
+
+	for _, names := range [][]string{{"location"}, {"location", "location"}, {"location", ""}} {
+		if _, err := NewFunctionTool("weather", "Gets the weather", weather, WithParamNames(names...)); err == nil {
+			t.Errorf("Expected an error for parameter names %q", names)
+		}
+	}
+}
+
+// TestStructTool tests schemas and argument decoding of struct-based tools
+func TestStructTool(t *testing.T) {
+	type Address struct {
+		City    string  `json:"city" desc:"City name"`
+		Country *string `json:"country,omitempty" desc:"Country code"`
+	}
+	type Input struct {
+		Name     string    `json:"name" desc:"Person to greet"`
+		Address  Address   `json:"address" desc:"Where the person lives"`
+		Tags     []string  `json:"tags" desc:"Labels"`
+		Nickname *string   `json:"nickname" desc:"Optional nickname"`
+		Visits   []Address `json:"visits"`
+		internal string
+	}
+
+	greet := func(ctx context.Context, in Input) (string, error) {
+		greeting := "Hello, " + in.Name + " from " + in.Address.City
+		if in.Nickname != nil {
+			greeting += " aka " + *in.Nickname
+		}
+		return greeting, nil
+	}
+
+	tool, err := NewStructTool("greet", "Greets a person", greet)
+	if err != nil {
+		t.Fatalf("NewStructTool() error = %v", err)
+	}
+
+	schema := tool.Schema()
+	if !reflect.DeepEqual(schema.Required, []string{"name", "address", "tags", "visits"}) {
+		t.Errorf("Expected the non-pointer fields to be required, got %v", schema.Required)
+	}
+	if name := schema.Properties["name"]; name.Type != "string" || name.Description != "Person to greet" {
+		t.Errorf("Unexpected name property: %+v", name)
+	}
+	if nickname, ok := schema.Properties["nickname"]; !ok || nickname.Description != "Optional nickname" {
+		t.Errorf("Expected an optional nickname property, got %+v", schema.Properties)
+	}
+	if _, ok := schema.Properties["internal"]; ok {
+		t.Error("Expected unexported fields to be skipped")
+	}
+
+	address := schema.Properties["address"]
+	if address.Type != "object" || address.Properties["city"].Description != "City name" ||
+		!reflect.DeepEqual(address.Required, []string{"city"}) {
+		t.Errorf("Unexpected nested address property: %+v", address)
+	}
+	if tags := schema.Properties["tags"]; tags.Type != "array" || tags.Items == nil || tags.Items.Type != "string" {
+		t.Errorf("Unexpected tags property: %+v", tags)
+	}
+	if visits := schema.Properties["visits"]; visits.Items == nil || visits.Items.Properties["country"].Type != "string" {
+		t.Errorf("Unexpected visits property: %+v", visits)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{
+		"name":     "Ada",
+		"address":  map[string]any{"city": "London"},
+		"tags":     []any{"math"},
+		"visits":   []any{},
+		"nickname": "Countess",
+	})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "Hello, Ada from London aka Countess" {
+		t.Errorf("Unexpected result: %v", result)
+	}
+
+	_, err = tool.Execute(context.Background(), map[string]any{
+		"name":    "Ada",
+		"address": map[string]any{"country": "UK"},
+		"tags":    []any{},
+		"visits":  []any{},
+	})
+	if err == nil || !strings.Contains(err.Error(), "address.city") {
+		t.Errorf("Expected a missing nested argument error, got %v", err)
+	}
+
+	if _, err := NewStructTool("bad", "Takes a string", func(ctx context.Context, in string) (string, error) { return in, nil }); err == nil {
+		t.Error("Expected an error for a non-struct input")
+	}
+}
+
+func TestOutputRenderer(t *testing.T) {
+	list := func() []string { return []string{"milk", "eggs"} }
+	bullets := func(output any) string {
+		var b strings.Builder
+		for _, item := range output.([]string) {
+			fmt.Fprintf(&b, "- %s\n", item)
+		}
+		return b.String()
+	}
+
+	plain, err := NewFunctionTool("list", "Lists groceries", list)
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	rendered, err := NewFunctionTool("list", "Lists groceries", list, WithOutputRenderer(bullets))
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+
+	output := []string{"milk", "eggs"}
+	if text := RenderOutput(plain, output); text != "[milk eggs]" {
+		t.Errorf("Expected the default rendering '[milk eggs]', got %q", text)
+	}
+	if text := RenderOutput(rendered, output); text != "- milk\n- eggs\n" {
+		t.Errorf("Expected a bullet list, got %q", text)
+	}
+	if text := RenderOutput(nil, 42); text != "42" {
+		t.Errorf("Expected '42' without a tool, got %q", text)
+	}
+}
+
+// cannedAgent is a sub-agent answering every task with the same answer.
+type cannedAgent struct {
+	answer any
+	err    error
+	tasks  []string
+}
+
+func (a *cannedAgent) Run(ctx context.Context, task string) (any, error) {
+	a.tasks = append(a.tasks, task)
+	return a.answer, a.err
+}
+
+func TestAgentTool(t *testing.T) {
+	researcher := &cannedAgent{answer: "Paris is the capital of France."}
+	tool := AgentTool(researcher, "researcher", "Researches facts")
+
+	if tool.Name() != "researcher" || tool.Description() != "Researches facts" {
+		t.Errorf("Unexpected name %q or description %q", tool.Name(), tool.Description())
+	}
+	if required := tool.Schema().Required; !reflect.DeepEqual(required, []string{"task"}) {
+		t.Errorf("Expected the task argument to be required, got %v", required)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"task": "What is the capital of France?"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result != "Paris is the capital of France." {
+		t.Errorf("Expected the sub-agent's answer, got %v", result)
+	}
+	if !reflect.DeepEqual(researcher.tasks, []string{"What is the capital of France?"}) {
+		t.Errorf("Expected the sub-agent to run on the task, got %v", researcher.tasks)
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]any{}); err == nil {
+		t.Error("Expected an error without a task")
+	}
+
+	failing := AgentTool(&cannedAgent{err: errors.New("no sources")}, "researcher", "Researches facts")
+	if _, err := failing.Execute(context.Background(), map[string]any{"task": "Anything"}); err == nil || !strings.Contains(err.Error(), "agent researcher failed: no sources") {
+		t.Errorf("Expected the sub-agent's error, got %v", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	var calls int
+	plain, err := NewFunctionTool("add", "Adds two numbers", func(a, b int) int {
+		calls++
+		return a + b
+	})
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	waiting, err := NewFunctionTool("wait", "Waits for the context", func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("NewFunctionTool() error = %v", err)
+	}
+	type input struct {
+		Query string `json:"query"`
+	}
+	structTool, err := NewStructTool("search", "Searches", func(ctx context.Context, in input) (string, error) {
+		calls++
+		return in.Query, nil
+	})
+	if err != nil {
+		t.Fatalf("NewStructTool() error = %v", err)
+	}
+
+	// A cancelled context stops tools before they run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := plain.Execute(ctx, map[string]any{"arg0": 1, "arg1": 2}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from the function tool, got %v", err)
+	}
+	if _, err := structTool.Execute(ctx, map[string]any{"query": "go"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from the struct tool, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no tool to run, got %d calls", calls)
+	}
+
+	// A deadline reaches a running tool through its context parameter
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := waiting.Execute(ctx, map[string]any{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestToolbox(t *testing.T) {
+	newTool := func(name string) Tool {
+		tool, err := NewFunctionTool(name, "Returns its name", func() string { return name })
+		if err != nil {
+			t.Fatalf("NewFunctionTool() error = %v", err)
+		}
+		return tool
+	}
+
+	if _, err := NewToolbox("web", newTool("search"), newTool("search")); err == nil || !strings.Contains(err.Error(), "duplicate tool name: search") {
+		t.Errorf("Expected a duplicate tool name error from NewToolbox, got %v", err)
+	}
+
+	web, err := NewToolbox("web", newTool("search"), newTool("fetch"))
+	if err != nil {
+		t.Fatalf("NewToolbox() error = %v", err)
+	}
+
+	// Add rejects a name collision
+	if err := web.Add(newTool("search")); err == nil || !strings.Contains(err.Error(), "duplicate tool name: search") {
+		t.Errorf("Expected a duplicate tool name error from Add, got %v", err)
+	}
+	if err := web.Add(newTool("summarize")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if names := web.List(); !reflect.DeepEqual(names, []string{"search", "fetch", "summarize"}) {
+		t.Errorf("Expected tools in insertion order, got %v", names)
+	}
+
+	if tool, ok := web.Get("fetch"); !ok || tool.Name() != "fetch" {
+		t.Errorf("Expected Get to find fetch, got %v", tool)
+	}
+	if _, ok := web.Get("missing"); ok {
+		t.Error("Expected Get to report a missing tool")
+	}
+
+	if !web.Remove("summarize") || web.Remove("summarize") {
+		t.Error("Expected Remove to remove summarize exactly once")
+	}
+
+	// Merge rejects a collision without adding any tool
+	files, err := NewToolbox("files", newTool("read"), newTool("fetch"))
+	if err != nil {
+		t.Fatalf("NewToolbox() error = %v", err)
+	}
+	if err := web.Merge(files); err == nil || !strings.Contains(err.Error(), "duplicate tool name: fetch") {
+		t.Errorf("Expected a duplicate tool name error from Merge, got %v", err)
+	}
+	if names := web.List(); !reflect.DeepEqual(names, []string{"search", "fetch"}) {
+		t.Errorf("Expected a failed Merge to add nothing, got %v", names)
+	}
+
+	files.Remove("fetch")
+	if err := web.Merge(files); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	all := web.Tools()
+	if len(all) != 3 || all[2].Name() != "read" {
+		t.Errorf("Expected the merged tools, got %v", web.List())
+	}
+	all[0] = nil
+	if tool, _ := web.Get("search"); tool == nil {
+		t.Error("Expected Tools to return a copy")
+	}
+}